@@ -12,6 +12,8 @@ import (
 	"irrigation-system/backend/internal/database"
 	"irrigation-system/backend/internal/handler"
 	"irrigation-system/backend/internal/middleware"
+	"irrigation-system/backend/internal/repository"
+	"irrigation-system/backend/internal/scheduler"
 	"irrigation-system/backend/internal/service"
 	"irrigation-system/backend/internal/weather"
 )
@@ -48,13 +50,16 @@ func main() {
 		}
 		log.Println("Database schema initialized successfully")
 	}
+	if err := db.Migrate(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
 
-	// Initialize weather client
-	weatherClient := weather.NewQWeatherClient(
-		cfg.Weather.APIHost,
-		cfg.Weather.APIKey,
-		cfg.Weather.Timeout,
-	)
+	// Initialize weather provider
+	weatherClient, err := weather.NewProvider(cfg.Weather)
+	if err != nil {
+		log.Fatalf("Failed to initialize weather provider: %v", err)
+	}
+	log.Printf("Weather provider: %s", weatherClient.Name())
 
 	// Initialize service
 	svc := service.NewService(cfg, db.DB, weatherClient)
@@ -64,11 +69,53 @@ func main() {
 	log.Printf("JWT auth initialized (expire: %dh)", cfg.Security.JWTExpireHours)
 
 	// Initialize device API auth
-	middleware.InitDeviceAuth(cfg.Security.DeviceAPIKey)
-	log.Printf("Device API auth initialized")
+	middleware.InitDeviceAuth(svc.AuthenticateDevice)
+	middleware.InitDeviceSigning(svc.DeviceSigningKeys, svc.RecordDeviceNonce, cfg.Security.DeviceSignature == "required", cfg.Security.DeviceSignatureSkew)
+	log.Printf("Device API auth initialized (signature: %s, max skew: %s)", cfg.Security.DeviceSignature, cfg.Security.DeviceSignatureSkew)
+	if cfg.Security.SharedDeviceKey && cfg.Security.DeviceAPIKey != "" {
+		log.Printf("WARNING: shared device key enabled for registered devices without their own key")
+	} else if cfg.Security.DeviceAPIKey != "" {
+		log.Printf("Shared device key disabled (security.shared_device_key), device_api_key is ignored")
+	}
+
+	// Initialize scheduler
+	sched := scheduler.NewScheduler(repository.NewJobRunRepository(db.DB))
+	if err := sched.Register("forecast_refresh", cfg.Scheduler.ForecastRefresh, svc.RefreshAllForecasts); err != nil {
+		log.Fatalf("Failed to register forecast_refresh job: %v", err)
+	}
+	if err := sched.Register("plan_recompute", cfg.Scheduler.PlanRecompute, svc.RecomputeAllPlans); err != nil {
+		log.Fatalf("Failed to register plan_recompute job: %v", err)
+	}
+	if err := sched.Register("plan_execute", cfg.Scheduler.PlanExecute, svc.ExecutePlans); err != nil {
+		log.Fatalf("Failed to register plan_execute job: %v", err)
+	}
+	if err := sched.Register("plan_drift_check", cfg.Scheduler.DriftCheck, svc.CheckPlanDrift); err != nil {
+		log.Fatalf("Failed to register plan_drift_check job: %v", err)
+	}
+	if err := sched.Register("planner_calibration", cfg.Scheduler.Calibration, svc.CalibrateAllDevices); err != nil {
+		log.Fatalf("Failed to register planner_calibration job: %v", err)
+	}
+	if err := sched.Register("volume_store", cfg.Scheduler.VolumeStore, svc.StoreExecutedVolumes); err != nil {
+		log.Fatalf("Failed to register volume_store job: %v", err)
+	}
+	// 超时命令的定期检查不依赖 cron，这里注册后也可手动触发
+	if err := sched.Register("command_sweep", "", svc.SweepCommands); err != nil {
+		log.Fatalf("Failed to register command_sweep job: %v", err)
+	}
+	stopSweeper := svc.StartCommandSweeper()
+	defer stopSweeper()
+	log.Printf("Command sweeper started (interval: %s)", cfg.Commands.SweepInterval)
+
+	if cfg.Scheduler.Enabled {
+		sched.Start()
+		defer sched.Stop()
+		log.Printf("Scheduler started")
+	} else {
+		log.Printf("Scheduler disabled, jobs can only be triggered manually")
+	}
 
 	// Initialize handler
-	h := handler.NewHandler(svc)
+	h := handler.NewHandler(svc, sched)
 
 	// Setup Gin router
 	r := gin.Default()
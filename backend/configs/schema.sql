@@ -14,28 +14,42 @@ CREATE TABLE IF NOT EXISTS sensor_data (
 
 CREATE INDEX IF NOT EXISTS idx_sensor_timestamp ON sensor_data(device_id, timestamp DESC);
 
--- 天气预报表
+-- 天气预报表（按位置存储，location_key 为保留两位小数的 "纬度,经度"）
 CREATE TABLE IF NOT EXISTS rain_forecast (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
-    date TEXT NOT NULL UNIQUE,
+    location_key TEXT NOT NULL DEFAULT '',
+    latitude REAL,
+    longitude REAL,
+    date TEXT NOT NULL,
     temp_max REAL,
     temp_min REAL,
     precip_mm REAL,
     humidity_pct REAL,
+    precip_prob_pct REAL,          -- 降水概率 (%)
     raw_json TEXT,
-    created_at TEXT NOT NULL
+    created_at TEXT NOT NULL,
+    UNIQUE(location_key, date)
 );
 
 CREATE INDEX IF NOT EXISTS idx_forecast_date ON rain_forecast(date);
 
--- 灌溉计划表
+-- 灌溉计划表（每次重新计算生成一个新版本，旧版本保留）
 CREATE TABLE IF NOT EXISTS irrigation_plan (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     device_id TEXT NOT NULL,
     date TEXT NOT NULL,
+    version_id INTEGER NOT NULL DEFAULT 0,  -- plan_versions.id，0 为版本化之前的计划
     planned_volume_l REAL,
+    start_moisture REAL,      -- 预测当日开始湿度
+    predicted_moisture REAL,  -- 预测当日结束湿度
+    et_l REAL,                -- 预测蒸散量（升）
+    rain_l REAL,              -- 预测降雨补充（升）
+    cost_deviation REAL,      -- 代价：湿度偏差
+    cost_water REAL,          -- 代价：用水量
+    cost_smoothness REAL,     -- 代价：变化平滑
+    locked INTEGER NOT NULL DEFAULT 0,  -- 灌溉量由人工指定
     created_at TEXT NOT NULL,
-    UNIQUE(device_id, date)
+    UNIQUE(device_id, date, version_id)
 );
 
 CREATE INDEX IF NOT EXISTS idx_plan_device_date ON irrigation_plan(device_id, date);
@@ -74,6 +88,13 @@ CREATE TABLE IF NOT EXISTS devices (
     device_id TEXT UNIQUE NOT NULL,
     user_id INTEGER,
     device_name TEXT NOT NULL,
+    api_key_hash TEXT,                 -- 设备专属API密钥的SHA-256，未分配时为空
+    api_key_issued_at TEXT,
+    previous_api_key_hash TEXT,        -- 轮换前的旧密钥，宽限期内仍可使用
+    previous_api_key_expires_at TEXT,
+    api_key_revoked_at TEXT,           -- 吊销后设备无法认证，直到重新分配密钥
+    api_signing_secret TEXT,           -- 由密钥派生的请求签名密钥，用服务器密钥加密存储
+    previous_api_signing_secret TEXT,
     created_at TEXT NOT NULL,
     updated_at TEXT NOT NULL,
     FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
@@ -82,6 +103,14 @@ CREATE TABLE IF NOT EXISTS devices (
 CREATE INDEX IF NOT EXISTS idx_devices_user ON devices(user_id);
 CREATE INDEX IF NOT EXISTS idx_devices_device_id ON devices(device_id);
 
+-- 签名请求已使用的随机数，保留到签名时间戳超出允许范围，用于拒绝重放
+CREATE TABLE IF NOT EXISTS device_nonces (
+    device_id TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    expires_at INTEGER NOT NULL,           -- Unix 秒
+    PRIMARY KEY (device_id, nonce)
+);
+
 -- 设备位置表
 CREATE TABLE IF NOT EXISTS device_locations (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -97,12 +126,211 @@ CREATE TABLE IF NOT EXISTS device_locations (
 CREATE TABLE IF NOT EXISTS device_commands (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     device_id TEXT NOT NULL,
-    command_type TEXT NOT NULL,  -- 'irrigate', 'update_config', 'toggle_shade'
+    command_type TEXT NOT NULL,  -- 'irrigate', 'set_shade', 'update_config', 'reboot', 'ota_update'
     parameters TEXT,              -- JSON格式参数，如: {"duration_minutes": 5}
-    status TEXT DEFAULT 'pending', -- 'pending', 'executing', 'completed', 'failed'
+    status TEXT DEFAULT 'pending', -- 'pending', 'delivered', 'executing', 'completed', 'failed', 'expired', 'cancelled'
     created_at TEXT NOT NULL,
     executed_at TEXT,
-    result TEXT                   -- 执行结果或错误信息
+    result TEXT,                  -- 执行结果或错误信息
+    plan_id INTEGER,              -- 由灌溉计划自动下发时关联的 irrigation_plan.id
+    attempts INTEGER NOT NULL DEFAULT 0, -- 已下发次数，含重新下发
+    delivered_at TEXT,            -- 最近一次下发时间
+    updated_at TEXT,              -- 最近一次状态变更时间
+    idempotency_key TEXT,         -- 设备上报状态时回传，用于识别重复下发
+    issued_by TEXT                -- 下发命令的用户名，计划自动下发为 'plan_executor'
 );
 
 CREATE INDEX IF NOT EXISTS idx_command_status ON device_commands(device_id, status);
+
+-- 定时任务执行记录表
+CREATE TABLE IF NOT EXISTS job_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    job_name TEXT NOT NULL,
+    trigger_type TEXT NOT NULL,   -- 'schedule', 'manual'
+    started_at TEXT NOT NULL,
+    finished_at TEXT,
+    status TEXT NOT NULL,         -- 'running', 'success', 'failed'
+    message TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_runs_name ON job_runs(job_name, started_at DESC);
+
+-- 定时任务状态表（暂停状态在重启后保留）
+CREATE TABLE IF NOT EXISTS job_states (
+    job_name TEXT PRIMARY KEY,
+    paused INTEGER NOT NULL DEFAULT 0,
+    updated_at TEXT NOT NULL
+);
+
+-- 设备浇水时间窗口表（计划自动执行）
+CREATE TABLE IF NOT EXISTS watering_schedules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id TEXT UNIQUE NOT NULL,
+    windows TEXT NOT NULL,        -- 逗号分隔的 HH:MM，如 "06:00,18:00"
+    enabled INTEGER NOT NULL DEFAULT 1,
+    updated_at TEXT NOT NULL
+);
+
+-- 计划下发记录表（每个设备每天每个窗口最多一条）
+CREATE TABLE IF NOT EXISTS plan_dispatches (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id TEXT NOT NULL,
+    date TEXT NOT NULL,
+    window TEXT NOT NULL,
+    plan_id INTEGER,
+    command_id INTEGER,
+    volume_l REAL NOT NULL DEFAULT 0,
+    status TEXT NOT NULL,         -- 'dispatched', 'skipped_rain'
+    created_at TEXT NOT NULL,
+    UNIQUE(device_id, date, window)
+);
+
+-- 每日实际灌溉水量表
+CREATE TABLE IF NOT EXISTS irrigation_volume_daily (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id TEXT NOT NULL,
+    date TEXT NOT NULL,
+    command_volume_l REAL NOT NULL DEFAULT 0,
+    pump_runtime_sec REAL NOT NULL DEFAULT 0,
+    pump_volume_l REAL NOT NULL DEFAULT 0,
+    executed_volume_l REAL NOT NULL DEFAULT 0,
+    updated_at TEXT NOT NULL,
+    UNIQUE(device_id, date)
+);
+
+-- 设备灌溉规划参数表（未配置的设备使用 config.yaml 中的默认参数）
+CREATE TABLE IF NOT EXISTS device_planner_profiles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id TEXT UNIQUE NOT NULL,
+    crop TEXT,
+    soil_type TEXT,
+    pot_size_l REAL,
+    soil_optimal_min INTEGER NOT NULL,
+    soil_optimal_max INTEGER NOT NULL,
+    max_irrigation_per_day REAL NOT NULL,
+    base_et REAL NOT NULL,
+    temp_factor REAL NOT NULL,
+    rain_conversion REAL NOT NULL,
+    adc_to_moisture REAL NOT NULL,
+    cost_w1 REAL NOT NULL,
+    cost_w2 REAL NOT NULL,
+    cost_w3 REAL NOT NULL,
+    et_model TEXT NOT NULL DEFAULT 'linear',  -- 'linear', 'fao56'
+    canopy_area_m2 REAL NOT NULL DEFAULT 0.5,
+    planting_date TEXT,
+    volume_budget_l REAL NOT NULL DEFAULT 0,       -- 规划期内总灌溉量上限，0 表示不限
+    allowed_weekdays TEXT,                         -- 允许浇水的星期，如 'mon,wed,sat'
+    min_days_between INTEGER NOT NULL DEFAULT 0,   -- 两个浇水日之间至少间隔的天数
+    moisture_floor INTEGER NOT NULL DEFAULT 0,     -- 预测湿度下限
+    risk_aversion REAL NOT NULL DEFAULT 0,         -- 降雨不确定时的风险厌恶系数
+    updated_at TEXT NOT NULL,
+    FOREIGN KEY (device_id) REFERENCES devices(device_id) ON DELETE CASCADE
+);
+
+-- 计划预测轨迹表（每次计算计划记录一条，用于对比预测与实际湿度）
+CREATE TABLE IF NOT EXISTS plan_trajectories (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id TEXT NOT NULL,
+    reason TEXT NOT NULL,          -- 'manual', 'schedule', 'drift'
+    start_moisture INTEGER NOT NULL,
+    drift REAL,                    -- 触发重新规划时的偏差
+    created_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_trajectory_device ON plan_trajectories(device_id, created_at DESC);
+
+-- 预测轨迹明细（每天结束时的预测湿度）
+CREATE TABLE IF NOT EXISTS plan_trajectory_points (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    trajectory_id INTEGER NOT NULL,
+    date TEXT NOT NULL,
+    predicted_moisture REAL NOT NULL,
+    planned_volume_l REAL NOT NULL,
+    FOREIGN KEY (trajectory_id) REFERENCES plan_trajectories(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_trajectory_point ON plan_trajectory_points(trajectory_id, date);
+
+-- 规划参数标定记录表（管理员审核后应用到设备参数）
+CREATE TABLE IF NOT EXISTS calibration_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',  -- 'pending', 'applied', 'rejected'
+    start_date TEXT NOT NULL,
+    end_date TEXT NOT NULL,
+    samples INTEGER NOT NULL,
+    weather_source TEXT NOT NULL,
+    base_et REAL NOT NULL,
+    temp_factor REAL NOT NULL,
+    rain_conversion REAL NOT NULL,
+    adc_to_moisture REAL NOT NULL,
+    fixed_params TEXT,                        -- 逗号分隔
+    r_squared REAL NOT NULL,
+    rmse REAL NOT NULL,
+    baseline_rmse REAL NOT NULL,
+    created_at TEXT NOT NULL,
+    reviewed_at TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_calibration_device ON calibration_runs(device_id, created_at DESC);
+
+-- 人工指定的计划灌溉量（重新规划时作为固定决策）
+CREATE TABLE IF NOT EXISTS plan_overrides (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id TEXT NOT NULL,
+    date TEXT NOT NULL,
+    volume_l REAL NOT NULL,       -- 0 表示当天不浇水
+    reason TEXT,
+    created_by TEXT,
+    created_at TEXT NOT NULL,
+    UNIQUE(device_id, date)
+);
+
+-- 计划版本表（每次重新计算记录触发原因和输入）
+CREATE TABLE IF NOT EXISTS plan_versions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id TEXT NOT NULL,
+    version INTEGER NOT NULL,              -- 设备内递增的版本号
+    reason TEXT NOT NULL,                  -- 'manual', 'schedule', 'drift', 'override'
+    soil_reading INTEGER NOT NULL,         -- 输入的土壤湿度读数
+    soil_reading_at TEXT,
+    drift REAL,
+    forecast_snapshot_id INTEGER,
+    params TEXT NOT NULL,                  -- 规划参数 (JSON)
+    days INTEGER NOT NULL,
+    total_volume_l REAL NOT NULL,
+    total_cost REAL NOT NULL,
+    created_at TEXT NOT NULL,
+    UNIQUE(device_id, version)
+);
+
+-- 预报快照表（计算计划时使用的预报，内容相同时复用）
+CREATE TABLE IF NOT EXISTS forecast_snapshots (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    location_key TEXT NOT NULL,
+    days TEXT NOT NULL,                    -- 预报逐日数据 (JSON)
+    created_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_forecast_snapshot_location ON forecast_snapshots(location_key, id DESC);
+
+-- 设备配置孪生：期望配置与设备上报的实际配置，不一致时自动下发 update_config
+CREATE TABLE IF NOT EXISTS device_config (
+    device_id TEXT PRIMARY KEY,
+    desired TEXT,                          -- 期望配置 (JSON)，未设置的项不管理
+    desired_updated_at TEXT,
+    desired_updated_by TEXT,
+    reported TEXT,                         -- 设备最近一次上报的配置 (JSON)
+    reported_at TEXT,
+    last_command_id INTEGER                -- 最近一次为收敛下发的命令
+);
+
+-- 未注册设备上报的数据，等待管理员认领或丢弃
+CREATE TABLE IF NOT EXISTS device_quarantine (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_id TEXT NOT NULL,
+    payload TEXT NOT NULL,                 -- 设备上报的原始数据 (JSON)
+    received_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_quarantine_device ON device_quarantine(device_id, received_at);
@@ -9,12 +9,18 @@ import (
 )
 
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Database DatabaseConfig `yaml:"database"`
-	Weather  WeatherConfig  `yaml:"weather"`
-	Planner  PlannerConfig  `yaml:"planner"`
-	Logging  LoggingConfig  `yaml:"logging"`
-	Security SecurityConfig `yaml:"security"`
+	Server      ServerConfig      `yaml:"server"`
+	Database    DatabaseConfig    `yaml:"database"`
+	Weather     WeatherConfig     `yaml:"weather"`
+	Planner     PlannerConfig     `yaml:"planner"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	Security    SecurityConfig    `yaml:"security"`
+	Scheduler   SchedulerConfig   `yaml:"scheduler"`
+	Executor    ExecutorConfig    `yaml:"executor"`
+	Commands    CommandsConfig    `yaml:"commands"`
+	Accounting  AccountingConfig  `yaml:"accounting"`
+	ClosedLoop  ClosedLoopConfig  `yaml:"closed_loop"`
+	Calibration CalibrationConfig `yaml:"calibration"`
 }
 
 type ServerConfig struct {
@@ -34,6 +40,23 @@ type WeatherConfig struct {
 	Endpoint        string              `yaml:"endpoint"`
 	Timeout         time.Duration       `yaml:"timeout"`
 	DefaultLocation DefaultLocationInfo `yaml:"default_location"`
+	Provider        string              `yaml:"provider"` // qweather, open_meteo, file
+	Fallback        []string            `yaml:"fallback"` // 主数据源失败时依次尝试
+	OpenMeteo       OpenMeteoConfig     `yaml:"open_meteo"`
+	File            FileProviderConfig  `yaml:"file"`
+	CacheTTL        time.Duration       `yaml:"cache_ttl"`     // 相同位置的预报缓存时间
+	DailyBudget     int                 `yaml:"daily_budget"`  // 和风天气每日请求上限，0 表示不限
+	MaxRetries      int                 `yaml:"max_retries"`   // 429/5xx 重试次数
+	RetryBackoff    time.Duration       `yaml:"retry_backoff"` // 首次重试等待时间
+}
+
+type OpenMeteoConfig struct {
+	ForecastURL string `yaml:"forecast_url"`
+	ArchiveURL  string `yaml:"archive_url"`
+}
+
+type FileProviderConfig struct {
+	Path string `yaml:"path"` // JSON 或 CSV 文件
 }
 
 type DefaultLocationInfo struct {
@@ -52,6 +75,68 @@ type PlannerConfig struct {
 	CostW1              float64 `yaml:"cost_w1"`
 	CostW2              float64 `yaml:"cost_w2"`
 	CostW3              float64 `yaml:"cost_w3"`
+	ETModel             string  `yaml:"et_model"`       // linear, fao56
+	CanopyAreaM2        float64 `yaml:"canopy_area_m2"` // fao56 模型的蒸散面积
+	MoistureStep        int     `yaml:"moisture_step"`  // DP湿度网格步长 (ADC值)
+	VolumeStep          float64 `yaml:"volume_step"`    // DP灌溉量步长 (升)
+	RiskAversion        float64 `yaml:"risk_aversion"`  // 降雨不确定时的风险厌恶系数
+	EnsembleSize        int     `yaml:"ensemble_size"`  // 降雨情景采样数
+
+	// 硬约束，零值表示不限制
+	VolumeBudgetL   float64  `yaml:"volume_budget_l"`  // 规划期内总灌溉量上限 (升)
+	AllowedWeekdays []string `yaml:"allowed_weekdays"` // 允许浇水的星期: sun, mon, ..., sat
+	MinDaysBetween  int      `yaml:"min_days_between"` // 两个浇水日之间至少间隔的天数
+	MoistureFloor   int      `yaml:"moisture_floor"`   // 预测湿度下限 (ADC值)
+}
+
+// SchedulerConfig contains background job schedules (cron format: 分 时 日 月 周)
+type SchedulerConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	ForecastRefresh string `yaml:"forecast_refresh"` // 刷新所有设备位置的天气预报
+	PlanRecompute   string `yaml:"plan_recompute"`   // 重新计算所有设备的灌溉计划
+	PlanExecute     string `yaml:"plan_execute"`     // 按浇水窗口下发计划灌溉命令
+	DriftCheck      string `yaml:"drift_check"`      // 对比预测与实测湿度，偏差过大时重新规划
+	Calibration     string `yaml:"calibration"`      // 根据历史数据标定各设备的规划参数
+	VolumeStore     string `yaml:"volume_store"`     // 保存各设备昨天和今天的实际灌溉水量
+}
+
+// ExecutorConfig controls automatic dispatch of planned irrigation
+type ExecutorConfig struct {
+	DefaultWindows      []string `yaml:"default_windows"`       // 设备未单独配置时的浇水窗口 (HH:MM)
+	GraceMinutes        int      `yaml:"grace_minutes"`         // 窗口开始后多长时间内仍可补发
+	MinPulseVolumeL     float64  `yaml:"min_pulse_volume_l"`    // 单次脉冲最小水量
+	RainLookbackMinutes int      `yaml:"rain_lookback_minutes"` // 降雨传感器数据的有效时间
+}
+
+// CommandsConfig controls the lifecycle of device commands
+type CommandsConfig struct {
+	PendingTTL      time.Duration `yaml:"pending_ttl"`      // 未被设备取走的命令的有效期
+	DeliveryTimeout time.Duration `yaml:"delivery_timeout"` // 下发后等待设备确认的时间，超时后重新下发或置为 expired
+	ExecutingTTL    time.Duration `yaml:"executing_ttl"`    // 执行中的命令等待结果的最长时间
+	MaxRetries      int           `yaml:"max_retries"`      // 设备未确认时的最多重新下发次数（浇水命令不重新下发）
+	SweepInterval   time.Duration `yaml:"sweep_interval"`   // 检查超时命令的间隔
+
+	ConfigSyncInterval time.Duration `yaml:"config_sync_interval"` // 设备配置未收敛时两次下发 update_config 的最小间隔
+}
+
+// AccountingConfig controls how executed irrigation volume is derived
+type AccountingConfig struct {
+	PumpFlowRateLPerMin float64 `yaml:"pump_flow_rate_l_per_min"` // 水泵流量（升/分钟）
+	MaxSampleGapMinutes int     `yaml:"max_sample_gap_minutes"`   // 两次上报间隔超过该值时不计入水泵运行时长
+	Method              string  `yaml:"method"`                   // command, pump, max
+}
+
+// ClosedLoopConfig controls replanning when observed moisture drifts from the prediction
+type ClosedLoopConfig struct {
+	Enabled          bool `yaml:"enabled"`
+	DriftThreshold   int  `yaml:"drift_threshold"`    // 实测与预测湿度偏差阈值 (ADC值)
+	MinReplanMinutes int  `yaml:"min_replan_minutes"` // 两次偏差触发的重新规划之间的最小间隔
+}
+
+// CalibrationConfig controls fitting of planner coefficients from history
+type CalibrationConfig struct {
+	LookbackDays int `yaml:"lookback_days"` // 使用最近多少天的数据
+	MinSamples   int `yaml:"min_samples"`   // 最少有效天数
 }
 
 type LoggingConfig struct {
@@ -65,7 +150,17 @@ type SecurityConfig struct {
 	JWTExpireHours     int      `yaml:"jwt_expire_hours"`
 	AllowedOrigins     []string `yaml:"allowed_origins"`
 	RateLimitPerMinute int      `yaml:"rate_limit_per_minute"`
-	DeviceAPIKey       string   `yaml:"device_api_key"`
+	DeviceAPIKey       string   `yaml:"device_api_key"`    // 未分配专属密钥的设备使用的共享密钥，留空则禁用
+	SharedDeviceKey    bool     `yaml:"shared_device_key"` // 是否接受共享密钥，仅限已注册且未分配专属密钥的设备
+	DeviceSecretKey    string   `yaml:"device_secret_key"` // 加密存储设备签名密钥的服务器密钥，留空时使用 jwt_secret
+
+	DeviceKeyGracePeriod time.Duration `yaml:"device_key_grace_period"` // 轮换设备密钥后旧密钥继续有效的时间
+	DeviceSignature      string        `yaml:"device_signature"`        // optional: 接受签名请求和明文密钥；required: 只接受签名请求
+	DeviceSignatureSkew  time.Duration `yaml:"device_signature_skew"`   // 签名时间戳与服务器时间的最大偏差
+	UnknownDevices       string        `yaml:"unknown_devices"`         // 未注册设备的上报数据: quarantine 隔离待认领, reject 直接拒绝
+	QuarantineLimit      int           `yaml:"quarantine_limit"`        // 每个未注册设备最多保留的隔离数据条数
+	QuarantineMaxDevices int           `yaml:"quarantine_max_devices"`  // 隔离区最多容纳的未注册设备数，超出后拒绝新设备
+	QuarantineRate       int           `yaml:"quarantine_rate"`         // 每个来源IP每分钟最多写入隔离区的次数
 }
 
 // Load loads configuration from file
@@ -87,6 +182,9 @@ func Load(path string) (*Config, error) {
 	if deviceAPIKey := os.Getenv("DEVICE_API_KEY"); deviceAPIKey != "" {
 		cfg.Security.DeviceAPIKey = deviceAPIKey
 	}
+	if deviceSecretKey := os.Getenv("DEVICE_SECRET_KEY"); deviceSecretKey != "" {
+		cfg.Security.DeviceSecretKey = deviceSecretKey
+	}
 
 	// 验证必要的安全配置
 	if err := cfg.Validate(); err != nil {
@@ -110,6 +208,137 @@ func (c *Config) Validate() error {
 	if c.Security.RateLimitPerMinute <= 0 {
 		c.Security.RateLimitPerMinute = 10 // 默认每分钟10次
 	}
+	if c.Security.DeviceSecretKey != "" && len(c.Security.DeviceSecretKey) < 32 {
+		return fmt.Errorf("DEVICE_SECRET_KEY must be at least 32 characters")
+	}
+	if c.Security.DeviceKeyGracePeriod < 0 {
+		return fmt.Errorf("security device_key_grace_period must not be negative")
+	}
+	if c.Security.DeviceKeyGracePeriod == 0 {
+		c.Security.DeviceKeyGracePeriod = 24 * time.Hour
+	}
+	switch c.Security.DeviceSignature {
+	case "":
+		c.Security.DeviceSignature = "optional"
+	case "optional", "required":
+	default:
+		return fmt.Errorf("security device_signature must be optional or required, got %q", c.Security.DeviceSignature)
+	}
+	if c.Security.DeviceSignatureSkew <= 0 {
+		c.Security.DeviceSignatureSkew = 5 * time.Minute
+	}
+	switch c.Security.UnknownDevices {
+	case "":
+		c.Security.UnknownDevices = "quarantine"
+	case "quarantine", "reject":
+	default:
+		return fmt.Errorf("security unknown_devices must be quarantine or reject, got %q", c.Security.UnknownDevices)
+	}
+	if c.Security.QuarantineLimit <= 0 {
+		c.Security.QuarantineLimit = 1000
+	}
+	if c.Security.QuarantineMaxDevices <= 0 {
+		c.Security.QuarantineMaxDevices = 50
+	}
+	if c.Security.QuarantineRate <= 0 {
+		c.Security.QuarantineRate = 10
+	}
+	if len(c.Executor.DefaultWindows) == 0 {
+		c.Executor.DefaultWindows = []string{"06:00"}
+	}
+	for _, w := range c.Executor.DefaultWindows {
+		if _, err := time.Parse("15:04", w); err != nil {
+			return fmt.Errorf("invalid executor default window %q, expected HH:MM", w)
+		}
+	}
+	if c.Executor.GraceMinutes <= 0 {
+		c.Executor.GraceMinutes = 60
+	}
+	if c.Executor.MinPulseVolumeL <= 0 {
+		c.Executor.MinPulseVolumeL = 0.5
+	}
+	if c.Executor.RainLookbackMinutes <= 0 {
+		c.Executor.RainLookbackMinutes = 30
+	}
+	if c.Commands.PendingTTL <= 0 {
+		c.Commands.PendingTTL = 30 * time.Minute
+	}
+	if c.Commands.DeliveryTimeout <= 0 {
+		c.Commands.DeliveryTimeout = 2 * time.Minute
+	}
+	if c.Commands.ExecutingTTL <= 0 {
+		c.Commands.ExecutingTTL = 30 * time.Minute
+	}
+	if c.Commands.MaxRetries < 0 {
+		return fmt.Errorf("commands max_retries must not be negative")
+	}
+	if c.Commands.SweepInterval <= 0 {
+		c.Commands.SweepInterval = 30 * time.Second
+	}
+	if c.Commands.ConfigSyncInterval <= 0 {
+		c.Commands.ConfigSyncInterval = 5 * time.Minute
+	}
+	if c.Accounting.PumpFlowRateLPerMin <= 0 {
+		c.Accounting.PumpFlowRateLPerMin = 30 // 与固件 FLOW_RATE 0.5L/s 一致
+	}
+	if c.Accounting.MaxSampleGapMinutes <= 0 {
+		c.Accounting.MaxSampleGapMinutes = 10
+	}
+	if c.Planner.ETModel == "" {
+		c.Planner.ETModel = "linear"
+	}
+	if c.Planner.CanopyAreaM2 <= 0 {
+		c.Planner.CanopyAreaM2 = 0.5
+	}
+	if c.Planner.MoistureStep <= 0 {
+		c.Planner.MoistureStep = 10
+	}
+	if c.Planner.VolumeStep <= 0 {
+		c.Planner.VolumeStep = 0.5
+	}
+	if c.Planner.EnsembleSize <= 0 {
+		c.Planner.EnsembleSize = 16
+	}
+	if c.Planner.VolumeStep < 0.05 || c.Planner.MoistureStep > 500 {
+		return fmt.Errorf("planner volume_step must be at least 0.05 and moisture_step at most 500")
+	}
+	if c.Calibration.LookbackDays <= 0 {
+		c.Calibration.LookbackDays = 60
+	}
+	if c.Calibration.MinSamples <= 0 {
+		c.Calibration.MinSamples = 10
+	}
+	if c.ClosedLoop.DriftThreshold <= 0 {
+		c.ClosedLoop.DriftThreshold = 300
+	}
+	if c.ClosedLoop.MinReplanMinutes <= 0 {
+		c.ClosedLoop.MinReplanMinutes = 60
+	}
+	if c.Weather.CacheTTL == 0 {
+		c.Weather.CacheTTL = time.Hour
+	}
+	if c.Weather.RetryBackoff == 0 {
+		c.Weather.RetryBackoff = time.Second
+	}
+	if c.Weather.DailyBudget < 0 || c.Weather.MaxRetries < 0 {
+		return fmt.Errorf("weather daily_budget and max_retries must not be negative")
+	}
+	if c.Weather.Provider == "" {
+		c.Weather.Provider = "qweather"
+	}
+	if c.Weather.OpenMeteo.ForecastURL == "" {
+		c.Weather.OpenMeteo.ForecastURL = "https://api.open-meteo.com/v1/forecast"
+	}
+	if c.Weather.OpenMeteo.ArchiveURL == "" {
+		c.Weather.OpenMeteo.ArchiveURL = "https://archive-api.open-meteo.com/v1/archive"
+	}
+	switch c.Accounting.Method {
+	case "":
+		c.Accounting.Method = "max"
+	case "command", "pump", "max":
+	default:
+		return fmt.Errorf("invalid accounting method %q, must be command, pump or max", c.Accounting.Method)
+	}
 	return nil
 }
 
@@ -0,0 +1,268 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// migration upgrades a table created by an older schema.sql. It is applied
+// when the table exists but lacks the marker column; all statements run in
+// one transaction.
+type migration struct {
+	table      string
+	column     string
+	statements []string
+}
+
+// migrations 旧数据库的升级步骤（新数据库由schema.sql直接创建，会被自动跳过）
+var migrations = []migration{
+	{
+		table:  "device_commands",
+		column: "plan_id",
+		statements: []string{
+			`ALTER TABLE device_commands ADD COLUMN plan_id INTEGER`,
+		},
+	},
+	{
+		// 天气预报从全局唯一日期改为按位置存储；旧数据保留为 location_key = '' 的全局预报
+		table:  "rain_forecast",
+		column: "location_key",
+		statements: []string{
+			`CREATE TABLE rain_forecast_new (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				location_key TEXT NOT NULL DEFAULT '',
+				latitude REAL,
+				longitude REAL,
+				date TEXT NOT NULL,
+				temp_max REAL,
+				temp_min REAL,
+				precip_mm REAL,
+				humidity_pct REAL,
+				raw_json TEXT,
+				created_at TEXT NOT NULL,
+				UNIQUE(location_key, date)
+			)`,
+			`INSERT INTO rain_forecast_new (id, location_key, date, temp_max, temp_min, precip_mm, humidity_pct, raw_json, created_at)
+				SELECT id, '', date, temp_max, temp_min, precip_mm, humidity_pct, raw_json, created_at FROM rain_forecast`,
+			`DROP TABLE rain_forecast`,
+			`ALTER TABLE rain_forecast_new RENAME TO rain_forecast`,
+			`CREATE INDEX IF NOT EXISTS idx_forecast_date ON rain_forecast(date)`,
+		},
+	},
+	{
+		table:  "device_planner_profiles",
+		column: "et_model",
+		statements: []string{
+			`ALTER TABLE device_planner_profiles ADD COLUMN et_model TEXT NOT NULL DEFAULT 'linear'`,
+			`ALTER TABLE device_planner_profiles ADD COLUMN canopy_area_m2 REAL NOT NULL DEFAULT 0.5`,
+			`ALTER TABLE device_planner_profiles ADD COLUMN planting_date TEXT`,
+		},
+	},
+	{
+		// 计划解释：预测湿度、水量平衡和代价分项
+		table:  "irrigation_plan",
+		column: "predicted_moisture",
+		statements: []string{
+			`ALTER TABLE irrigation_plan ADD COLUMN start_moisture REAL`,
+			`ALTER TABLE irrigation_plan ADD COLUMN predicted_moisture REAL`,
+			`ALTER TABLE irrigation_plan ADD COLUMN et_l REAL`,
+			`ALTER TABLE irrigation_plan ADD COLUMN rain_l REAL`,
+			`ALTER TABLE irrigation_plan ADD COLUMN cost_deviation REAL`,
+			`ALTER TABLE irrigation_plan ADD COLUMN cost_water REAL`,
+			`ALTER TABLE irrigation_plan ADD COLUMN cost_smoothness REAL`,
+		},
+	},
+	{
+		// 规划硬约束：总水量、允许星期、浇水间隔、湿度下限
+		table:  "device_planner_profiles",
+		column: "volume_budget_l",
+		statements: []string{
+			`ALTER TABLE device_planner_profiles ADD COLUMN volume_budget_l REAL NOT NULL DEFAULT 0`,
+			`ALTER TABLE device_planner_profiles ADD COLUMN allowed_weekdays TEXT`,
+			`ALTER TABLE device_planner_profiles ADD COLUMN min_days_between INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE device_planner_profiles ADD COLUMN moisture_floor INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		table:  "rain_forecast",
+		column: "precip_prob_pct",
+		statements: []string{
+			`ALTER TABLE rain_forecast ADD COLUMN precip_prob_pct REAL`,
+		},
+	},
+	{
+		table:  "device_planner_profiles",
+		column: "risk_aversion",
+		statements: []string{
+			`ALTER TABLE device_planner_profiles ADD COLUMN risk_aversion REAL NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		table:  "irrigation_plan",
+		column: "locked",
+		statements: []string{
+			`ALTER TABLE irrigation_plan ADD COLUMN locked INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		// 计划改为按版本保存；已有计划作为版本 0 保留
+		table:  "irrigation_plan",
+		column: "version_id",
+		statements: []string{
+			`CREATE TABLE irrigation_plan_new (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				device_id TEXT NOT NULL,
+				date TEXT NOT NULL,
+				version_id INTEGER NOT NULL DEFAULT 0,
+				planned_volume_l REAL,
+				start_moisture REAL,
+				predicted_moisture REAL,
+				et_l REAL,
+				rain_l REAL,
+				cost_deviation REAL,
+				cost_water REAL,
+				cost_smoothness REAL,
+				locked INTEGER NOT NULL DEFAULT 0,
+				created_at TEXT NOT NULL,
+				UNIQUE(device_id, date, version_id)
+			)`,
+			`INSERT INTO irrigation_plan_new (id, device_id, date, planned_volume_l, start_moisture, predicted_moisture,
+				et_l, rain_l, cost_deviation, cost_water, cost_smoothness, locked, created_at)
+				SELECT id, device_id, date, planned_volume_l, start_moisture, predicted_moisture,
+				et_l, rain_l, cost_deviation, cost_water, cost_smoothness, locked, created_at FROM irrigation_plan`,
+			`DROP TABLE irrigation_plan`,
+			`ALTER TABLE irrigation_plan_new RENAME TO irrigation_plan`,
+			`CREATE INDEX IF NOT EXISTS idx_plan_device_date ON irrigation_plan(device_id, date)`,
+		},
+	},
+	{
+		// 命令状态机：下发次数、下发时间和状态变更时间
+		table:  "device_commands",
+		column: "attempts",
+		statements: []string{
+			`ALTER TABLE device_commands ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE device_commands ADD COLUMN delivered_at TEXT`,
+			`ALTER TABLE device_commands ADD COLUMN updated_at TEXT`,
+			`UPDATE device_commands SET updated_at = COALESCE(executed_at, created_at)`,
+		},
+	},
+	{
+		table:  "device_commands",
+		column: "idempotency_key",
+		statements: []string{
+			`ALTER TABLE device_commands ADD COLUMN idempotency_key TEXT`,
+			`UPDATE device_commands SET idempotency_key = lower(hex(randomblob(16)))`,
+		},
+	},
+	{
+		// 手动命令的下发用户无从得知，只补全计划自动下发的命令
+		table:  "device_commands",
+		column: "issued_by",
+		statements: []string{
+			`ALTER TABLE device_commands ADD COLUMN issued_by TEXT`,
+			`UPDATE device_commands SET issued_by = 'plan_executor' WHERE plan_id IS NOT NULL`,
+		},
+	},
+	{
+		// 设备专属密钥；已有设备在分配密钥前继续使用全局共享密钥
+		table:  "devices",
+		column: "api_key_hash",
+		statements: []string{
+			`ALTER TABLE devices ADD COLUMN api_key_hash TEXT`,
+			`ALTER TABLE devices ADD COLUMN api_key_issued_at TEXT`,
+			`ALTER TABLE devices ADD COLUMN previous_api_key_hash TEXT`,
+			`ALTER TABLE devices ADD COLUMN previous_api_key_expires_at TEXT`,
+			`ALTER TABLE devices ADD COLUMN api_key_revoked_at TEXT`,
+		},
+	},
+	{
+		// 请求签名密钥与密钥哈希分开存储；此前分配的密钥需轮换后才能签名
+		table:  "devices",
+		column: "api_signing_secret",
+		statements: []string{
+			`ALTER TABLE devices ADD COLUMN api_signing_secret TEXT`,
+			`ALTER TABLE devices ADD COLUMN previous_api_signing_secret TEXT`,
+		},
+	},
+}
+
+// postMigrations run after all migrations, e.g. indexes on migrated columns
+var postMigrations = []string{
+	`CREATE INDEX IF NOT EXISTS idx_command_plan ON device_commands(plan_id)`,
+}
+
+// Migrate brings an existing database up to date with the current schema
+func (db *DB) Migrate() error {
+	for _, m := range migrations {
+		tableExists, err := db.tableExists(m.table)
+		if err != nil {
+			return fmt.Errorf("failed to inspect %s: %w", m.table, err)
+		}
+		if !tableExists {
+			continue
+		}
+		exists, err := db.columnExists(m.table, m.column)
+		if err != nil {
+			return fmt.Errorf("failed to inspect %s: %w", m.table, err)
+		}
+		if exists {
+			continue
+		}
+
+		if err := db.runMigration(m); err != nil {
+			return fmt.Errorf("failed to migrate %s.%s: %w", m.table, m.column, err)
+		}
+	}
+
+	for _, stmt := range postMigrations {
+		if _, err := db.Exec(stmt); err != nil && !strings.Contains(err.Error(), "no such table") {
+			return fmt.Errorf("failed to execute migration %q: %w", stmt, err)
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) runMigration(m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// tableExists checks whether a table has been created
+func (db *DB) tableExists(table string) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&count)
+	return count > 0, err
+}
+
+// columnExists checks whether a table has the given column
+func (db *DB) columnExists(table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue interface{}
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
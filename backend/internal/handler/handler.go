@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
@@ -9,19 +11,23 @@ import (
 	"github.com/gin-gonic/gin"
 	"irrigation-system/backend/internal/middleware"
 	"irrigation-system/backend/internal/models"
+	"irrigation-system/backend/internal/planner"
+	"irrigation-system/backend/internal/scheduler"
 	"irrigation-system/backend/internal/service"
 )
 
 // Handler handles HTTP requests
 type Handler struct {
 	service          *service.Service
+	scheduler        *scheduler.Scheduler
 	loginRateLimiter *middleware.LoginRateLimiter
 }
 
 // NewHandler creates a new handler instance
-func NewHandler(svc *service.Service) *Handler {
+func NewHandler(svc *service.Service, sched *scheduler.Scheduler) *Handler {
 	return &Handler{
 		service:          svc,
+		scheduler:        sched,
 		loginRateLimiter: middleware.NewLoginRateLimiter(),
 	}
 }
@@ -54,7 +60,31 @@ func (h *Handler) SetupRoutes(r *gin.Engine) {
 			protected.GET("/device/:device_id/status", middleware.DeviceAccessCheck(), h.GetDeviceStatus)
 			protected.GET("/device/:device_id/history", middleware.DeviceAccessCheck(), h.GetDeviceHistory)
 			protected.POST("/device/:device_id/irrigate", middleware.DeviceAccessCheck(), h.TriggerIrrigation)
+			protected.GET("/device/:device_id/commands", middleware.DeviceAccessCheck(), h.GetCommands)
+			protected.GET("/device/:device_id/commands/:id", middleware.DeviceAccessCheck(), h.GetCommand)
+			protected.DELETE("/device/:device_id/commands/:id", middleware.DeviceAccessCheck(), h.CancelCommand)
+			protected.POST("/device/:device_id/commands/shade", middleware.DeviceAccessCheck(), h.SetShade)
+			protected.POST("/device/:device_id/commands/config", middleware.DeviceAccessCheck(), h.PushDeviceConfig)
+			protected.POST("/device/:device_id/commands/reboot", middleware.DeviceAccessCheck(), h.RebootDevice)
+			protected.POST("/device/:device_id/commands/ota", middleware.DeviceAccessCheck(), h.UpdateFirmware)
+			protected.GET("/device/:device_id/config", middleware.DeviceAccessCheck(), h.GetDeviceConfig)
+			protected.PUT("/device/:device_id/config", middleware.DeviceAccessCheck(), h.UpdateDeviceConfig)
 			protected.GET("/device/:device_id/logs", middleware.DeviceAccessCheck(), h.GetLogs)
+			protected.GET("/device/:device_id/watering-schedule", middleware.DeviceAccessCheck(), h.GetWateringSchedule)
+			protected.PUT("/device/:device_id/watering-schedule", middleware.DeviceAccessCheck(), h.UpdateWateringSchedule)
+			protected.GET("/device/:device_id/plan", middleware.DeviceAccessCheck(), h.GetPlan)
+			protected.POST("/device/:device_id/plan/simulate", middleware.DeviceAccessCheck(), h.SimulatePlan)
+			protected.GET("/device/:device_id/plan/versions", middleware.DeviceAccessCheck(), h.GetPlanVersions)
+			protected.GET("/device/:device_id/plan/versions/:version", middleware.DeviceAccessCheck(), h.GetPlanVersion)
+			protected.GET("/device/:device_id/plan/diff", middleware.DeviceAccessCheck(), h.DiffPlanVersions)
+			protected.GET("/device/:device_id/plan/overrides", middleware.DeviceAccessCheck(), h.GetPlanOverrides)
+			protected.PUT("/device/:device_id/plan/overrides/:date", middleware.DeviceAccessCheck(), h.SetPlanOverride)
+			protected.DELETE("/device/:device_id/plan/overrides/:date", middleware.DeviceAccessCheck(), h.ClearPlanOverride)
+			protected.GET("/device/:device_id/plan/dispatches", middleware.DeviceAccessCheck(), h.GetPlanDispatches)
+			protected.GET("/device/:device_id/plan/trajectory", middleware.DeviceAccessCheck(), h.GetPlanTrajectory)
+			protected.GET("/device/:device_id/profile", middleware.DeviceAccessCheck(), h.GetPlannerProfile)
+			protected.PUT("/device/:device_id/profile", middleware.DeviceAccessCheck(), h.UpdatePlannerProfile)
+			protected.DELETE("/device/:device_id/profile", middleware.DeviceAccessCheck(), h.DeletePlannerProfile)
 
 			// 位置API
 			protected.GET("/location/:device_id", middleware.DeviceAccessCheck(), h.GetLocation)
@@ -64,6 +94,7 @@ func (h *Handler) SetupRoutes(r *gin.Engine) {
 			protected.POST("/forecast/update", h.UpdateForecast)
 			protected.GET("/forecast", h.GetForecast)
 			protected.POST("/plan/recompute", h.RecomputePlan)
+			protected.GET("/planner/presets", h.GetPlannerPresets)
 
 			// 管理员专用API
 			admin := protected.Group("/admin")
@@ -72,6 +103,35 @@ func (h *Handler) SetupRoutes(r *gin.Engine) {
 				admin.GET("/users", h.GetAllUsers)           // 获取所有用户
 				admin.POST("/users", h.CreateUser)           // 创建用户
 				admin.DELETE("/users/:user_id", h.DeleteUser) // 删除用户
+
+				// 设备专属密钥的分配、轮换和吊销
+				admin.GET("/devices/:device_id/credentials", h.GetDeviceCredentials)
+				admin.POST("/devices/:device_id/credentials", h.ProvisionDeviceCredentials)
+				admin.POST("/devices/:device_id/credentials/rotate", h.RotateDeviceCredentials)
+				admin.DELETE("/devices/:device_id/credentials", h.RevokeDeviceCredentials)
+
+				// 未注册设备的隔离数据：查看、认领或丢弃
+				admin.GET("/quarantine", h.GetQuarantinedDevices)
+				admin.GET("/quarantine/:device_id", h.GetQuarantinedData)
+				admin.POST("/quarantine/:device_id/claim", h.ClaimQuarantinedDevice)
+				admin.DELETE("/quarantine/:device_id", h.DiscardQuarantinedDevice)
+
+				// 定时任务管理
+				admin.GET("/jobs", h.GetJobs)
+				admin.GET("/jobs/:name/runs", h.GetJobRuns)
+				admin.POST("/jobs/:name/trigger", h.TriggerJob)
+				admin.POST("/jobs/:name/pause", h.PauseJob)
+				admin.POST("/jobs/:name/resume", h.ResumeJob)
+
+				// 规划参数标定审核
+				admin.GET("/calibrations", h.GetCalibrations)
+				admin.POST("/calibrations", h.RunCalibration)
+				admin.GET("/calibrations/:id", h.GetCalibration)
+				admin.POST("/calibrations/:id/apply", h.ApplyCalibration)
+				admin.POST("/calibrations/:id/reject", h.RejectCalibration)
+
+				// 天气接口配额
+				admin.GET("/weather/stats", h.GetWeatherStats)
 			}
 
 			// 用户个人操作（所有登录用户可用）
@@ -91,9 +151,17 @@ func (h *Handler) HandleDeviceData(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.service.HandleDeviceData(&req)
+	resp, err := h.service.HandleDeviceData(c.GetString("device_id"), c.ClientIP(), &req)
+	if errors.Is(err, service.ErrDeviceQuarantined) {
+		c.JSON(http.StatusAccepted, gin.H{
+			"success":     false,
+			"quarantined": true,
+			"message":     err.Error(),
+		})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(deviceErrorStatus(err), gin.H{
 			"success": false,
 			"message": "Failed to process device data: " + err.Error(),
 		})
@@ -150,9 +218,33 @@ func (h *Handler) GetDeviceHistory(c *gin.Context) {
 		return
 	}
 
+	// 每日实际灌溉水量（默认最近7天）
+	volumeEnd := time.Now()
+	if endTime != nil {
+		volumeEnd = *endTime
+	}
+	volumeStart := volumeEnd.AddDate(0, 0, -6)
+	if startTime != nil {
+		volumeStart = *startTime
+	}
+	if volumeEnd.Sub(volumeStart) > 90*24*time.Hour {
+		volumeStart = volumeEnd.AddDate(0, 0, -89)
+	}
+	volumes, err := h.service.GetExecutedVolumes(deviceID,
+		volumeStart.In(time.Local).Format("2006-01-02"),
+		volumeEnd.In(time.Local).Format("2006-01-02"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get executed volumes: " + err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"data":  data,
-		"total": total,
+		"data":          data,
+		"total":         total,
+		"daily_volumes": volumes,
 	})
 }
 
@@ -169,7 +261,7 @@ func (h *Handler) TriggerIrrigation(c *gin.Context) {
 		return
 	}
 
-	commandID, err := h.service.TriggerIrrigation(deviceID, req.VolumeL, req.Reason)
+	commandID, err := h.service.TriggerIrrigation(deviceID, req.VolumeL, req.Reason, c.GetString("username"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -274,7 +366,28 @@ func (h *Handler) UpdateForecast(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.UpdateForecast(req.Latitude, req.Longitude); err != nil {
+	var err error
+	switch {
+	case req.DeviceID != "":
+		if !canAccessDevice(c, req.DeviceID) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "无权访问该设备",
+			})
+			return
+		}
+		err = h.service.UpdateDeviceForecast(req.DeviceID)
+	case req.Latitude != nil && req.Longitude != nil:
+		err = h.service.UpdateForecast(*req.Latitude, *req.Longitude)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request: device_id or latitude/longitude is required",
+		})
+		return
+	}
+
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
 			"message": "Failed to update forecast: " + err.Error(),
@@ -292,7 +405,22 @@ func (h *Handler) UpdateForecast(c *gin.Context) {
 func (h *Handler) GetForecast(c *gin.Context) {
 	days, _ := strconv.Atoi(c.DefaultQuery("days", "5"))
 
-	forecasts, err := h.service.GetForecast(days)
+	// 未指定设备时，普通用户默认查询自己设备所在位置
+	deviceID := c.Query("device_id")
+	if deviceID == "" {
+		if own, exists := c.Get("device_id"); exists {
+			deviceID = own.(string)
+		}
+	}
+	if deviceID != "" && !canAccessDevice(c, deviceID) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "无权访问该设备",
+		})
+		return
+	}
+
+	forecasts, err := h.service.GetForecast(deviceID, days)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -317,8 +445,24 @@ func (h *Handler) RecomputePlan(c *gin.Context) {
 		})
 		return
 	}
+	if !canAccessDevice(c, deviceID) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "无权访问该设备",
+		})
+		return
+	}
 
 	plans, err := h.service.RecomputePlan(deviceID)
+	var infeasible *planner.InfeasibleError
+	if errors.As(err, &infeasible) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"success":    false,
+			"message":    infeasible.Error(),
+			"infeasible": infeasible,
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
@@ -327,259 +471,1351 @@ func (h *Handler) RecomputePlan(c *gin.Context) {
 		return
 	}
 
-	// Convert to simple format
-	simplePlans := make([]models.DailyPlan, len(plans))
-	for i, p := range plans {
-		simplePlans[i] = models.DailyPlan{
-			Date:           p.Date,
-			PlannedVolumeL: p.PlannedVolumeL,
-		}
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"plan":    simplePlans,
+		"plan":    toDailyPlans(plans),
 	})
 }
 
-// UpdateCommandStatus handles ESP32 reporting command execution status
-func (h *Handler) UpdateCommandStatus(c *gin.Context) {
-	var req models.CommandExecutionRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"message": "Invalid request: " + err.Error(),
-		})
-		return
+// GetPlan retrieves the stored irrigation plan of a device with its explanation
+func (h *Handler) GetPlan(c *gin.Context) {
+	deviceID := c.Param("device_id")
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "15"))
+	if days < 1 || days > 15 {
+		days = 15
 	}
 
-	if err := h.service.UpdateCommandStatus(req.CommandID, req.Status, &req.Result); err != nil {
+	plans, err := h.service.GetPlan(deviceID, days)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"message": "Failed to update command status: " + err.Error(),
+			"message": "Failed to get plan: " + err.Error(),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
+		"plan":    toDailyPlans(plans),
 	})
 }
 
-// ========== 用户认证处理器 ==========
+// SimulatePlan computes a what-if irrigation plan with caller-supplied
+// inputs without storing it
+func (h *Handler) SimulatePlan(c *gin.Context) {
+	deviceID := c.Param("device_id")
 
-// Login 普通用户登录
-func (h *Handler) Login(c *gin.Context) {
-	var req models.LoginRequest
+	var req models.SimulatePlanRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"message": "请求格式错误: " + err.Error(),
+			"message": "Invalid request: " + err.Error(),
 		})
 		return
 	}
 
-	// 调用服务层登录
-	user, deviceID, err := h.service.Login(req.Username, req.Password)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"success": false,
-			"message": err.Error(),
+	sim, err := h.service.SimulatePlan(deviceID, &req)
+	var infeasible *planner.InfeasibleError
+	if errors.As(err, &infeasible) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"success":    false,
+			"message":    infeasible.Error(),
+			"infeasible": infeasible,
 		})
 		return
 	}
-
-	// 只允许普通用户从此接口登录
-	if user.Role != "user" {
-		c.JSON(http.StatusForbidden, gin.H{
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"message": "请使用管理员登录入口",
+			"message": "Failed to simulate plan: " + err.Error(),
 		})
 		return
 	}
 
-	// 生成token
-	token, err := middleware.GenerateToken(user, deviceID)
+	c.JSON(http.StatusOK, gin.H{
+		"success":    true,
+		"simulation": sim,
+		"plan":       toDailyPlans(sim.Plans),
+	})
+}
+
+// GetPlanVersions lists the plan versions of a device, newest first
+func (h *Handler) GetPlanVersions(c *gin.Context) {
+	deviceID := c.Param("device_id")
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	versions, total, err := h.service.GetPlanVersions(deviceID, limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"message": "Token生成失败",
+			"message": "Failed to get plan versions: " + err.Error(),
 		})
 		return
 	}
 
-	// 登录成功，重置速率限制
-	h.loginRateLimiter.ResetAttempts(c.ClientIP())
-
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"token":   token,
-		"user":    user,
+		"data":  versions,
+		"total": total,
 	})
 }
 
-// AdminLogin 管理员登录
-func (h *Handler) AdminLogin(c *gin.Context) {
-	var req models.LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		log.Printf("[AdminLogin] 请求格式错误: %v", err)
+// GetPlanVersion returns one plan version with its days and forecast snapshot
+func (h *Handler) GetPlanVersion(c *gin.Context) {
+	deviceID := c.Param("device_id")
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"message": "请求格式错误: " + err.Error(),
+			"message": "Invalid plan version",
 		})
 		return
 	}
 
-	log.Printf("[AdminLogin] 尝试登录: username=%s", req.Username)
-
-	// 调用服务层登录
-	user, deviceID, err := h.service.Login(req.Username, req.Password)
-	if err != nil {
-		log.Printf("[AdminLogin] 登录失败: %v", err)
-		c.JSON(http.StatusUnauthorized, gin.H{
+	v, plans, snapshot, err := h.service.GetPlanVersion(deviceID, version)
+	if errors.Is(err, service.ErrPlanVersionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
 			"success": false,
 			"message": err.Error(),
 		})
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get plan version: " + err.Error(),
+		})
+		return
+	}
 
-	log.Printf("[AdminLogin] 登录成功: username=%s, role=%s", user.Username, user.Role)
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"version":  v,
+		"plan":     toDailyPlans(plans),
+		"forecast": snapshot,
+	})
+}
 
-	// 只允许管理员从此接口登录
-	if user.Role != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{
+// DiffPlanVersions compares two plan versions of a device. Without from the
+// version before to is used; without to the latest version.
+func (h *Handler) DiffPlanVersions(c *gin.Context) {
+	deviceID := c.Param("device_id")
+	from, err1 := strconv.Atoi(c.DefaultQuery("from", "0"))
+	to, err2 := strconv.Atoi(c.DefaultQuery("to", "0"))
+	if err1 != nil || err2 != nil || from < 0 || to < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"message": "您不是管理员",
+			"message": "from and to must be plan version numbers",
 		})
 		return
 	}
 
-	// 生成token
-	token, err := middleware.GenerateToken(user, deviceID)
+	diff, err := h.service.DiffPlanVersions(deviceID, from, to)
+	if errors.Is(err, service.ErrPlanVersionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"message": "Token生成失败",
+			"message": "Failed to diff plan versions: " + err.Error(),
 		})
 		return
 	}
 
-	// 登录成功，重置速率限制
-	h.loginRateLimiter.ResetAttempts(c.ClientIP())
-
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"token":   token,
-		"user":    user,
+		"diff":    diff,
 	})
 }
 
-// ========== 用户管理处理器（管理员专用） ==========
+// GetPlanOverrides lists the plan overrides of a device from today on
+func (h *Handler) GetPlanOverrides(c *gin.Context) {
+	deviceID := c.Param("device_id")
 
-// GetAllUsers 获取所有用户
-func (h *Handler) GetAllUsers(c *gin.Context) {
-	users, err := h.service.GetAllUsers()
+	overrides, err := h.service.GetPlanOverrides(deviceID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"message": "获取用户列表失败: " + err.Error(),
+			"message": "Failed to get plan overrides: " + err.Error(),
 		})
 		return
 	}
+	if overrides == nil {
+		overrides = []*models.PlanOverride{}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"users":   users,
+		"data":  overrides,
+		"total": len(overrides),
 	})
 }
 
-// CreateUser 创建新用户
-func (h *Handler) CreateUser(c *gin.Context) {
-	var req models.CreateUserRequest
+// SetPlanOverride pins the irrigation volume of one plan day and recomputes
+// the plan around it
+func (h *Handler) SetPlanOverride(c *gin.Context) {
+	deviceID := c.Param("device_id")
+
+	var req models.SetPlanOverrideRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"message": "请求格式错误: " + err.Error(),
+			"message": "Invalid request: " + err.Error(),
 		})
 		return
 	}
 
-	user, err := h.service.CreateUser(&req)
+	override, err := h.service.SetPlanOverride(deviceID, c.Param("date"), &req, c.GetString("username"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"message": err.Error(),
+			"message": "Failed to set plan override: " + err.Error(),
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
+		"success":  true,
+		"override": override,
+	}
+	h.recomputeAfterOverride(deviceID, response)
+	c.JSON(http.StatusOK, response)
+}
+
+// ClearPlanOverride removes the override of one plan day and recomputes the plan
+func (h *Handler) ClearPlanOverride(c *gin.Context) {
+	deviceID := c.Param("device_id")
+
+	if err := h.service.ClearPlanOverride(deviceID, c.Param("date")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Failed to clear plan override: " + err.Error(),
+		})
+		return
+	}
+
+	response := gin.H{
 		"success": true,
-		"message": "用户创建成功",
-		"user":    user,
-	})
+	}
+	h.recomputeAfterOverride(deviceID, response)
+	c.JSON(http.StatusOK, response)
 }
 
-// DeleteUser 删除用户
-func (h *Handler) DeleteUser(c *gin.Context) {
-	userIDStr := c.Param("user_id")
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+// recomputeAfterOverride adds the recomputed plan to response. The override
+// is kept when recomputation fails; the failure is reported as a warning.
+func (h *Handler) recomputeAfterOverride(deviceID string, response gin.H) {
+	plans, err := h.service.RecomputePlanAfterOverride(deviceID)
+	if err != nil {
+		response["warning"] = "Plan not recomputed: " + err.Error()
+		var infeasible *planner.InfeasibleError
+		if errors.As(err, &infeasible) {
+			response["infeasible"] = infeasible
+		}
+		return
+	}
+	response["plan"] = toDailyPlans(plans)
+}
+
+// toDailyPlans converts stored plans to the API response format
+func toDailyPlans(plans []models.IrrigationPlan) []models.DailyPlan {
+	simplePlans := make([]models.DailyPlan, len(plans))
+	for i, p := range plans {
+		simplePlans[i] = models.DailyPlan{
+			Date:              p.Date,
+			PlannedVolumeL:    p.PlannedVolumeL,
+			ExecutedVolumeL:   p.ExecutedVolumeL,
+			StartMoisture:     p.StartMoisture,
+			PredictedMoisture: p.PredictedMoisture,
+			ETL:               p.ETL,
+			RainL:             p.RainL,
+			Cost:              p.Cost,
+			Locked:            p.Locked,
+		}
+	}
+	return simplePlans
+}
+
+// GetWateringSchedule retrieves the watering windows of a device
+func (h *Handler) GetWateringSchedule(c *gin.Context) {
+	deviceID := c.Param("device_id")
+
+	schedule, err := h.service.GetWateringSchedule(deviceID)
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get watering schedule: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// UpdateWateringSchedule updates the watering windows of a device
+func (h *Handler) UpdateWateringSchedule(c *gin.Context) {
+	deviceID := c.Param("device_id")
+
+	var req models.UpdateWateringScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"message": "无效的用户ID",
+			"message": "Invalid request: " + err.Error(),
 		})
 		return
 	}
 
-	if err := h.service.DeleteUser(userID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+	schedule, err := h.service.UpdateWateringSchedule(deviceID, req.Windows, req.Enabled)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"message": "删除用户失败: " + err.Error(),
+			"message": "Failed to update watering schedule: " + err.Error(),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "用户删除成功",
+		"success":  true,
+		"schedule": schedule,
 	})
 }
 
-// ChangePassword 修改当前用户密码
-func (h *Handler) ChangePassword(c *gin.Context) {
-	var req models.ChangePasswordRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
+// GetPlanDispatches retrieves the plan executor records of a device for one day
+func (h *Handler) GetPlanDispatches(c *gin.Context) {
+	deviceID := c.Param("device_id")
+	date := c.DefaultQuery("date", time.Now().Format("2006-01-02"))
+
+	dispatches, err := h.service.GetPlanDispatches(deviceID, date)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"message": "请求格式错误: " + err.Error(),
+			"message": "Failed to get plan dispatches: " + err.Error(),
 		})
 		return
 	}
 
-	// 从token中获取用户ID
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    dispatches,
+	})
+}
+
+// GetPlanTrajectory returns predicted vs observed soil moisture of a device
+func (h *Handler) GetPlanTrajectory(c *gin.Context) {
+	deviceID := c.Param("device_id")
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if days < 0 {
+		days = 0
+	}
+	if days > 90 {
+		days = 90
+	}
+
+	trajectory, comparison, err := h.service.GetPlanTrajectory(deviceID, days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"message": "用户未登录",
+			"message": "Failed to get plan trajectory: " + err.Error(),
 		})
 		return
 	}
 
-	// 调用service修改密码
-	err := h.service.ChangePassword(userID.(int64), req.OldPassword, req.NewPassword)
+	drift, err := h.service.GetDriftStatus(deviceID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
+		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"message": err.Error(),
+			"message": "Failed to get drift status: " + err.Error(),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "密码修改成功",
+		"data": gin.H{
+			"trajectory": trajectory,
+			"comparison": comparison,
+			"drift":      drift,
+		},
 	})
 }
 
+// GetPlannerProfile retrieves the planner profile of a device
+func (h *Handler) GetPlannerProfile(c *gin.Context) {
+	deviceID := c.Param("device_id")
+
+	profile, err := h.service.GetPlannerProfile(deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get planner profile: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdatePlannerProfile creates or replaces the planner profile of a device
+func (h *Handler) UpdatePlannerProfile(c *gin.Context) {
+	deviceID := c.Param("device_id")
+
+	var req models.UpdatePlannerProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	profile, err := h.service.UpdatePlannerProfile(deviceID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to update planner profile: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"profile": profile,
+	})
+}
+
+// DeletePlannerProfile resets a device to the default planner parameters
+func (h *Handler) DeletePlannerProfile(c *gin.Context) {
+	deviceID := c.Param("device_id")
+
+	if err := h.service.DeletePlannerProfile(deviceID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Failed to delete planner profile: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// GetPlannerPresets lists the crop and soil templates for planner profiles
+func (h *Handler) GetPlannerPresets(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"crops":   planner.CropPresets(),
+		"soils":   planner.SoilPresets(),
+	})
+}
+
+// UpdateCommandStatus handles ESP32 reporting command execution status
+func (h *Handler) UpdateCommandStatus(c *gin.Context) {
+	var req models.CommandExecutionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	duplicate, err := h.service.UpdateCommandStatus(c.GetString("device_id"), &req)
+	if err != nil {
+		c.JSON(commandErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to update command status: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"duplicate": duplicate,
+	})
+}
+
+// GetCommands lists a device's commands, filtered by status, type and
+// creation time
+func (h *Handler) GetCommands(c *gin.Context) {
+	deviceID := c.Param("device_id")
+
+	// Parse query parameters
+	var statusPtr, typePtr *string
+	if status := c.Query("status"); status != "" {
+		statusPtr = &status
+	}
+	if commandType := c.Query("type"); commandType != "" {
+		typePtr = &commandType
+	}
+
+	var startTime, endTime *time.Time
+	if startStr := c.Query("start_time"); startStr != "" {
+		t, err := time.Parse(time.RFC3339, startStr)
+		if err == nil {
+			startTime = &t
+		}
+	}
+	if endStr := c.Query("end_time"); endStr != "" {
+		t, err := time.Parse(time.RFC3339, endStr)
+		if err == nil {
+			endTime = &t
+		}
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	commands, total, err := h.service.GetCommands(deviceID, statusPtr, typePtr, startTime, endTime, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get commands: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  commands,
+		"total": total,
+	})
+}
+
+// GetCommand returns one device command with its parameters, delivery
+// attempts and result
+func (h *Handler) GetCommand(c *gin.Context) {
+	commandID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid command ID",
+		})
+		return
+	}
+
+	cmd, err := h.service.GetCommand(c.Param("device_id"), commandID)
+	if err != nil {
+		c.JSON(commandErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to get command: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"command": cmd,
+	})
+}
+
+// CancelCommand cancels a device command that has not started executing
+func (h *Handler) CancelCommand(c *gin.Context) {
+	commandID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid command ID",
+		})
+		return
+	}
+
+	cmd, err := h.service.CancelCommand(c.Param("device_id"), commandID, c.GetString("username"))
+	if err != nil {
+		c.JSON(commandErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to cancel command: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"command": cmd,
+	})
+}
+
+// SetShade sends a set_shade command (open, closed or auto) to a device
+func (h *Handler) SetShade(c *gin.Context) {
+	var req models.SetShadeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+	h.issueCommand(c, "set_shade", &req)
+}
+
+// PushDeviceConfig sends an update_config command with the given settings
+func (h *Handler) PushDeviceConfig(c *gin.Context) {
+	var req models.DeviceConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+	h.issueCommand(c, "update_config", &req)
+}
+
+// RebootDevice sends a reboot command. The request body is optional.
+func (h *Handler) RebootDevice(c *gin.Context) {
+	var req models.RebootRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+	h.issueCommand(c, "reboot", &req)
+}
+
+// UpdateFirmware sends an ota_update command with the firmware URL and checksum
+func (h *Handler) UpdateFirmware(c *gin.Context) {
+	var req models.OTAUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+	h.issueCommand(c, "ota_update", &req)
+}
+
+// GetDeviceConfig returns the desired and reported settings of a device and
+// where they differ
+func (h *Handler) GetDeviceConfig(c *gin.Context) {
+	twin, err := h.service.GetDeviceTwin(c.Param("device_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get device config: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"config":  twin,
+	})
+}
+
+// UpdateDeviceConfig changes desired settings of a device. The response
+// includes the update_config command queued to apply them, if any.
+func (h *Handler) UpdateDeviceConfig(c *gin.Context) {
+	var req models.DeviceConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	twin, cmd, err := h.service.UpdateDesiredConfig(c.Param("device_id"), &req, c.GetString("username"))
+	if err != nil {
+		c.JSON(commandErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to update device config: " + err.Error(),
+		})
+		return
+	}
+
+	response := gin.H{
+		"success": true,
+		"config":  twin,
+	}
+	if cmd != nil {
+		response["command"] = cmd
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// issueCommand queues a command of a registered type for the device in the
+// path on behalf of the current user
+func (h *Handler) issueCommand(c *gin.Context, commandType string, params interface{}) {
+	cmd, err := h.service.IssueCommand(c.Param("device_id"), commandType, params, c.GetString("username"), c.GetString("role"))
+	if err != nil {
+		c.JSON(commandErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to issue command: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"command": cmd,
+	})
+}
+
+// commandErrorStatus maps command errors to HTTP status codes
+func commandErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrCommandNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInvalidCommandParams), errors.Is(err, service.ErrUnknownCommandType):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrCommandDeviceMismatch), errors.Is(err, service.ErrCommandForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrInvalidTransition), errors.Is(err, service.ErrIdempotencyKeyMismatch):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ========== 用户认证处理器 ==========
+
+// Login 普通用户登录
+func (h *Handler) Login(c *gin.Context) {
+	var req models.LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求格式错误: " + err.Error(),
+		})
+		return
+	}
+
+	// 调用服务层登录
+	user, deviceID, err := h.service.Login(req.Username, req.Password)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 只允许普通用户从此接口登录
+	if user.Role != "user" {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "请使用管理员登录入口",
+		})
+		return
+	}
+
+	// 生成token
+	token, err := middleware.GenerateToken(user, deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Token生成失败",
+		})
+		return
+	}
+
+	// 登录成功，重置速率限制
+	h.loginRateLimiter.ResetAttempts(c.ClientIP())
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"token":   token,
+		"user":    user,
+	})
+}
+
+// AdminLogin 管理员登录
+func (h *Handler) AdminLogin(c *gin.Context) {
+	var req models.LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("[AdminLogin] 请求格式错误: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求格式错误: " + err.Error(),
+		})
+		return
+	}
+
+	log.Printf("[AdminLogin] 尝试登录: username=%s", req.Username)
+
+	// 调用服务层登录
+	user, deviceID, err := h.service.Login(req.Username, req.Password)
+	if err != nil {
+		log.Printf("[AdminLogin] 登录失败: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	log.Printf("[AdminLogin] 登录成功: username=%s, role=%s", user.Username, user.Role)
+
+	// 只允许管理员从此接口登录
+	if user.Role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "您不是管理员",
+		})
+		return
+	}
+
+	// 生成token
+	token, err := middleware.GenerateToken(user, deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Token生成失败",
+		})
+		return
+	}
+
+	// 登录成功，重置速率限制
+	h.loginRateLimiter.ResetAttempts(c.ClientIP())
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"token":   token,
+		"user":    user,
+	})
+}
+
+// ========== 用户管理处理器（管理员专用） ==========
+
+// GetAllUsers 获取所有用户
+func (h *Handler) GetAllUsers(c *gin.Context) {
+	users, err := h.service.GetAllUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取用户列表失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"users":   users,
+	})
+}
+
+// CreateUser 创建新用户
+func (h *Handler) CreateUser(c *gin.Context) {
+	var req models.CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求格式错误: " + err.Error(),
+		})
+		return
+	}
+
+	user, err := h.service.CreateUser(&req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "用户创建成功",
+		"user":    user,
+	})
+}
+
+// DeleteUser 删除用户
+func (h *Handler) DeleteUser(c *gin.Context) {
+	userIDStr := c.Param("user_id")
+	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的用户ID",
+		})
+		return
+	}
+
+	if err := h.service.DeleteUser(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "删除用户失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "用户删除成功",
+	})
+}
+
+// ChangePassword 修改当前用户密码
+func (h *Handler) ChangePassword(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求格式错误: " + err.Error(),
+		})
+		return
+	}
+
+	// 从token中获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "用户未登录",
+		})
+		return
+	}
+
+	// 调用service修改密码
+	err := h.service.ChangePassword(userID.(int64), req.OldPassword, req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "密码修改成功",
+	})
+}
+
+// ========== 设备密钥处理器（管理员专用） ==========
+
+// GetDeviceCredentials returns the state of a device's API key
+func (h *Handler) GetDeviceCredentials(c *gin.Context) {
+	creds, err := h.service.GetDeviceCredentials(c.Param("device_id"))
+	if err != nil {
+		c.JSON(deviceErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to get device credentials: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"credentials": creds,
+	})
+}
+
+// ProvisionDeviceCredentials issues a device's first API key
+func (h *Handler) ProvisionDeviceCredentials(c *gin.Context) {
+	key, creds, err := h.service.ProvisionDeviceKey(c.Param("device_id"), c.GetString("username"))
+	if err != nil {
+		c.JSON(deviceErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to provision device credentials: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":     true,
+		"message":     "API key is shown only once, store it in the device configuration now",
+		"api_key":     key,
+		"credentials": creds,
+	})
+}
+
+// RotateDeviceCredentials issues a new API key for a device; the old key
+// stays valid for the grace period
+func (h *Handler) RotateDeviceCredentials(c *gin.Context) {
+	var req models.RotateDeviceKeyRequest
+	// 请求体可省略
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	var grace *time.Duration
+	if req.GraceHours != nil {
+		d := time.Duration(*req.GraceHours) * time.Hour
+		grace = &d
+	}
+
+	key, creds, err := h.service.RotateDeviceKey(c.Param("device_id"), grace, c.GetString("username"))
+	if err != nil {
+		c.JSON(deviceErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to rotate device credentials: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"message":     "API key is shown only once, store it in the device configuration now",
+		"api_key":     key,
+		"credentials": creds,
+	})
+}
+
+// RevokeDeviceCredentials revokes a device's API keys
+func (h *Handler) RevokeDeviceCredentials(c *gin.Context) {
+	creds, err := h.service.RevokeDeviceKey(c.Param("device_id"), c.GetString("username"))
+	if err != nil {
+		c.JSON(deviceErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to revoke device credentials: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"credentials": creds,
+	})
+}
+
+func deviceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrDeviceNotFound), errors.Is(err, service.ErrNothingQuarantined):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInvalidDeviceData), errors.Is(err, service.ErrInvalidClaim):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrDeviceIDMismatch), errors.Is(err, service.ErrUnknownDevice):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrDeviceKeyExists), errors.Is(err, service.ErrDeviceKeyMissing):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrQuarantineRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, service.ErrQuarantineFull):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ========== 未注册设备隔离区处理器（管理员专用） ==========
+
+// GetQuarantinedDevices lists the unregistered devices that uploaded data
+func (h *Handler) GetQuarantinedDevices(c *gin.Context) {
+	devices, err := h.service.GetQuarantinedDevices()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get quarantined devices: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  devices,
+		"total": len(devices),
+	})
+}
+
+// GetQuarantinedData lists a device's quarantined uploads
+func (h *Handler) GetQuarantinedData(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 {
+		limit = 20
+	}
+
+	entries, total, err := h.service.GetQuarantinedData(c.Param("device_id"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get quarantined data: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  entries,
+		"total": total,
+	})
+}
+
+// ClaimQuarantinedDevice registers a quarantined device and imports its data
+func (h *Handler) ClaimQuarantinedDevice(c *gin.Context) {
+	var req models.ClaimDeviceRequest
+	// 已注册的设备只导入数据，请求体可省略
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	user, imported, err := h.service.ClaimQuarantinedDevice(c.Param("device_id"), &req, c.GetString("username"))
+	if err != nil {
+		c.JSON(deviceErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to claim device: " + err.Error(),
+		})
+		return
+	}
+
+	response := gin.H{
+		"success":  true,
+		"imported": imported,
+	}
+	if user != nil {
+		response["user"] = user
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// DiscardQuarantinedDevice deletes a device's quarantined uploads
+func (h *Handler) DiscardQuarantinedDevice(c *gin.Context) {
+	deleted, err := h.service.DiscardQuarantinedDevice(c.Param("device_id"))
+	if err != nil {
+		c.JSON(deviceErrorStatus(err), gin.H{
+			"success": false,
+			"message": "Failed to discard quarantined data: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"deleted": deleted,
+	})
+}
+
+// ========== 规划参数标定处理器（管理员专用） ==========
+
+// GetCalibrations lists calibration runs
+func (h *Handler) GetCalibrations(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	runs, total, err := h.service.GetCalibrations(c.Query("device_id"), c.Query("status"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "Failed to get calibrations: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  runs,
+		"total": total,
+	})
+}
+
+// RunCalibration calibrates one device immediately
+func (h *Handler) RunCalibration(c *gin.Context) {
+	var req models.RunCalibrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	run, err := h.service.CalibrateDevice(req.DeviceID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"success": false,
+			"message": "Calibration failed: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    run,
+	})
+}
+
+// GetCalibration retrieves one calibration run
+func (h *Handler) GetCalibration(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid calibration id",
+		})
+		return
+	}
+
+	run, err := h.service.GetCalibration(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Calibration not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    run,
+	})
+}
+
+// ApplyCalibration writes a pending calibration into the device profile
+func (h *Handler) ApplyCalibration(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid calibration id",
+		})
+		return
+	}
+
+	profile, err := h.service.ApplyCalibration(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to apply calibration: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    profile,
+	})
+}
+
+// RejectCalibration discards a pending calibration
+func (h *Handler) RejectCalibration(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid calibration id",
+		})
+		return
+	}
+
+	if err := h.service.RejectCalibration(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to reject calibration: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// GetWeatherStats returns weather API cache and quota metrics (admin only)
+func (h *Handler) GetWeatherStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"providers": h.service.GetWeatherStats(),
+	})
+}
+
+// ========== 定时任务处理器（管理员专用） ==========
+
+// GetJobs lists all scheduled jobs and their state
+func (h *Handler) GetJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"jobs":    h.scheduler.Jobs(),
+	})
+}
+
+// GetJobRuns retrieves run history of a job
+func (h *Handler) GetJobRuns(c *gin.Context) {
+	name := c.Param("name")
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+
+	runs, total, err := h.scheduler.Runs(name, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Failed to get job runs: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  runs,
+		"total": total,
+	})
+}
+
+// TriggerJob runs a job immediately
+func (h *Handler) TriggerJob(c *gin.Context) {
+	if err := h.scheduler.Trigger(c.Param("name")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Failed to trigger job: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// PauseJob pauses a scheduled job
+func (h *Handler) PauseJob(c *gin.Context) {
+	if err := h.scheduler.Pause(c.Param("name")); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, scheduler.ErrJobNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": "Failed to pause job: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// ResumeJob resumes a paused job
+func (h *Handler) ResumeJob(c *gin.Context) {
+	if err := h.scheduler.Resume(c.Param("name")); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, scheduler.ErrJobNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": "Failed to resume job: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// canAccessDevice reports whether the logged-in user may access a device
+// (same rule as middleware.DeviceAccessCheck, for IDs outside the URL path)
+func canAccessDevice(c *gin.Context, deviceID string) bool {
+	if role, _ := c.Get("role"); role == "admin" {
+		return true
+	}
+	own, exists := c.Get("device_id")
+	return exists && own.(string) == deviceID
+}
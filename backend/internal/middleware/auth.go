@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -200,15 +201,18 @@ func DeviceAccessCheck() gin.HandlerFunc {
 	}
 }
 
+// DeviceKeyVerifier reports whether apiKey authenticates the device
+type DeviceKeyVerifier func(deviceID, apiKey string) (bool, error)
+
 // DeviceAPIAuth 设备API认证中间件（用于ESP32上报数据）
-var deviceAPIKey string
+var deviceKeyVerifier DeviceKeyVerifier
 
 // InitDeviceAuth 初始化设备API认证
-func InitDeviceAuth(apiKey string) {
-	deviceAPIKey = apiKey
+func InitDeviceAuth(verify DeviceKeyVerifier) {
+	deviceKeyVerifier = verify
 }
 
-// DeviceAPIAuthMiddleware 设备API认证中间件
+// DeviceAPIAuthMiddleware 设备API认证中间件，签名或密钥必须属于 X-Device-ID 声明的设备
 func DeviceAPIAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		apiKey := c.GetHeader("X-Device-API-Key")
@@ -223,7 +227,53 @@ func DeviceAPIAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		if apiKey == "" || apiKey != deviceAPIKey {
+		// 带签名的请求校验签名和随机数，不需要明文密钥
+		if c.GetHeader("X-Signature") != "" {
+			reason, err := verifyDeviceSignature(c, deviceID)
+			if err != nil {
+				// 内部错误只记录在服务器日志中
+				log.Printf("[SECURITY] Device signature check failed | Device: %s | Error: %v", deviceID, err)
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"success": false,
+					"message": "设备认证失败",
+				})
+				c.Abort()
+				return
+			}
+			if reason != "" {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"success": false,
+					"message": "设备认证失败: " + reason,
+				})
+				c.Abort()
+				return
+			}
+
+			c.Set("device_id", deviceID)
+			c.Next()
+			return
+		}
+
+		if deviceSignatureRequired {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "设备请求必须签名",
+			})
+			c.Abort()
+			return
+		}
+
+		ok, err := deviceKeyVerifier(deviceID, apiKey)
+		if err != nil {
+			log.Printf("[SECURITY] Device key check failed | Device: %s | Error: %v", deviceID, err)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "设备认证失败",
+			})
+			c.Abort()
+			return
+		}
+		if !ok {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
 				"message": "设备认证失败",
@@ -0,0 +1,240 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	testCurrentKey  = []byte("current-signing-secret-0123456789")
+	testPreviousKey = []byte("previous-signing-secret-012345678")
+)
+
+// signedRequest describes a device request and how it is signed
+type signedRequest struct {
+	path     string // 可带查询串
+	body     string
+	deviceID string
+	key      []byte
+	signedAt time.Time
+	nonce    string
+
+	// 签名之后对请求的篡改
+	sendPath string
+	sendBody string
+}
+
+func (r signedRequest) build(t *testing.T) *http.Request {
+	t.Helper()
+	path, query, _ := strings.Cut(r.path, "?")
+	timestamp := strconv.FormatInt(r.signedAt.Unix(), 10)
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(deviceSignaturePayload("POST", path, query, r.deviceID, timestamp, r.nonce, []byte(r.body))))
+
+	sendPath, sendBody := r.path, r.body
+	if r.sendPath != "" {
+		sendPath = r.sendPath
+	}
+	if r.sendBody != "" {
+		sendBody = r.sendBody
+	}
+	req := httptest.NewRequest("POST", sendPath, strings.NewReader(sendBody))
+	req.Header.Set("X-Device-ID", r.deviceID)
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("X-Nonce", r.nonce)
+	req.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+// newSignedRouter sets up device authentication with an in-memory nonce
+// store and returns a router whose device handlers echo the request body
+func newSignedRouter(t *testing.T, required bool, keysErr error) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	var mu sync.Mutex
+	nonces := make(map[string]bool)
+	InitDeviceSigning(
+		func(deviceID string) ([][]byte, error) {
+			if keysErr != nil {
+				return nil, keysErr
+			}
+			if deviceID != "dev1" {
+				return nil, nil
+			}
+			// 轮换后的宽限期内旧密钥仍然有效
+			return [][]byte{testCurrentKey, testPreviousKey}, nil
+		},
+		func(deviceID, nonce string, expiresAt time.Time) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if nonces[deviceID+"\n"+nonce] {
+				return false, nil
+			}
+			nonces[deviceID+"\n"+nonce] = true
+			return true, nil
+		},
+		required, 5*time.Minute,
+	)
+	InitDeviceAuth(func(deviceID, apiKey string) (bool, error) {
+		return deviceID == "dev1" && apiKey == "plain-key", nil
+	})
+
+	echo := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.JSON(http.StatusOK, gin.H{"success": true, "device_id": c.GetString("device_id"), "body": string(body)})
+	}
+	r := gin.New()
+	device := r.Group("/api/device", DeviceAPIAuthMiddleware())
+	device.POST("/data", echo)
+	device.POST("/command/status", echo)
+	return r
+}
+
+// serve sends req and returns the status and the decoded JSON response
+func serve(r *gin.Engine, req *http.Request) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func TestDeviceSignature(t *testing.T) {
+	now := time.Now()
+	valid := signedRequest{
+		path:     "/api/device/data?fw=2.1",
+		body:     `{"device_id":"dev1","soil_raw":2000}`,
+		deviceID: "dev1",
+		key:      testCurrentKey,
+		signedAt: now,
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *signedRequest)
+		want   int
+		reason string // 拒绝原因
+	}{
+		{name: "valid", modify: func(r *signedRequest) {}, want: http.StatusOK},
+		{name: "previous key in grace period", modify: func(r *signedRequest) { r.key = testPreviousKey }, want: http.StatusOK},
+		{name: "unknown key", modify: func(r *signedRequest) { r.key = []byte("wrong") }, want: http.StatusUnauthorized, reason: "签名无效"},
+		{name: "other device", modify: func(r *signedRequest) { r.deviceID = "dev2" }, want: http.StatusUnauthorized, reason: "签名无效"},
+		{name: "tampered body", modify: func(r *signedRequest) { r.sendBody = `{"device_id":"dev1","soil_raw":9999}` }, want: http.StatusUnauthorized, reason: "签名无效"},
+		{name: "tampered path", modify: func(r *signedRequest) { r.sendPath = "/api/device/command/status?fw=2.1" }, want: http.StatusUnauthorized, reason: "签名无效"},
+		{name: "tampered query", modify: func(r *signedRequest) { r.sendPath = "/api/device/data?fw=2.2" }, want: http.StatusUnauthorized, reason: "签名无效"},
+		{name: "query removed", modify: func(r *signedRequest) { r.sendPath = "/api/device/data" }, want: http.StatusUnauthorized, reason: "签名无效"},
+		{name: "timestamp too old", modify: func(r *signedRequest) { r.signedAt = now.Add(-6 * time.Minute) }, want: http.StatusUnauthorized, reason: "签名时间戳超出允许范围"},
+		{name: "timestamp too far ahead", modify: func(r *signedRequest) { r.signedAt = now.Add(6 * time.Minute) }, want: http.StatusUnauthorized, reason: "签名时间戳超出允许范围"},
+		{name: "timestamp within skew", modify: func(r *signedRequest) { r.signedAt = now.Add(-4 * time.Minute) }, want: http.StatusOK},
+		{name: "missing nonce", modify: func(r *signedRequest) { r.nonce = "" }, want: http.StatusUnauthorized, reason: "签名随机数无效"},
+		{name: "oversize body", modify: func(r *signedRequest) { r.body = strings.Repeat("x", maxSignedBodyBytes+1) }, want: http.StatusUnauthorized, reason: "读取请求体失败"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newSignedRouter(t, true, nil)
+			req := valid
+			req.nonce = "nonce-" + strconv.Itoa(i)
+			tt.modify(&req)
+
+			code, resp := serve(r, req.build(t))
+			if code != tt.want {
+				t.Fatalf("status %d, want %d: %v", code, tt.want, resp)
+			}
+			if tt.reason != "" && resp["message"] != "设备认证失败: "+tt.reason {
+				t.Errorf("message %q, want reason %q", resp["message"], tt.reason)
+			}
+			if code == http.StatusOK && (resp["device_id"] != "dev1" || resp["body"] != req.body) {
+				t.Errorf("handler got device %v and body %v", resp["device_id"], resp["body"])
+			}
+		})
+	}
+}
+
+func TestDeviceSignatureReplay(t *testing.T) {
+	r := newSignedRouter(t, true, nil)
+	req := signedRequest{
+		path:     "/api/device/data",
+		body:     `{"device_id":"dev1"}`,
+		deviceID: "dev1",
+		key:      testCurrentKey,
+		signedAt: time.Now(),
+		nonce:    "replayed",
+	}
+
+	if code, resp := serve(r, req.build(t)); code != http.StatusOK {
+		t.Fatalf("first request: status %d: %v", code, resp)
+	}
+	code, resp := serve(r, req.build(t))
+	if code != http.StatusUnauthorized || resp["message"] != "设备认证失败: 重复的请求" {
+		t.Errorf("replay: status %d: %v", code, resp)
+	}
+}
+
+func TestDeviceSignatureKeyLookupError(t *testing.T) {
+	r := newSignedRouter(t, true, errors.New("database is locked"))
+	req := signedRequest{
+		path:     "/api/device/data",
+		body:     `{}`,
+		deviceID: "dev1",
+		key:      testCurrentKey,
+		signedAt: time.Now(),
+		nonce:    "n1",
+	}
+
+	code, resp := serve(r, req.build(t))
+	if code != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401", code)
+	}
+	if msg, _ := resp["message"].(string); strings.Contains(msg, "database") {
+		t.Errorf("internal error leaked: %q", msg)
+	}
+}
+
+func TestUnsignedDeviceRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		required bool
+		apiKey   string
+		want     int
+	}{
+		{name: "plain key when optional", apiKey: "plain-key", want: http.StatusOK},
+		{name: "wrong key when optional", apiKey: "wrong", want: http.StatusUnauthorized},
+		{name: "plain key when required", required: true, apiKey: "plain-key", want: http.StatusUnauthorized},
+		{name: "no credentials when required", required: true, want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newSignedRouter(t, tt.required, nil)
+			req := httptest.NewRequest("POST", "/api/device/data", bytes.NewReader([]byte(`{}`)))
+			req.Header.Set("X-Device-ID", "dev1")
+			if tt.apiKey != "" {
+				req.Header.Set("X-Device-API-Key", tt.apiKey)
+			}
+
+			code, resp := serve(r, req)
+			if code != tt.want {
+				t.Errorf("status %d, want %d: %v", code, tt.want, resp)
+			}
+			if tt.required && resp["message"] != "设备请求必须签名" {
+				t.Errorf("message %q", resp["message"])
+			}
+		})
+	}
+}
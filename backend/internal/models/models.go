@@ -18,24 +18,43 @@ type SensorData struct {
 
 // RainForecast represents a weather forecast record
 type RainForecast struct {
-	ID          int64     `json:"id"`
-	Date        string    `json:"date"`
-	TempMax     *float64  `json:"temp_max"`
-	TempMin     *float64  `json:"temp_min"`
-	PrecipMm    *float64  `json:"precip_mm"`
-	HumidityPct *float64  `json:"humidity_pct"`
-	RawJSON     string    `json:"raw_json"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID            int64     `json:"id"`
+	LocationKey   string    `json:"location_key"`
+	Latitude      *float64  `json:"latitude,omitempty"`
+	Longitude     *float64  `json:"longitude,omitempty"`
+	Date          string    `json:"date"`
+	TempMax       *float64  `json:"temp_max"`
+	TempMin       *float64  `json:"temp_min"`
+	PrecipMm      *float64  `json:"precip_mm"`
+	HumidityPct   *float64  `json:"humidity_pct"`
+	PrecipProbPct *float64  `json:"precip_prob_pct"` // 降水概率 (%)，部分天气源没有
+	RawJSON       string    `json:"raw_json"`
+	CreatedAt     time.Time `json:"created_at"`
 }
 
 // IrrigationPlan represents an irrigation plan record
 type IrrigationPlan struct {
-	ID              int64     `json:"id"`
-	DeviceID        string    `json:"device_id"`
-	Date            string    `json:"date"`
-	PlannedVolumeL  float64   `json:"planned_volume_l"`
-	CreatedAt       time.Time `json:"created_at"`
-	ExecutedVolumeL float64   `json:"executed_volume_l,omitempty"` // 已执行水量
+	ID                int64     `json:"id"`
+	DeviceID          string    `json:"device_id"`
+	Date              string    `json:"date"`
+	PlannedVolumeL    float64   `json:"planned_volume_l"`
+	CreatedAt         time.Time `json:"created_at"`
+	ExecutedVolumeL   float64   `json:"executed_volume_l,omitempty"`  // 已执行水量
+	StartMoisture     float64   `json:"start_moisture,omitempty"`     // 计算计划时预测的当日开始湿度
+	PredictedMoisture float64   `json:"predicted_moisture,omitempty"` // 计算计划时预测的当日结束湿度
+	ETL               float64   `json:"et_l,omitempty"`               // 预测蒸散量 (升)
+	RainL             float64   `json:"rain_l,omitempty"`             // 预测降雨补充 (升)
+	Cost              PlanCost  `json:"cost"`
+	Locked            bool      `json:"locked"`     // 灌溉量由人工指定
+	VersionID         int64     `json:"version_id"` // 所属计划版本，0 为版本化之前的计划
+}
+
+// PlanCost is the planner's cost of one day split into its components
+type PlanCost struct {
+	Deviation  float64 `json:"deviation"`  // 湿度偏离最优区间中心
+	Water      float64 `json:"water"`      // 用水量
+	Smoothness float64 `json:"smoothness"` // 与前一天灌溉量的变化
+	Total      float64 `json:"total"`
 }
 
 // DeviceLog represents a device log record
@@ -60,14 +79,20 @@ type DeviceLocation struct {
 
 // DeviceCommand represents a command to be executed by device
 type DeviceCommand struct {
-	ID          int64      `json:"id"`
-	DeviceID    string     `json:"device_id"`
-	CommandType string     `json:"command_type"` // irrigate, toggle_shade, update_config
-	Parameters  *string    `json:"parameters,omitempty"`
-	Status      string     `json:"status"` // pending, executing, completed, failed
-	CreatedAt   time.Time  `json:"created_at"`
-	ExecutedAt  *time.Time `json:"executed_at,omitempty"`
-	Result      *string    `json:"result,omitempty"` // 执行结果或错误信息
+	ID             int64      `json:"id"`
+	DeviceID       string     `json:"device_id"`
+	CommandType    string     `json:"command_type"` // irrigate, set_shade, update_config, reboot, ota_update
+	Parameters     *string    `json:"parameters,omitempty"`
+	Status         string     `json:"status"` // pending, delivered, executing, completed, failed, expired, cancelled
+	CreatedAt      time.Time  `json:"created_at"`
+	ExecutedAt     *time.Time `json:"executed_at,omitempty"`
+	Result         *string    `json:"result,omitempty"`  // 执行结果或错误信息
+	PlanID         *int64     `json:"plan_id,omitempty"` // 由计划自动下发时关联的计划ID
+	Attempts       int        `json:"attempts"`          // 已下发次数，含重新下发（最多 1+max_retries 次，浇水命令只下发1次）
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	IdempotencyKey string     `json:"idempotency_key"` // 设备上报状态时回传
+	IssuedBy       string     `json:"issued_by,omitempty"`
 }
 
 // DeviceStatus represents the current device status (for API response)
@@ -83,6 +108,29 @@ type DeviceStatus struct {
 	TodayPlan    TodayPlanInfo      `json:"today_plan"`
 }
 
+// SoilSample is a soil moisture reading used to compare against predictions
+type SoilSample struct {
+	Timestamp time.Time
+	SoilRaw   int
+}
+
+// PumpSample is a pump state reading used for volume accounting
+type PumpSample struct {
+	Timestamp time.Time
+	PumpState string
+}
+
+// DailyIrrigationVolume represents the executed irrigation volume of a device for one day
+type DailyIrrigationVolume struct {
+	DeviceID        string    `json:"device_id"`
+	Date            string    `json:"date"`
+	CommandVolumeL  float64   `json:"command_volume_l"` // 已完成灌溉命令上报的水量
+	PumpRuntimeSec  float64   `json:"pump_runtime_sec"` // 根据水泵开关状态推算的运行时长
+	PumpVolumeL     float64   `json:"pump_volume_l"`    // 运行时长 × 水泵流量
+	ExecutedVolumeL float64   `json:"executed_volume_l"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
 // TodayPlanInfo contains today's irrigation plan information
 type TodayPlanInfo struct {
 	PlannedVolumeL  float64 `json:"planned_volume_l"`
@@ -91,15 +139,16 @@ type TodayPlanInfo struct {
 
 // DeviceDataRequest represents the device data upload request
 type DeviceDataRequest struct {
-	DeviceID     string   `json:"device_id" binding:"required"`
-	Timestamp    string   `json:"timestamp" binding:"required"`
-	TemperatureC *float64 `json:"temperature_c"`
-	HumidityPct  *float64 `json:"humidity_pct"`
-	SoilRaw      *int     `json:"soil_raw"`
-	RainAnalog   *int     `json:"rain_analog"`
-	RainDigital  *int     `json:"rain_digital"`
-	PumpState    string   `json:"pump_state"`
-	ShadeState   string   `json:"shade_state"`
+	DeviceID     string          `json:"device_id" binding:"required"`
+	Timestamp    string          `json:"timestamp" binding:"required"`
+	TemperatureC *float64        `json:"temperature_c"`
+	HumidityPct  *float64        `json:"humidity_pct"`
+	SoilRaw      *int            `json:"soil_raw"`
+	RainAnalog   *int            `json:"rain_analog"`
+	RainDigital  *int            `json:"rain_digital"`
+	PumpState    string          `json:"pump_state"`
+	ShadeState   string          `json:"shade_state"`
+	Config       *DeviceSettings `json:"config,omitempty"` // 设备当前生效的配置，旧固件不上报
 }
 
 // DeviceDataResponse represents the response to device data upload
@@ -111,9 +160,10 @@ type DeviceDataResponse struct {
 
 // CommandExecutionRequest represents ESP32 reporting command execution status
 type CommandExecutionRequest struct {
-	CommandID int64  `json:"command_id" binding:"required"`
-	Status    string `json:"status" binding:"required"` // executing, completed, failed
-	Result    string `json:"result"`                    // 执行结果或错误信息
+	CommandID      int64  `json:"command_id" binding:"required"`
+	Status         string `json:"status" binding:"required,oneof=executing completed failed"`
+	Result         string `json:"result"`          // 执行结果或错误信息
+	IdempotencyKey string `json:"idempotency_key"` // 命令下发时的幂等键，旧固件可不传
 }
 
 // IrrigateRequest represents a manual irrigation request
@@ -122,6 +172,62 @@ type IrrigateRequest struct {
 	Reason  string  `json:"reason"`
 }
 
+// SetShadeRequest represents a set_shade command
+type SetShadeRequest struct {
+	Mode string `json:"mode" binding:"required,oneof=open closed auto"` // auto: 按温度阈值自动控制
+}
+
+// DeviceConfigRequest represents an update_config command. Only the given
+// settings are changed on the device.
+type DeviceConfigRequest struct {
+	ReportIntervalS  *int     `json:"report_interval_s,omitempty" binding:"omitempty,gte=5,lte=3600"`
+	ShadeOnTemp      *float64 `json:"shade_on_temp,omitempty" binding:"omitempty,gte=-20,lte=60"`  // 高于此温度开启遮阳
+	ShadeOffTemp     *float64 `json:"shade_off_temp,omitempty" binding:"omitempty,gte=-20,lte=60"` // 低于此温度关闭遮阳
+	SoilDryThreshold *int     `json:"soil_dry_threshold,omitempty" binding:"omitempty,gte=0,lte=4095"`
+}
+
+// DeviceSettings are the firmware settings managed remotely. It has the
+// fields of DeviceConfigRequest; nil means not set.
+type DeviceSettings struct {
+	ReportIntervalS  *int     `json:"report_interval_s,omitempty"`
+	ShadeOnTemp      *float64 `json:"shade_on_temp,omitempty"`
+	ShadeOffTemp     *float64 `json:"shade_off_temp,omitempty"`
+	SoilDryThreshold *int     `json:"soil_dry_threshold,omitempty"`
+}
+
+// DeviceTwin holds the settings a device should have and the settings it
+// last reported
+type DeviceTwin struct {
+	DeviceID         string          `json:"device_id"`
+	Desired          DeviceSettings  `json:"desired"`
+	DesiredUpdatedAt *time.Time      `json:"desired_updated_at,omitempty"`
+	DesiredUpdatedBy string          `json:"desired_updated_by,omitempty"`
+	Reported         *DeviceSettings `json:"reported,omitempty"` // 设备从未上报时为空
+	ReportedAt       *time.Time      `json:"reported_at,omitempty"`
+	LastCommandID    *int64          `json:"last_command_id,omitempty"` // 最近一次为收敛下发的 update_config 命令
+	Drift            []SettingDrift  `json:"drift"`
+	Converged        bool            `json:"converged"`
+}
+
+// SettingDrift is a desired setting the device has not applied
+type SettingDrift struct {
+	Setting  string      `json:"setting"`
+	Desired  interface{} `json:"desired"`
+	Reported interface{} `json:"reported"`
+}
+
+// RebootRequest represents a reboot command
+type RebootRequest struct {
+	Reason string `json:"reason,omitempty" binding:"max=200"`
+}
+
+// OTAUpdateRequest represents an ota_update command
+type OTAUpdateRequest struct {
+	URL     string `json:"url" binding:"required,url,max=500"`
+	SHA256  string `json:"sha256" binding:"required,len=64,hexadecimal"` // 固件文件校验和
+	Version string `json:"version,omitempty" binding:"max=32"`
+}
+
 // UpdateLocationRequest represents a location update request
 type UpdateLocationRequest struct {
 	Latitude  float64 `json:"latitude" binding:"required"`
@@ -129,16 +235,300 @@ type UpdateLocationRequest struct {
 	Address   *string `json:"address"`
 }
 
-// UpdateForecastRequest represents a forecast update request
+// UpdateForecastRequest represents a forecast update request.
+// 提供 device_id 时使用该设备登记的位置，否则使用请求中的经纬度
 type UpdateForecastRequest struct {
-	Latitude  float64 `json:"latitude" binding:"required"`
-	Longitude float64 `json:"longitude" binding:"required"`
+	DeviceID  string   `json:"device_id"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
 }
 
 // DailyPlan represents a single day's irrigation plan (for API response)
 type DailyPlan struct {
-	Date           string  `json:"date"`
-	PlannedVolumeL float64 `json:"planned_volume_l"`
+	Date              string   `json:"date"`
+	PlannedVolumeL    float64  `json:"planned_volume_l"`
+	ExecutedVolumeL   float64  `json:"executed_volume_l"`
+	StartMoisture     float64  `json:"start_moisture"`
+	PredictedMoisture float64  `json:"predicted_moisture"`
+	ETL               float64  `json:"et_l"`
+	RainL             float64  `json:"rain_l"`
+	Cost              PlanCost `json:"cost"`
+	Locked            bool     `json:"locked"`
+}
+
+// WateringSchedule represents the daily watering windows of a device
+type WateringSchedule struct {
+	DeviceID  string    `json:"device_id"`
+	Windows   []string  `json:"windows"` // HH:MM，计划水量按窗口平均分成多次脉冲
+	Enabled   bool      `json:"enabled"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UpdateWateringScheduleRequest represents a watering schedule update request
+type UpdateWateringScheduleRequest struct {
+	Windows []string `json:"windows" binding:"required,min=1"`
+	Enabled *bool    `json:"enabled"`
+}
+
+// PlanDispatch records what the plan executor did for one watering window
+type PlanDispatch struct {
+	ID        int64     `json:"id"`
+	DeviceID  string    `json:"device_id"`
+	Date      string    `json:"date"`
+	Window    string    `json:"window"`
+	PlanID    *int64    `json:"plan_id,omitempty"`
+	CommandID *int64    `json:"command_id,omitempty"`
+	VolumeL   float64   `json:"volume_l"`
+	Status    string    `json:"status"` // dispatched, skipped_rain
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PlannerProfile represents the planner parameters of one device
+type PlannerProfile struct {
+	DeviceID            string     `json:"device_id"`
+	Source              string     `json:"source"` // device, default
+	Crop                string     `json:"crop,omitempty"`
+	SoilType            string     `json:"soil_type,omitempty"`
+	PotSizeL            float64    `json:"pot_size_l,omitempty"`
+	SoilOptimalMin      int        `json:"soil_optimal_min"`
+	SoilOptimalMax      int        `json:"soil_optimal_max"`
+	MaxIrrigationPerDay float64    `json:"max_irrigation_per_day"`
+	BaseET              float64    `json:"base_et"`
+	TempFactor          float64    `json:"temp_factor"`
+	RainConversion      float64    `json:"rain_conversion"`
+	ADCToMoisture       float64    `json:"adc_to_moisture"`
+	CostW1              float64    `json:"cost_w1"`
+	CostW2              float64    `json:"cost_w2"`
+	CostW3              float64    `json:"cost_w3"`
+	ETModel             string     `json:"et_model"` // linear, fao56
+	CanopyAreaM2        float64    `json:"canopy_area_m2"`
+	PlantingDate        string     `json:"planting_date,omitempty"` // YYYY-MM-DD，用于计算作物生长阶段
+	VolumeBudgetL       float64    `json:"volume_budget_l"`         // 规划期内总灌溉量上限，0 表示不限
+	AllowedWeekdays     []string   `json:"allowed_weekdays"`        // 允许浇水的星期，空表示每天
+	MinDaysBetween      int        `json:"min_days_between"`        // 两个浇水日之间至少间隔的天数
+	MoistureFloor       int        `json:"moisture_floor"`          // 预测湿度下限 (ADC值)，0 表示不限
+	RiskAversion        float64    `json:"risk_aversion"`           // 降雨不确定时的风险厌恶系数，0 表示只看期望代价
+	MoistureStep        int        `json:"moisture_step,omitempty"` // 以下为全局规划设置，只记录在计划版本中
+	VolumeStep          float64    `json:"volume_step,omitempty"`
+	EnsembleSize        int        `json:"ensemble_size,omitempty"`
+	UpdatedAt           *time.Time `json:"updated_at,omitempty"`
+}
+
+// UpdatePlannerProfileRequest represents a planner profile update request.
+// Crop, soil type and pot size derive the parameters from the defaults;
+// explicitly given parameters override the derived values.
+type UpdatePlannerProfileRequest struct {
+	Crop                string   `json:"crop"`
+	SoilType            string   `json:"soil_type"`
+	PotSizeL            float64  `json:"pot_size_l" binding:"gte=0"`
+	SoilOptimalMin      *int     `json:"soil_optimal_min"`
+	SoilOptimalMax      *int     `json:"soil_optimal_max"`
+	MaxIrrigationPerDay *float64 `json:"max_irrigation_per_day"`
+	BaseET              *float64 `json:"base_et"`
+	TempFactor          *float64 `json:"temp_factor"`
+	RainConversion      *float64 `json:"rain_conversion"`
+	ADCToMoisture       *float64 `json:"adc_to_moisture"`
+	CostW1              *float64 `json:"cost_w1"`
+	CostW2              *float64 `json:"cost_w2"`
+	CostW3              *float64 `json:"cost_w3"`
+	ETModel             string   `json:"et_model" binding:"omitempty,oneof=linear fao56"`
+	CanopyAreaM2        *float64 `json:"canopy_area_m2"`
+	PlantingDate        string   `json:"planting_date"`
+	VolumeBudgetL       *float64 `json:"volume_budget_l"`
+	AllowedWeekdays     []string `json:"allowed_weekdays"`
+	MinDaysBetween      *int     `json:"min_days_between"`
+	MoistureFloor       *int     `json:"moisture_floor"`
+	RiskAversion        *float64 `json:"risk_aversion"`
+}
+
+// PlanOverride pins the irrigation volume of one plan day. Recomputation
+// keeps it as a fixed decision.
+type PlanOverride struct {
+	ID        int64     `json:"id"`
+	DeviceID  string    `json:"device_id"`
+	Date      string    `json:"date"`
+	VolumeL   float64   `json:"volume_l"` // 0 表示当天不浇水
+	Reason    string    `json:"reason"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SetPlanOverrideRequest represents a plan override request
+type SetPlanOverrideRequest struct {
+	VolumeL *float64 `json:"volume_l" binding:"required,gte=0"`
+	Reason  string   `json:"reason" binding:"max=200"`
+}
+
+// PlanVersion records one plan computation with its trigger and inputs
+type PlanVersion struct {
+	ID                 int64           `json:"id"`
+	DeviceID           string          `json:"device_id"`
+	Version            int             `json:"version"`
+	Reason             string          `json:"reason"` // manual, schedule, drift, override
+	SoilReading        int             `json:"soil_reading"`
+	SoilReadingAt      *time.Time      `json:"soil_reading_at,omitempty"`
+	Drift              *float64        `json:"drift,omitempty"`
+	ForecastSnapshotID int64           `json:"forecast_snapshot_id"`
+	Params             *PlannerProfile `json:"params"`
+	Days               int             `json:"days"`
+	TotalVolumeL       float64         `json:"total_volume_l"`
+	TotalCost          float64         `json:"total_cost"`
+	CreatedAt          time.Time       `json:"created_at"`
+}
+
+// ForecastSnapshot is the forecast a plan was computed from
+type ForecastSnapshot struct {
+	ID          int64                `json:"id"`
+	LocationKey string               `json:"location_key"`
+	Days        []PlannerForecastDay `json:"days"`
+	CreatedAt   time.Time            `json:"created_at"`
+}
+
+// PlanVersionDiff compares two plan versions of a device
+type PlanVersionDiff struct {
+	From              *PlanVersion  `json:"from"`
+	To                *PlanVersion  `json:"to"`
+	SoilReadingDelta  int           `json:"soil_reading_delta"`
+	ForecastChanged   bool          `json:"forecast_changed"`
+	ParamChanges      []ParamChange `json:"param_changes"`
+	Days              []PlanDayDiff `json:"days"`
+	TotalVolumeDeltaL float64       `json:"total_volume_delta_l"`
+	TotalCostDelta    float64       `json:"total_cost_delta"`
+}
+
+// ParamChange is a planner parameter that differs between two versions
+type ParamChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// PlanDayDiff compares one day of two plan versions; values are nil when
+// the version does not cover the day
+type PlanDayDiff struct {
+	Date                  string   `json:"date"`
+	FromVolumeL           *float64 `json:"from_volume_l"`
+	ToVolumeL             *float64 `json:"to_volume_l"`
+	VolumeDeltaL          float64  `json:"volume_delta_l"`
+	FromPredictedMoisture *float64 `json:"from_predicted_moisture"`
+	ToPredictedMoisture   *float64 `json:"to_predicted_moisture"`
+	FromRainL             *float64 `json:"from_rain_l"`
+	ToRainL               *float64 `json:"to_rain_l"`
+	FromLocked            bool     `json:"from_locked"`
+	ToLocked              bool     `json:"to_locked"`
+}
+
+// SimulatePlanRequest represents a what-if plan request. Omitted inputs fall
+// back to the device's latest reading, stored forecast and current profile.
+type SimulatePlanRequest struct {
+	InitialSoilMoisture *int                         `json:"initial_soil_moisture" binding:"omitempty,gte=0,lte=4095"`
+	Forecast            []PlannerForecastDay         `json:"forecast" binding:"omitempty,max=30,dive"`
+	Params              *UpdatePlannerProfileRequest `json:"params"`
+}
+
+// PlannerForecastDay is one forecast day as input to the planner
+type PlannerForecastDay struct {
+	Date          string   `json:"date" binding:"required"`
+	TempMax       float64  `json:"temp_max"`
+	TempMin       float64  `json:"temp_min"`
+	PrecipMm      float64  `json:"precip_mm" binding:"gte=0"`
+	HumidityPct   *float64 `json:"humidity_pct" binding:"omitempty,gte=0,lte=100"`
+	PrecipProbPct *float64 `json:"precip_prob_pct" binding:"omitempty,gte=0,lte=100"`
+}
+
+// PlanSimulation is the result of a what-if plan; nothing of it is stored
+type PlanSimulation struct {
+	InitialSoilMoisture int               `json:"initial_soil_moisture"`
+	ForecastSource      string            `json:"forecast_source"` // stored, request
+	Profile             *PlannerProfile   `json:"profile"`
+	Plans               []IrrigationPlan  `json:"-"`
+	Trajectory          []TrajectoryPoint `json:"trajectory"`
+	TotalVolumeL        float64           `json:"total_volume_l"`
+	TotalCost           float64           `json:"total_cost"`
+}
+
+// PlanTrajectory is the moisture trajectory predicted when a plan was computed
+type PlanTrajectory struct {
+	ID            int64             `json:"id"`
+	DeviceID      string            `json:"device_id"`
+	Reason        string            `json:"reason"` // manual, schedule, drift, override
+	StartMoisture int               `json:"start_moisture"`
+	Drift         *float64          `json:"drift,omitempty"` // 触发重新规划时的偏差 (ADC值)
+	CreatedAt     time.Time         `json:"created_at"`
+	Points        []TrajectoryPoint `json:"points"`
+}
+
+// TrajectoryPoint is the predicted end-of-day moisture for one plan day
+type TrajectoryPoint struct {
+	Date              string  `json:"date"`
+	PredictedMoisture float64 `json:"predicted_moisture"`
+	PlannedVolumeL    float64 `json:"planned_volume_l"`
+}
+
+// MoistureComparison compares predicted and observed end-of-day moisture
+type MoistureComparison struct {
+	Date              string   `json:"date"`
+	PredictedMoisture *float64 `json:"predicted_moisture"`
+	ObservedMoisture  *float64 `json:"observed_moisture"`
+	Drift             *float64 `json:"drift"` // observed - predicted
+}
+
+// DriftStatus compares the latest reading with the active trajectory
+type DriftStatus struct {
+	PredictedMoisture float64   `json:"predicted_moisture"`
+	ObservedMoisture  float64   `json:"observed_moisture"`
+	Drift             float64   `json:"drift"` // observed - predicted
+	Threshold         int       `json:"threshold"`
+	ObservedAt        time.Time `json:"observed_at"`
+}
+
+// CalibrationRun is a fitted set of planner coefficients awaiting admin review
+type CalibrationRun struct {
+	ID             int64      `json:"id"`
+	DeviceID       string     `json:"device_id"`
+	Status         string     `json:"status"` // pending, applied, rejected
+	StartDate      string     `json:"start_date"`
+	EndDate        string     `json:"end_date"`
+	Samples        int        `json:"samples"` // 参与拟合的天数
+	WeatherSource  string     `json:"weather_source"`
+	BaseET         float64    `json:"base_et"`
+	TempFactor     float64    `json:"temp_factor"`
+	RainConversion float64    `json:"rain_conversion"`
+	ADCToMoisture  float64    `json:"adc_to_moisture"`
+	Fixed          []string   `json:"fixed"` // 数据不足、沿用原值的参数
+	RSquared       float64    `json:"r_squared"`
+	RMSE           float64    `json:"rmse"`
+	BaselineRMSE   float64    `json:"baseline_rmse"` // 当前参数的误差
+	CreatedAt      time.Time  `json:"created_at"`
+	ReviewedAt     *time.Time `json:"reviewed_at,omitempty"`
+}
+
+// RunCalibrationRequest represents a request to calibrate one device now
+type RunCalibrationRequest struct {
+	DeviceID string `json:"device_id" binding:"required"`
+}
+
+// ========== 定时任务相关模型 ==========
+
+// JobRun represents one execution of a scheduled job
+type JobRun struct {
+	ID         int64      `json:"id"`
+	JobName    string     `json:"job_name"`
+	Trigger    string     `json:"trigger"` // schedule, manual
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Status     string     `json:"status"` // running, success, failed
+	Message    *string    `json:"message,omitempty"`
+}
+
+// JobInfo describes a registered scheduled job (for API response)
+type JobInfo struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Paused   bool       `json:"paused"`
+	Running  bool       `json:"running"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+	LastRun  *JobRun    `json:"last_run,omitempty"`
 }
 
 // ========== 用户认证相关模型 ==========
@@ -163,6 +553,52 @@ type Device struct {
 	UpdatedAt  time.Time `json:"updated_at"`
 }
 
+// DeviceCredentials describes a device's API key. Only hashes and the
+// encrypted signing secret are stored; the key itself is returned once when
+// it is issued.
+type DeviceCredentials struct {
+	DeviceID              string     `json:"device_id"`
+	Provisioned           bool       `json:"provisioned"` // 已分配专属密钥，不再接受共享密钥
+	KeyHash               *string    `json:"-"`
+	IssuedAt              *time.Time `json:"issued_at,omitempty"`
+	PreviousKeyHash       *string    `json:"-"`
+	PreviousKeyExpiresAt  *time.Time `json:"previous_key_expires_at,omitempty"` // 轮换前旧密钥的失效时间
+	RevokedAt             *time.Time `json:"revoked_at,omitempty"`
+	SigningEnabled        bool       `json:"signing_enabled"` // 当前密钥可用于请求签名；此前分配的密钥需轮换
+	SigningSecret         *string    `json:"-"`               // 加密的请求签名密钥
+	PreviousSigningSecret *string    `json:"-"`
+}
+
+// QuarantinedData is a data upload from a device that is not registered
+type QuarantinedData struct {
+	ID         int64     `json:"id"`
+	DeviceID   string    `json:"device_id"`
+	Payload    string    `json:"payload"` // 设备上报的原始数据 (JSON)
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// QuarantinedDevice summarizes the quarantined uploads of one device
+type QuarantinedDevice struct {
+	DeviceID  string    `json:"device_id"`
+	Uploads   int       `json:"uploads"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// ClaimDeviceRequest represents a request to register a quarantined device.
+// The account fields are required unless the device was registered since.
+type ClaimDeviceRequest struct {
+	Username    string `json:"username" binding:"omitempty,min=3,max=20"`
+	Password    string `json:"password" binding:"omitempty,min=6"`
+	DeviceName  string `json:"device_name"`
+	DiscardData bool   `json:"discard_data"` // 不导入隔离期间的数据
+}
+
+// RotateDeviceKeyRequest represents a request to rotate a device's API key
+type RotateDeviceKeyRequest struct {
+	GraceHours *int `json:"grace_hours" binding:"omitempty,min=0,max=720"` // 旧密钥继续有效的小时数，默认使用配置值
+}
+
 // LoginRequest represents a login request
 type LoginRequest struct {
 	Username string `json:"username"`
@@ -0,0 +1,262 @@
+package planner
+
+import (
+	"fmt"
+	"math"
+)
+
+// CalibrationSample is one observed day used to fit the moisture model
+type CalibrationSample struct {
+	Date          string
+	StartMoisture float64 // 前一天结束时的实测湿度 (ADC值)
+	EndMoisture   float64 // 当天结束时的实测湿度 (ADC值)
+	IrrigationL   float64 // 当天实际灌溉水量
+	TempAvg       float64
+	PrecipMm      float64
+}
+
+// CalibrationResult holds fitted model coefficients and the goodness of fit
+type CalibrationResult struct {
+	BaseET         float64
+	TempFactor     float64
+	RainConversion float64
+	ADCToMoisture  float64
+	Fixed          []string // 数据不足以辨识、沿用原值的参数
+	Samples        int
+	RSquared       float64 // 每日湿度变化的决定系数
+	RMSE           float64 // 每日湿度变化的均方根误差 (ADC值)
+	BaselineRMSE   float64 // 原参数的均方根误差，用于对比
+}
+
+// minVarianceSamples is the number of days with a non-zero value required
+// before irrigation or rain coefficients are fitted
+const minVarianceSamples = 3
+
+// Calibrate fits BaseET, TempFactor, RainConversion and ADCToMoisture to the
+// observed daily moisture changes by ordinary least squares.
+//
+// The daily model is linear in the inputs:
+//
+//	ΔM = ADCToMoisture × (Irrigation + RainConversion×Precip − BaseET − TempFactor×(Tavg−20))
+//
+// Coefficients the data cannot identify (no irrigation, no rain or no
+// temperature spread) keep their values from base.
+func Calibrate(base PlannerConfig, samples []CalibrationSample) (*CalibrationResult, error) {
+	var usable []CalibrationSample
+	for _, s := range samples {
+		// 传感器饱和时湿度变化不可信
+		if s.StartMoisture <= 0 || s.StartMoisture >= maxADC || s.EndMoisture <= 0 || s.EndMoisture >= maxADC {
+			continue
+		}
+		usable = append(usable, s)
+	}
+
+	fitADC := countNonZero(usable, func(s CalibrationSample) float64 { return s.IrrigationL }) >= minVarianceSamples
+	fitRain := countNonZero(usable, func(s CalibrationSample) float64 { return s.PrecipMm }) >= minVarianceSamples
+	fitTemp := spread(usable, func(s CalibrationSample) float64 { return s.TempAvg }) >= 2.0
+
+	result := &CalibrationResult{
+		BaseET:         base.BaseET,
+		TempFactor:     base.TempFactor,
+		RainConversion: base.RainConversion,
+		ADCToMoisture:  base.ADCToMoisture,
+		Samples:        len(usable),
+	}
+	if !fitADC {
+		result.Fixed = append(result.Fixed, "adc_to_moisture")
+	}
+	if !fitRain {
+		result.Fixed = append(result.Fixed, "rain_conversion")
+	}
+	if !fitTemp {
+		result.Fixed = append(result.Fixed, "temp_factor")
+	}
+
+	// 列：常数项、[温度]、[降雨]、[灌溉（含固定项）]
+	cols := 1
+	if fitTemp {
+		cols++
+	}
+	if fitRain {
+		cols++
+	}
+	if fitADC {
+		cols++
+	}
+	if len(usable) < cols+2 {
+		return nil, fmt.Errorf("not enough usable days: %d, need at least %d", len(usable), cols+2)
+	}
+
+	xs := make([][]float64, len(usable))
+	ys := make([]float64, len(usable))
+	for i, s := range usable {
+		dT := s.TempAvg - 20.0
+		delta := s.EndMoisture - s.StartMoisture
+
+		// 固定参数的贡献（单位：升）
+		fixedL := 0.0
+		if !fitTemp {
+			fixedL -= base.TempFactor * dT
+		}
+		if !fitRain {
+			fixedL += base.RainConversion * s.PrecipMm
+		}
+
+		row := []float64{1}
+		if fitTemp {
+			row = append(row, dT)
+		}
+		if fitRain {
+			row = append(row, s.PrecipMm)
+		}
+		if fitADC {
+			row = append(row, s.IrrigationL+fixedL)
+			ys[i] = delta
+		} else {
+			// ADCToMoisture 固定时直接拟合以升为单位的水量平衡
+			ys[i] = delta/base.ADCToMoisture - s.IrrigationL - fixedL
+		}
+		xs[i] = row
+	}
+
+	coef, err := leastSquares(xs, ys)
+	if err != nil {
+		return nil, err
+	}
+
+	// 把线性系数换算回模型参数
+	scale := 1.0
+	if fitADC {
+		scale = coef[len(coef)-1]
+		if scale <= 0 {
+			return nil, fmt.Errorf("fitted adc_to_moisture is not positive (%.3f), irrigation has no visible effect", scale)
+		}
+		result.ADCToMoisture = scale
+	}
+	idx := 0
+	result.BaseET = -coef[idx] / scale
+	idx++
+	if fitTemp {
+		result.TempFactor = -coef[idx] / scale
+		idx++
+	}
+	if fitRain {
+		result.RainConversion = coef[idx] / scale
+	}
+
+	fitted := base
+	fitted.BaseET = result.BaseET
+	fitted.TempFactor = result.TempFactor
+	fitted.RainConversion = result.RainConversion
+	fitted.ADCToMoisture = result.ADCToMoisture
+	if err := fitted.Validate(); err != nil {
+		return nil, fmt.Errorf("fitted parameters are not usable: %w", err)
+	}
+
+	result.RMSE, result.RSquared = goodnessOfFit(fitted, usable)
+	result.BaselineRMSE, _ = goodnessOfFit(base, usable)
+	return result, nil
+}
+
+// predictedDelta is the unclamped daily moisture change of the model
+func predictedDelta(c PlannerConfig, s CalibrationSample) float64 {
+	et := c.BaseET + c.TempFactor*(s.TempAvg-20.0)
+	return c.ADCToMoisture * (s.IrrigationL + c.RainConversion*s.PrecipMm - et)
+}
+
+// goodnessOfFit returns the RMSE and R² of the model's daily moisture changes
+func goodnessOfFit(c PlannerConfig, samples []CalibrationSample) (float64, float64) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+
+	mean := 0.0
+	for _, s := range samples {
+		mean += s.EndMoisture - s.StartMoisture
+	}
+	mean /= float64(len(samples))
+
+	var rss, tss float64
+	for _, s := range samples {
+		delta := s.EndMoisture - s.StartMoisture
+		residual := delta - predictedDelta(c, s)
+		rss += residual * residual
+		tss += (delta - mean) * (delta - mean)
+	}
+
+	rmse := math.Sqrt(rss / float64(len(samples)))
+	r2 := 0.0
+	if tss > 0 {
+		r2 = 1 - rss/tss
+	}
+	return rmse, r2
+}
+
+// leastSquares solves min ||X·b − y||² via the normal equations
+func leastSquares(xs [][]float64, ys []float64) ([]float64, error) {
+	n := len(xs[0])
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = make([]float64, n+1)
+	}
+	for r, row := range xs {
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				a[i][j] += row[i] * row[j]
+			}
+			a[i][n] += row[i] * ys[r]
+		}
+	}
+
+	// 高斯消元（部分主元）
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-9 {
+			return nil, fmt.Errorf("calibration data is degenerate, inputs are collinear")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+
+		for r := 0; r < n; r++ {
+			if r == col {
+				continue
+			}
+			f := a[r][col] / a[col][col]
+			for k := col; k <= n; k++ {
+				a[r][k] -= f * a[col][k]
+			}
+		}
+	}
+
+	coef := make([]float64, n)
+	for i := range coef {
+		coef[i] = a[i][n] / a[i][i]
+	}
+	return coef, nil
+}
+
+func countNonZero(samples []CalibrationSample, value func(CalibrationSample) float64) int {
+	n := 0
+	for _, s := range samples {
+		if value(s) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func spread(samples []CalibrationSample, value func(CalibrationSample) float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range samples {
+		lo = math.Min(lo, value(s))
+		hi = math.Max(hi, value(s))
+	}
+	return hi - lo
+}
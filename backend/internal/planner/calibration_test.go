@@ -0,0 +1,200 @@
+package planner
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// calibrationTruth holds the coefficients the synthetic samples are drawn from
+func calibrationTruth() PlannerConfig {
+	truth := testConfig()
+	truth.BaseET = 1.5
+	truth.TempFactor = 0.08
+	truth.RainConversion = 0.6
+	truth.ADCToMoisture = 35
+	return truth
+}
+
+// sampleOptions selects which inputs vary in the synthetic samples
+type sampleOptions struct {
+	noRain       bool
+	noIrrigation bool
+	constantTemp bool
+	noise        float64 // 湿度变化的高斯噪声标准差 (ADC值)
+}
+
+// calibrationSamples simulates days whose moisture changes follow truth
+func calibrationSamples(truth PlannerConfig, days int, opts sampleOptions, seed int64) []CalibrationSample {
+	rng := rand.New(rand.NewSource(seed))
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	samples := make([]CalibrationSample, days)
+	for i := range samples {
+		s := CalibrationSample{
+			Date:          start.AddDate(0, 0, i).Format("2006-01-02"),
+			StartMoisture: 1500 + rng.Float64()*1000,
+			TempAvg:       25,
+		}
+		if !opts.constantTemp {
+			s.TempAvg = 15 + rng.Float64()*20
+		}
+		if !opts.noIrrigation && rng.Float64() < 0.5 {
+			s.IrrigationL = 1 + rng.Float64()*3
+		}
+		if !opts.noRain && rng.Float64() < 0.3 {
+			s.PrecipMm = rng.Float64() * 10
+		}
+		s.EndMoisture = s.StartMoisture + predictedDelta(truth, s) + rng.NormFloat64()*opts.noise
+		samples[i] = s
+	}
+	return samples
+}
+
+func TestCalibrateRecoversCoefficients(t *testing.T) {
+	tests := []struct {
+		name  string
+		noise float64
+		days  int
+		tol   float64 // 相对误差
+	}{
+		{name: "exact", days: 20, tol: 1e-6},
+		{name: "noisy", noise: 15, days: 120, tol: 0.1},
+	}
+
+	truth := calibrationTruth()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := calibrationSamples(truth, tt.days, sampleOptions{noise: tt.noise}, 1)
+			result, err := Calibrate(testConfig(), samples)
+			if err != nil {
+				t.Fatalf("Calibrate: %v", err)
+			}
+			if len(result.Fixed) != 0 {
+				t.Errorf("fixed %v, want every coefficient fitted", result.Fixed)
+			}
+
+			got := map[string][2]float64{
+				"base_et":         {result.BaseET, truth.BaseET},
+				"temp_factor":     {result.TempFactor, truth.TempFactor},
+				"rain_conversion": {result.RainConversion, truth.RainConversion},
+				"adc_to_moisture": {result.ADCToMoisture, truth.ADCToMoisture},
+			}
+			for name, v := range got {
+				if math.Abs(v[0]-v[1]) > tt.tol*v[1] {
+					t.Errorf("%s = %.4f, want %.4f", name, v[0], v[1])
+				}
+			}
+			if result.RMSE > tt.noise*1.2+1e-6 || result.RMSE >= result.BaselineRMSE {
+				t.Errorf("RMSE %.2f, baseline %.2f", result.RMSE, result.BaselineRMSE)
+			}
+			if tt.noise == 0 && math.Abs(result.RSquared-1) > 1e-9 {
+				t.Errorf("R² = %.6f, want 1", result.RSquared)
+			}
+		})
+	}
+}
+
+func TestCalibrateFixedCoefficients(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  sampleOptions
+		fixed string
+	}{
+		{name: "no rain", opts: sampleOptions{noRain: true}, fixed: "rain_conversion"},
+		{name: "no irrigation", opts: sampleOptions{noIrrigation: true}, fixed: "adc_to_moisture"},
+		{name: "no temperature spread", opts: sampleOptions{constantTemp: true}, fixed: "temp_factor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 固定参数沿用真值，其余参数应精确还原
+			truth := calibrationTruth()
+			base := testConfig()
+			switch tt.fixed {
+			case "rain_conversion":
+				base.RainConversion = truth.RainConversion
+			case "adc_to_moisture":
+				base.ADCToMoisture = truth.ADCToMoisture
+			case "temp_factor":
+				base.TempFactor = truth.TempFactor
+			}
+
+			result, err := Calibrate(base, calibrationSamples(truth, 30, tt.opts, 2))
+			if err != nil {
+				t.Fatalf("Calibrate: %v", err)
+			}
+			if len(result.Fixed) != 1 || result.Fixed[0] != tt.fixed {
+				t.Fatalf("fixed %v, want [%s]", result.Fixed, tt.fixed)
+			}
+			for name, v := range map[string][2]float64{
+				"base_et":         {result.BaseET, truth.BaseET},
+				"temp_factor":     {result.TempFactor, truth.TempFactor},
+				"rain_conversion": {result.RainConversion, truth.RainConversion},
+				"adc_to_moisture": {result.ADCToMoisture, truth.ADCToMoisture},
+			} {
+				if math.Abs(v[0]-v[1]) > 1e-6*math.Max(1, v[1]) {
+					t.Errorf("%s = %.6f, want %.6f", name, v[0], v[1])
+				}
+			}
+		})
+	}
+}
+
+func TestCalibrateRejectsUnusableData(t *testing.T) {
+	truth := calibrationTruth()
+
+	t.Run("saturated readings", func(t *testing.T) {
+		samples := calibrationSamples(truth, 20, sampleOptions{}, 3)
+		for i := range samples[:18] {
+			samples[i].EndMoisture = maxADC
+		}
+		if _, err := Calibrate(testConfig(), samples); err == nil || !strings.Contains(err.Error(), "not enough usable days: 2") {
+			t.Errorf("got %v, want not enough usable days", err)
+		}
+	})
+
+	t.Run("collinear inputs", func(t *testing.T) {
+		// 灌溉量总是降雨量的固定倍数，两者的系数无法区分
+		samples := calibrationSamples(truth, 20, sampleOptions{}, 4)
+		for i := range samples {
+			samples[i].PrecipMm = 2 * samples[i].IrrigationL
+		}
+		if _, err := Calibrate(testConfig(), samples); err == nil || !strings.Contains(err.Error(), "collinear") {
+			t.Errorf("got %v, want a collinear inputs error", err)
+		}
+	})
+
+	t.Run("irrigation drying the soil", func(t *testing.T) {
+		samples := calibrationSamples(truth, 20, sampleOptions{}, 5)
+		for i := range samples {
+			samples[i].EndMoisture = samples[i].StartMoisture - 50 - 20*samples[i].IrrigationL
+		}
+		if _, err := Calibrate(testConfig(), samples); err == nil || !strings.Contains(err.Error(), "adc_to_moisture") {
+			t.Errorf("got %v, want a non-positive adc_to_moisture error", err)
+		}
+	})
+}
+
+func TestLeastSquares(t *testing.T) {
+	// y = 2 + 3x，超定且无噪声
+	xs := [][]float64{{1, 0}, {1, 1}, {1, 2}, {1, 3}}
+	ys := []float64{2, 5, 8, 11}
+	coef, err := leastSquares(xs, ys)
+	if err != nil {
+		t.Fatalf("leastSquares: %v", err)
+	}
+	if math.Abs(coef[0]-2) > 1e-9 || math.Abs(coef[1]-3) > 1e-9 {
+		t.Errorf("got %v, want [2 3]", coef)
+	}
+
+	// 第二列恒为零，正规方程奇异
+	if _, err := leastSquares([][]float64{{1, 0}, {1, 0}, {1, 0}}, []float64{1, 2, 3}); err == nil {
+		t.Errorf("expected an error for singular normal equations")
+	}
+	// 两列成比例
+	if _, err := leastSquares([][]float64{{1, 2}, {2, 4}, {3, 6}}, []float64{1, 2, 3}); err == nil {
+		t.Errorf("expected an error for proportional columns")
+	}
+}
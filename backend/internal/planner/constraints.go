@@ -0,0 +1,72 @@
+package planner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// weekdayNames are the accepted names for AllowedWeekdays
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// InfeasibleError reports that no plan satisfies the hard constraints
+type InfeasibleError struct {
+	Date         string   `json:"date"`           // 第一个无法满足约束的日期
+	Floor        int      `json:"moisture_floor"` // 湿度下限 (ADC值)
+	BestMoisture float64  `json:"best_moisture"`  // 当天在其他约束下可达到的最高湿度
+	Limits       []string `json:"limits"`         // 限制了当天灌溉量的约束
+}
+
+func (e *InfeasibleError) Error() string {
+	limits := strings.Join(e.Limits, ", ")
+	if e.Floor > 0 {
+		return fmt.Sprintf("no feasible plan: on %s soil moisture cannot be kept at or above %d (best reachable %.0f), limited by %s",
+			e.Date, e.Floor, e.BestMoisture, limits)
+	}
+	// 未设置湿度下限时只可能是约束之间互相冲突，如指定水量超出预算
+	return fmt.Sprintf("no feasible plan: on %s no irrigation volume satisfies %s", e.Date, limits)
+}
+
+// validateConstraints checks the hard constraint settings
+func (c PlannerConfig) validateConstraints() error {
+	if c.VolumeBudgetL < 0 {
+		return fmt.Errorf("volume budget must not be negative")
+	}
+	if c.MinDaysBetween < 0 || c.MinDaysBetween > 14 {
+		return fmt.Errorf("min days between waterings must be between 0 and 14")
+	}
+	if c.MoistureFloor < 0 || c.MoistureFloor >= c.SoilOptimalMax {
+		return fmt.Errorf("moisture floor must satisfy 0 <= floor < soil optimal max")
+	}
+	for _, name := range c.AllowedWeekdays {
+		if _, ok := weekdayNames[strings.ToLower(name)]; !ok {
+			return fmt.Errorf("unknown weekday %q, expected one of sun, mon, tue, wed, thu, fri, sat", name)
+		}
+	}
+	return nil
+}
+
+// wateringAllowedOn reports whether the weekday of date is in AllowedWeekdays
+func (c PlannerConfig) wateringAllowedOn(date string) bool {
+	if len(c.AllowedWeekdays) == 0 {
+		return true
+	}
+	t, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return true
+	}
+	for _, name := range c.AllowedWeekdays {
+		if weekdayNames[strings.ToLower(name)] == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package planner
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestInfeasibleError(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *PlannerConfig)
+		fixed   float64 // 第一天人工指定的水量，0 表示不指定
+		want    []string
+		notWant string
+	}{
+		{
+			name: "moisture floor",
+			modify: func(c *PlannerConfig) {
+				c.MoistureFloor = 2400
+				c.MaxIrrigationPerDay = 1
+			},
+			want: []string{"at or above 2400", "max 1.0 L/day"},
+		},
+		{
+			name:    "fixed volume over budget without floor",
+			modify:  func(c *PlannerConfig) { c.VolumeBudgetL = 5 },
+			fixed:   8,
+			want:    []string{"no irrigation volume satisfies fixed volume 8.0 L, volume budget 5.0 L"},
+			notWant: "at or above",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			tt.modify(&config)
+			forecasts := testForecasts(3, 1)
+			p := NewIrrigationPlanner(config)
+			if tt.fixed > 0 {
+				p = p.WithFixedVolumes(map[string]float64{forecasts[0].Date: tt.fixed})
+			}
+
+			_, err := p.ComputePlan(1500, forecasts)
+			var infeasible *InfeasibleError
+			if !errors.As(err, &infeasible) {
+				t.Fatalf("got %v, want an InfeasibleError", err)
+			}
+			msg := infeasible.Error()
+			for _, want := range tt.want {
+				if !strings.Contains(msg, want) {
+					t.Errorf("message %q does not mention %q", msg, want)
+				}
+			}
+			if tt.notWant != "" && strings.Contains(msg, tt.notWant) {
+				t.Errorf("message %q mentions %q", msg, tt.notWant)
+			}
+		})
+	}
+}
@@ -1,15 +1,21 @@
 package planner
 
 import (
+	"fmt"
 	"math"
 )
 
+// maxADC is the upper bound of the soil moisture sensor reading
+const maxADC = 4095.0
+
 // ForecastDay represents a single day's forecast data
 type ForecastDay struct {
-	Date     string
-	TempMax  float64
-	TempMin  float64
-	PrecipMm float64
+	Date          string
+	TempMax       float64
+	TempMin       float64
+	PrecipMm      float64
+	HumidityPct   *float64 // 为空时 FAO-56 模型退回 Hargreaves 公式
+	PrecipProbPct *float64 // 降水概率 (%)，为空时视为确定降雨
 }
 
 // PlannerConfig contains algorithm configuration
@@ -24,157 +30,567 @@ type PlannerConfig struct {
 	CostW1              float64 // 代价权重1 (湿度偏差)
 	CostW2              float64 // 代价权重2 (用水量)
 	CostW3              float64 // 代价权重3 (变化平滑)
+	ETModel             string  // 蒸散模型: linear, fao56
+	CanopyAreaM2        float64 // 蒸散面积 (平方米)，fao56 模型使用
+	MoistureStep        int     // DP湿度网格步长 (ADC值)，0 表示默认10
+	VolumeStep          float64 // DP灌溉量步长 (升)，0 表示默认0.5
+	RiskAversion        float64 // 风险厌恶系数：降雨情景代价的标准差权重，0 表示只看期望
+	EnsembleSize        int     // 降雨情景采样数，0 表示默认16
+
+	// 硬约束，零值表示不限制
+	VolumeBudgetL   float64  // 规划期内总灌溉量上限 (升)
+	AllowedWeekdays []string // 允许浇水的星期: sun, mon, ..., sat
+	MinDaysBetween  int      // 两个浇水日之间至少间隔的天数
+	MoistureFloor   int      // 预测湿度不得低于此值 (ADC值)
+}
+
+// Validate checks that the configuration can be used for planning
+func (c PlannerConfig) Validate() error {
+	if c.SoilOptimalMin < 0 || c.SoilOptimalMax > 4095 || c.SoilOptimalMin >= c.SoilOptimalMax {
+		return fmt.Errorf("soil optimal range must satisfy 0 <= min < max <= 4095")
+	}
+	if c.MaxIrrigationPerDay < 0 {
+		return fmt.Errorf("max irrigation per day must not be negative")
+	}
+	if c.ADCToMoisture <= 0 {
+		return fmt.Errorf("adc to moisture must be positive")
+	}
+	if c.BaseET < 0 || c.RainConversion < 0 {
+		return fmt.Errorf("base ET and rain conversion must not be negative")
+	}
+	if c.CostW1 < 0 || c.CostW2 < 0 || c.CostW3 < 0 {
+		return fmt.Errorf("cost weights must not be negative")
+	}
+	if c.RiskAversion < 0 || c.RiskAversion > 10 {
+		return fmt.Errorf("risk aversion must be between 0 and 10")
+	}
+	if c.EnsembleSize < 0 || c.EnsembleSize > 200 {
+		return fmt.Errorf("ensemble size must be between 0 and 200")
+	}
+	if c.MoistureStep < 0 || c.MoistureStep > 500 {
+		return fmt.Errorf("moisture step must be between 1 and 500")
+	}
+	if c.VolumeStep < 0 || (c.VolumeStep > 0 && c.VolumeStep < 0.05) {
+		return fmt.Errorf("volume step must be at least 0.05 L")
+	}
+	if _, volumeStep := c.gridSteps(); c.MaxIrrigationPerDay/volumeStep > 1000 {
+		return fmt.Errorf("volume step too small: more than 1000 volume options per day")
+	}
+	switch c.ETModel {
+	case "", ETModelLinear:
+	case ETModelFAO56:
+		if c.CanopyAreaM2 <= 0 {
+			return fmt.Errorf("canopy area must be positive for the fao56 ET model")
+		}
+	default:
+		return fmt.Errorf("unknown ET model: %s", c.ETModel)
+	}
+	return c.validateConstraints()
+}
+
+// gridSteps returns the moisture grid and volume steps, applying defaults
+func (c PlannerConfig) gridSteps() (int, float64) {
+	moistureStep, volumeStep := c.MoistureStep, c.VolumeStep
+	if moistureStep <= 0 {
+		moistureStep = 10
+	}
+	if volumeStep <= 0 {
+		volumeStep = 0.5
+	}
+	return moistureStep, volumeStep
 }
 
-// DailyPlan represents the irrigation plan for one day
+// DailyPlan represents the irrigation plan for one day together with the
+// model's explanation of it
 type DailyPlan struct {
-	Date           string
-	PlannedVolumeL float64
+	Date              string
+	PlannedVolumeL    float64
+	StartMoisture     float64 // 当日开始时的预测土壤湿度 (ADC值)
+	PredictedMoisture float64 // 按模型预测的当日结束时土壤湿度 (ADC值)
+	ETL               float64 // 当日蒸散量 (升)
+	RainL             float64 // 降雨补充水量 (升)
+	CostDeviation     float64 // 代价：湿度偏离最优区间中心 (已乘权重)
+	CostWater         float64 // 代价：用水量 (已乘权重)
+	CostSmoothness    float64 // 代价：与前一天灌溉量的变化 (已乘权重)
+	Fixed             bool    // 灌溉量由人工指定，未经优化
+}
+
+// TotalCost returns the sum of the day's cost components
+func (d DailyPlan) TotalCost() float64 {
+	return d.CostDeviation + d.CostWater + d.CostSmoothness
 }
 
 // IrrigationPlanner implements the DP-based irrigation planning algorithm
 type IrrigationPlanner struct {
-	config PlannerConfig
+	config            PlannerConfig
+	et                ETModel
+	daysSinceWatering int                // 距上一个浇水日的天数，0 表示未知
+	fixed             map[string]float64 // 人工指定的灌溉量，按日期
 }
 
-// NewIrrigationPlanner creates a new planner instance
+// NewIrrigationPlanner creates a new planner instance using the linear ET model
 func NewIrrigationPlanner(config PlannerConfig) *IrrigationPlanner {
-	return &IrrigationPlanner{config: config}
+	return &IrrigationPlanner{
+		config: config,
+		et:     LinearET{BaseET: config.BaseET, TempFactor: config.TempFactor},
+	}
 }
 
-// ComputePlan computes the optimal irrigation plan using dynamic programming
-func (p *IrrigationPlanner) ComputePlan(initialSoilMoisture int, forecasts []ForecastDay) []DailyPlan {
+// WithETModel returns a copy of the planner that uses the given ET model
+func (p *IrrigationPlanner) WithETModel(model ETModel) *IrrigationPlanner {
+	c := *p
+	c.et = model
+	return &c
+}
+
+// WithDaysSinceWatering returns a copy of the planner that knows how many days
+// ago the device was last watered, for the MinDaysBetween constraint
+func (p *IrrigationPlanner) WithDaysSinceWatering(days int) *IrrigationPlanner {
+	c := *p
+	c.daysSinceWatering = days
+	return &c
+}
+
+// WithFixedVolumes returns a copy of the planner that takes the given daily
+// volumes, keyed by date, as decided instead of optimizing them. Fixed days
+// are exempt from the weekday, spacing and per-day limits but count against
+// the volume budget and must keep the moisture floor.
+func (p *IrrigationPlanner) WithFixedVolumes(volumes map[string]float64) *IrrigationPlanner {
+	c := *p
+	c.fixed = volumes
+	return &c
+}
+
+// maxStates bounds the number of DP states per day
+const maxStates = 4_000_000
+
+// stateSpace is the discretized DP state: moisture grid cell × volume budget
+// used so far × days since the last watering. Dimensions for constraints that
+// are not set have a single state.
+type stateSpace struct {
+	moistureStep   float64
+	moistureStates int
+	volumeStep     float64
+	volumeOptions  int // 灌溉量选项数（含0）
+	budgetSteps    int // 以 volumeStep 为单位的总水量预算，0 表示不跟踪
+	budgetStates   int
+	minGap         int
+	gapStates      int
+}
+
+// newStateSpace sizes the state space for a plan over the given forecast days,
+// of which those in fixed have their volume decided
+func newStateSpace(c PlannerConfig, forecasts []ForecastDay, fixed map[string]float64) stateSpace {
+	moistureStep, volumeStep := c.gridSteps()
+	sp := stateSpace{
+		moistureStep:   float64(moistureStep),
+		moistureStates: int(maxADC)/moistureStep + 1,
+		volumeStep:     volumeStep,
+		volumeOptions:  int(c.MaxIrrigationPerDay/volumeStep+1e-9) + 1,
+		budgetStates:   1,
+		minGap:         c.MinDaysBetween,
+		gapStates:      1,
+	}
+
+	// 规划期内用不完的预算不需要跟踪；人工指定的灌溉量不受每日上限限制，按实际值计入
+	if c.VolumeBudgetL > 0 {
+		maxSteps := 0
+		for _, f := range forecasts {
+			if volume, ok := fixed[f.Date]; ok {
+				maxSteps += int(math.Ceil(volume/volumeStep - 1e-9))
+			} else {
+				maxSteps += sp.volumeOptions - 1
+			}
+		}
+		if c.VolumeBudgetL < float64(maxSteps)*volumeStep {
+			sp.budgetSteps = int(c.VolumeBudgetL/volumeStep + 1e-9)
+			sp.budgetStates = sp.budgetSteps + 1
+		}
+	}
+	// 距上次浇水的天数达到 MinDaysBetween 后不再区分
+	if sp.minGap > 0 {
+		sp.gapStates = sp.minGap + 1
+	}
+	return sp
+}
+
+func (sp stateSpace) size() int {
+	return sp.moistureStates * sp.budgetStates * sp.gapStates
+}
+
+func (sp stateSpace) index(moistureIdx, budgetIdx, gapIdx int) int {
+	return (moistureIdx*sp.budgetStates+budgetIdx)*sp.gapStates + gapIdx
+}
+
+func (sp stateSpace) decode(idx int) (moistureIdx, budgetIdx, gapIdx int) {
+	gapIdx = idx % sp.gapStates
+	idx /= sp.gapStates
+	return idx / sp.budgetStates, idx % sp.budgetStates, gapIdx
+}
+
+// moistureIndex maps a moisture value to the nearest grid point
+func (sp stateSpace) moistureIndex(moisture float64) int {
+	idx := int(math.Round(moisture / sp.moistureStep))
+	if idx >= sp.moistureStates {
+		idx = sp.moistureStates - 1
+	}
+	return idx
+}
+
+// ComputePlan computes the optimal irrigation plan using dynamic programming.
+// It returns an *InfeasibleError when the hard constraints cannot all be met.
+//
+// When some forecast rain is uncertain (PrecipProbPct below 100) the plan is
+// chosen over an ensemble of rain scenarios, see computeRobustPlan; the
+// returned explanation then assumes the expected rain.
+func (p *IrrigationPlanner) ComputePlan(initialSoilMoisture int, forecasts []ForecastDay) ([]DailyPlan, error) {
 	if len(forecasts) == 0 {
-		return []DailyPlan{}
+		return []DailyPlan{}, nil
 	}
 
-	days := len(forecasts)
+	var volumes []float64
+	var err error
+	if hasRainUncertainty(forecasts) {
+		volumes, err = p.computeRobustPlan(initialSoilMoisture, forecasts)
+	} else {
+		volumes, _, err = p.optimize(initialSoilMoisture, forecasts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 按模型重新推演预测轨迹和代价
+	return p.Explain(initialSoilMoisture, expectedForecasts(forecasts), volumes), nil
+}
 
-	// 离散化灌溉量选项：0, 0.5, 1.0, 1.5, ..., MaxIrrigationPerDay
-	maxSteps := int(p.config.MaxIrrigationPerDay / 0.5)
-	irrigationOptions := make([]float64, maxSteps+1)
-	for i := range irrigationOptions {
-		irrigationOptions[i] = float64(i) * 0.5
+// optimize runs the DP for forecasts taken as certain and returns the daily
+// volumes of the optimal plan and its total cost.
+//
+// Only the states reached on the previous day are expanded. Costs are kept
+// for the current and the next day only; for backtracking each day stores the
+// parent pointer and the chosen volume of the states it reached. Each state
+// keeps the exact moisture of the path that reached it, the grid only decides
+// which paths are merged, so the returned cost is the cost Explain reports.
+func (p *IrrigationPlanner) optimize(initialSoilMoisture int, forecasts []ForecastDay) ([]float64, float64, error) {
+	ws, err := p.newWorkspace(forecasts)
+	if err != nil {
+		return nil, 0, err
 	}
+	return p.optimizeWith(ws, initialSoilMoisture, forecasts)
+}
 
-	// DP状态: dp[day][moisture] = {cost, prevMoisture, irrigationVolume}
-	// moisture范围: 0-4095 (ADC范围)
-	// 为了减少状态空间，按步长10离散化
-	moistureStep := 10
-	maxMoisture := 4095
-	moistureStates := maxMoisture/moistureStep + 1
+// dpWorkspace holds the state space of the DP and its state lookup table, so
+// that runs over the same horizon, like the rain scenarios of a robust plan,
+// can share them
+type dpWorkspace struct {
+	sp       stateSpace
+	position []int32 // 状态索引在次日数组中的位置，未到达为 -1
+}
 
-	type State struct {
-		cost             float64
-		prevMoisture     int
-		irrigationVolume float64
+// newWorkspace sizes the DP workspace for a plan over the given forecast days
+func (p *IrrigationPlanner) newWorkspace(forecasts []ForecastDay) (*dpWorkspace, error) {
+	sp := newStateSpace(p.config, forecasts, p.fixed)
+	states := sp.size()
+	if states > maxStates {
+		return nil, fmt.Errorf("planner state space too large (%d states per day), increase moisture_step or volume_step", states)
+	}
+	position := make([]int32, states)
+	for i := range position {
+		position[i] = -1
 	}
+	return &dpWorkspace{sp: sp, position: position}, nil
+}
 
-	// 初始化DP表
-	dp := make([][]State, days+1)
-	for i := range dp {
-		dp[i] = make([]State, moistureStates)
-		for j := range dp[i] {
-			dp[i][j].cost = math.Inf(1) // 无穷大
-		}
+// optimizeWith runs optimize in the given workspace, which must be sized for
+// the same forecast dates. The lookup table is left cleared for the next run.
+func (p *IrrigationPlanner) optimizeWith(ws *dpWorkspace, initialSoilMoisture int, forecasts []ForecastDay) ([]float64, float64, error) {
+	days := len(forecasts)
+	sp, position := ws.sp, ws.position
+	states := len(position)
+
+	// 滚动数组只保存当天已到达的状态：state 为状态索引，cost 为累计代价，
+	// moisture 为到达该状态的路径的实际湿度，irrigation 为到达该状态时的灌溉量
+	type frontier struct {
+		state      []int
+		cost       []float64
+		moisture   []float64
+		irrigation []float64
+		parent     []int32  // 前一天数组中的位置
+		choice     []uint16 // 当天的灌溉量选项
 	}
+	// 父指针：parents[day] 与 choices[day] 对应当天结束时到达的各状态
+	parents := make([][]int32, days)
+	choices := make([][]uint16, days)
 
 	// 初始状态
-	initMoistureIdx := initialSoilMoisture / moistureStep
-	if initMoistureIdx >= moistureStates {
-		initMoistureIdx = moistureStates - 1
+	initGap := 0
+	if sp.minGap > 0 {
+		initGap = sp.minGap
+		if p.daysSinceWatering > 0 && p.daysSinceWatering < sp.minGap {
+			initGap = p.daysSinceWatering
+		}
+	}
+	curr := frontier{
+		state:      []int{sp.index(sp.moistureIndex(float64(initialSoilMoisture)), 0, initGap)},
+		cost:       []float64{0},
+		moisture:   []float64{float64(initialSoilMoisture)},
+		irrigation: []float64{0},
 	}
-	dp[0][initMoistureIdx].cost = 0
-	dp[0][initMoistureIdx].prevMoisture = initialSoilMoisture
-	dp[0][initMoistureIdx].irrigationVolume = 0
 
+	floor := float64(p.config.MoistureFloor)
 	optimalCenter := float64(p.config.SoilOptimalMin+p.config.SoilOptimalMax) / 2.0
+	adc := p.config.ADCToMoisture
 
 	// DP转移
 	for day := 0; day < days; day++ {
 		forecast := forecasts[day]
+		allowedDay := p.config.wateringAllowedOn(forecast.Date)
+		fixedVolume, fixedDay := p.fixed[forecast.Date]
+		fixedSteps := 0
+		if fixedDay && sp.budgetSteps > 0 {
+			fixedSteps = int(math.Ceil(fixedVolume/sp.volumeStep - 1e-9))
+		}
 
-		// 计算蒸散量
-		tAvg := (forecast.TempMax + forecast.TempMin) / 2.0
-		et := p.config.BaseET + p.config.TempFactor*(tAvg-20.0)
+		// 当天的蒸散和降雨与状态无关，只计算一次
+		etL, rainL := p.waterBalance(forecast)
+		netL := rainL - etL
 
-		// 降雨补充 (毫米转换为湿度增量)
-		rainMoisture := forecast.PrecipMm * p.config.RainConversion * p.config.ADCToMoisture
+		// 记录当天不可行时的诊断信息
+		bestMoisture := math.Inf(-1)
+		blockedByGap, blockedByBudget := false, false
 
-		for currIdx := 0; currIdx < moistureStates; currIdx++ {
-			if math.IsInf(dp[day][currIdx].cost, 1) {
-				continue
-			}
+		capacity := len(curr.state) * sp.volumeOptions
+		if capacity > states {
+			capacity = states
+		}
+		next := frontier{
+			state:      make([]int, 0, capacity),
+			cost:       make([]float64, 0, capacity),
+			moisture:   make([]float64, 0, capacity),
+			irrigation: make([]float64, 0, capacity),
+			parent:     make([]int32, 0, capacity),
+			choice:     make([]uint16, 0, capacity),
+		}
 
-			currMoisture := currIdx * moistureStep
+		for currPos, currIdx := range curr.state {
+			_, budgetIdx, gapIdx := sp.decode(currIdx)
+			currMoisture := curr.moisture[currPos]
+			currCost := curr.cost[currPos]
+			prevIrrigation := curr.irrigation[currPos]
 
 			// 尝试所有灌溉选项
-			for _, irrigation := range irrigationOptions {
-				// 湿度转移方程
-				newMoisture := float64(currMoisture) - et*p.config.ADCToMoisture + irrigation*p.config.ADCToMoisture + rainMoisture
+			for opt := 0; opt < sp.volumeOptions; opt++ {
+				nextBudget, nextGap := budgetIdx, gapIdx
+				irrigation := float64(opt) * sp.volumeStep
+				overBudget := false
+				if fixedDay {
+					// 人工指定的日期只有一个选项
+					if opt > 0 {
+						break
+					}
+					irrigation = fixedVolume
+					if fixedVolume > 0 {
+						nextBudget = budgetIdx + fixedSteps
+						if sp.budgetSteps > 0 && nextBudget > sp.budgetSteps {
+							blockedByBudget, overBudget = true, true
+						}
+						if sp.minGap > 0 {
+							nextGap = 1
+						}
+					} else if sp.minGap > 0 && gapIdx < sp.minGap {
+						nextGap = gapIdx + 1
+					}
+				} else if opt > 0 {
+					if !allowedDay {
+						break
+					}
+					if sp.minGap > 0 && gapIdx < sp.minGap {
+						blockedByGap = true
+						break
+					}
+					if sp.budgetSteps > 0 {
+						nextBudget = budgetIdx + opt
+						if nextBudget > sp.budgetSteps {
+							blockedByBudget = true
+							break
+						}
+					}
+					if sp.minGap > 0 {
+						nextGap = 1
+					}
+				} else if sp.minGap > 0 && gapIdx < sp.minGap {
+					nextGap = gapIdx + 1
+				}
 
-				// 边界约束
+				// 湿度转移方程（与 nextMoisture 相同）
+				newMoisture := currMoisture + (irrigation+netL)*adc
 				if newMoisture < 0 {
 					newMoisture = 0
+				} else if newMoisture > maxADC {
+					newMoisture = maxADC
 				}
-				if newMoisture > float64(maxMoisture) {
-					newMoisture = float64(maxMoisture)
+				if newMoisture > bestMoisture {
+					bestMoisture = newMoisture
 				}
-
-				newIdx := int(newMoisture) / moistureStep
-				if newIdx >= moistureStates {
-					newIdx = moistureStates - 1
+				if overBudget || newMoisture < floor {
+					continue
 				}
 
-				// 计算代价
-				moistureDeviation := math.Abs(newMoisture - optimalCenter)
-				prevIrrigation := dp[day][currIdx].irrigationVolume
-				irrigationChange := math.Abs(irrigation - prevIrrigation)
-
-				cost := p.config.CostW1*moistureDeviation*moistureDeviation +
+				// 计算代价（与 dayCost 相同）
+				deviation := newMoisture - optimalCenter
+				totalCost := currCost +
+					p.config.CostW1*deviation*deviation +
 					p.config.CostW2*irrigation +
-					p.config.CostW3*irrigationChange
-
-				totalCost := dp[day][currIdx].cost + cost
+					p.config.CostW3*math.Abs(irrigation-prevIrrigation)
 
 				// 更新状态
-				if totalCost < dp[day+1][newIdx].cost {
-					dp[day+1][newIdx].cost = totalCost
-					dp[day+1][newIdx].prevMoisture = currMoisture
-					dp[day+1][newIdx].irrigationVolume = irrigation
+				newIdx := sp.index(sp.moistureIndex(newMoisture), nextBudget, nextGap)
+				pos := position[newIdx]
+				if pos < 0 {
+					position[newIdx] = int32(len(next.state))
+					next.state = append(next.state, newIdx)
+					next.cost = append(next.cost, totalCost)
+					next.moisture = append(next.moisture, newMoisture)
+					next.irrigation = append(next.irrigation, irrigation)
+					next.parent = append(next.parent, int32(currPos))
+					next.choice = append(next.choice, uint16(opt))
+				} else if totalCost < next.cost[pos] {
+					next.cost[pos] = totalCost
+					next.moisture[pos] = newMoisture
+					next.irrigation[pos] = irrigation
+					next.parent[pos] = int32(currPos)
+					next.choice[pos] = uint16(opt)
 				}
 			}
 		}
+
+		if len(next.state) == 0 {
+			limits := []string{fmt.Sprintf("max %.1f L/day", p.config.MaxIrrigationPerDay)}
+			if fixedDay {
+				limits = []string{fmt.Sprintf("fixed volume %.1f L", fixedVolume)}
+			} else if !allowedDay {
+				limits = append(limits, "allowed weekdays")
+			}
+			if blockedByGap {
+				limits = append(limits, fmt.Sprintf("min %d days between waterings", sp.minGap))
+			}
+			if blockedByBudget {
+				limits = append(limits, fmt.Sprintf("volume budget %.1f L", p.config.VolumeBudgetL))
+			}
+			return nil, 0, &InfeasibleError{
+				Date:         forecast.Date,
+				Floor:        p.config.MoistureFloor,
+				BestMoisture: bestMoisture,
+				Limits:       limits,
+			}
+		}
+
+		// 滚动到下一天
+		for _, idx := range next.state {
+			position[idx] = -1
+		}
+		parents[day], choices[day] = next.parent, next.choice
+		curr = next
 	}
 
 	// 回溯最优路径
 	// 找到最后一天代价最小的状态
-	minCost := math.Inf(1)
-	bestIdx := 0
-	for idx := 0; idx < moistureStates; idx++ {
-		if dp[days][idx].cost < minCost {
-			minCost = dp[days][idx].cost
-			bestIdx = idx
+	best := 0
+	for pos := range curr.cost {
+		if curr.cost[pos] < curr.cost[best] {
+			best = pos
 		}
 	}
 
-	// 构建计划
-	plan := make([]DailyPlan, days)
-	currentIdx := bestIdx
-
-	// 回溯路径
+	optimum := curr.cost[best]
+	volumes := make([]float64, days)
 	for day := days - 1; day >= 0; day-- {
+		volumes[day] = float64(choices[day][best]) * sp.volumeStep
+		if fixedVolume, ok := p.fixed[forecasts[day].Date]; ok {
+			volumes[day] = fixedVolume
+		}
+		best = int(parents[day][best])
+	}
+
+	return volumes, optimum, nil
+}
+
+// Explain replays the moisture model for the given daily volumes and returns
+// each day's start and end moisture, water balance and cost components
+func (p *IrrigationPlanner) Explain(initialSoilMoisture int, forecasts []ForecastDay, volumes []float64) []DailyPlan {
+	plan := make([]DailyPlan, len(forecasts))
+	moisture := float64(initialSoilMoisture)
+	prevIrrigation := 0.0
+	for day, forecast := range forecasts {
+		irrigation := 0.0
+		if day < len(volumes) {
+			irrigation = volumes[day]
+		}
+		etL, rainL := p.waterBalance(forecast)
+		next := p.nextMoisture(moisture, irrigation, forecast)
+		deviation, water, smoothness := p.dayCost(next, irrigation, prevIrrigation)
+
 		plan[day] = DailyPlan{
-			Date:           forecasts[day].Date,
-			PlannedVolumeL: dp[day+1][currentIdx].irrigationVolume,
+			Date:              forecast.Date,
+			PlannedVolumeL:    irrigation,
+			StartMoisture:     moisture,
+			PredictedMoisture: next,
+			ETL:               etL,
+			RainL:             rainL,
+			CostDeviation:     deviation,
+			CostWater:         water,
+			CostSmoothness:    smoothness,
 		}
+		_, plan[day].Fixed = p.fixed[forecast.Date]
+		moisture, prevIrrigation = next, irrigation
+	}
+	return plan
+}
+
+// dayCost returns the weighted deviation, water and smoothness costs of one day
+func (p *IrrigationPlanner) dayCost(moisture, irrigation, prevIrrigation float64) (float64, float64, float64) {
+	optimalCenter := float64(p.config.SoilOptimalMin+p.config.SoilOptimalMax) / 2.0
+	moistureDeviation := math.Abs(moisture - optimalCenter)
+	irrigationChange := math.Abs(irrigation - prevIrrigation)
 
-		// 回到前一天的状态
-		if day > 0 {
-			prevMoisture := dp[day+1][currentIdx].prevMoisture
-			currentIdx = prevMoisture / moistureStep
+	return p.config.CostW1 * moistureDeviation * moistureDeviation,
+		p.config.CostW2 * irrigation,
+		p.config.CostW3 * irrigationChange
+}
+
+// Simulate predicts the end-of-day soil moisture for each forecast day given
+// the irrigation volume applied on that day
+func (p *IrrigationPlanner) Simulate(initialSoilMoisture int, forecasts []ForecastDay, volumes []float64) []float64 {
+	trajectory := make([]float64, len(forecasts))
+	moisture := float64(initialSoilMoisture)
+	for day, forecast := range forecasts {
+		irrigation := 0.0
+		if day < len(volumes) {
+			irrigation = volumes[day]
 		}
+		moisture = p.nextMoisture(moisture, irrigation, forecast)
+		trajectory[day] = moisture
+	}
+	return trajectory
+}
+
+// nextMoisture applies one day of the moisture model: evapotranspiration
+// removes water, irrigation and rain add it, clamped to the ADC range
+func (p *IrrigationPlanner) nextMoisture(moisture, irrigation float64, forecast ForecastDay) float64 {
+	etL, rainL := p.waterBalance(forecast)
+	next := moisture + (irrigation+(rainL-etL))*p.config.ADCToMoisture
+
+	// 边界约束
+	if next < 0 {
+		next = 0
 	}
+	if next > maxADC {
+		next = maxADC
+	}
+	return next
+}
 
-	return plan
+// waterBalance returns the day's evapotranspiration and effective rain in liters
+func (p *IrrigationPlanner) waterBalance(forecast ForecastDay) (float64, float64) {
+	return p.et.DailyET(forecast), forecast.PrecipMm * p.config.RainConversion
 }
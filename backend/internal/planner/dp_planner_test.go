@@ -0,0 +1,157 @@
+package planner
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// testConfig mirrors the planner section of config.example.yaml
+func testConfig() PlannerConfig {
+	return PlannerConfig{
+		SoilOptimalMin:      1500,
+		SoilOptimalMax:      2500,
+		MaxIrrigationPerDay: 5.0,
+		BaseET:              2.0,
+		TempFactor:          0.1,
+		RainConversion:      0.8,
+		ADCToMoisture:       40.95,
+		CostW1:              10.0,
+		CostW2:              1.0,
+		CostW3:              2.0,
+	}
+}
+
+// testForecasts returns a reproducible forecast of the given number of days
+func testForecasts(days int, seed int64) []ForecastDay {
+	rng := rand.New(rand.NewSource(seed))
+	start := time.Date(2024, 6, 3, 0, 0, 0, 0, time.Local)
+	forecasts := make([]ForecastDay, days)
+	for i := range forecasts {
+		tempMax := 22 + rng.Float64()*14
+		forecasts[i] = ForecastDay{
+			Date:    start.AddDate(0, 0, i).Format("2006-01-02"),
+			TempMax: tempMax,
+			TempMin: tempMax - 6 - rng.Float64()*6,
+		}
+		if rng.Float64() < 0.3 {
+			forecasts[i].PrecipMm = rng.Float64() * 8
+		}
+	}
+	return forecasts
+}
+
+// planCost sums the cost components Explain reports for the plan
+func planCost(plan []DailyPlan) float64 {
+	total := 0.0
+	for _, day := range plan {
+		total += day.TotalCost()
+	}
+	return total
+}
+
+func TestOptimizeCostMatchesExplain(t *testing.T) {
+	base := testConfig()
+	forecasts := testForecasts(30, 1)
+
+	tests := []struct {
+		name    string
+		config  func(c *PlannerConfig)
+		fixed   map[string]float64
+		initial int
+	}{
+		{name: "default grid", initial: 1200},
+		{name: "wet start", initial: 3200},
+		{name: "fine grid", initial: 1800, config: func(c *PlannerConfig) { c.MoistureStep = 3; c.VolumeStep = 0.25 }},
+		{name: "coarse grid", initial: 1333, config: func(c *PlannerConfig) { c.MoistureStep = 50 }},
+		{name: "budget and spacing", initial: 1500, config: func(c *PlannerConfig) { c.VolumeBudgetL = 40; c.MinDaysBetween = 2 }},
+		{name: "weekdays and floor", initial: 2000, config: func(c *PlannerConfig) { c.AllowedWeekdays = []string{"mon", "thu"}; c.MoistureFloor = 600 }},
+		{name: "fixed volumes", initial: 1500, fixed: map[string]float64{forecasts[3].Date: 1.3, forecasts[10].Date: 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := base
+			if tt.config != nil {
+				tt.config(&config)
+			}
+			if err := config.Validate(); err != nil {
+				t.Fatalf("invalid config: %v", err)
+			}
+			p := NewIrrigationPlanner(config).WithFixedVolumes(tt.fixed)
+
+			volumes, optimum, err := p.optimize(tt.initial, forecasts)
+			if err != nil {
+				t.Fatalf("optimize: %v", err)
+			}
+			plan := p.Explain(tt.initial, forecasts, volumes)
+			if cost := planCost(plan); math.Abs(cost-optimum) > 1e-9*math.Max(1, optimum) {
+				t.Errorf("replayed cost %.6f, DP optimum %.6f", cost, optimum)
+			}
+			for _, day := range plan {
+				if day.PredictedMoisture < float64(config.MoistureFloor) {
+					t.Errorf("%s: moisture %.1f below floor %d", day.Date, day.PredictedMoisture, config.MoistureFloor)
+				}
+			}
+		})
+	}
+}
+
+func TestMoistureIndexRoundsToNearest(t *testing.T) {
+	sp := newStateSpace(testConfig(), testForecasts(1, 1), nil)
+	tests := []struct {
+		moisture float64
+		want     int
+	}{
+		{0, 0},
+		{4.9, 0},
+		{5.1, 1},
+		{1234.4, 123},
+		{1236, 124},
+		{maxADC, int(maxADC) / 10},
+	}
+	for _, tt := range tests {
+		if got := sp.moistureIndex(tt.moisture); got != tt.want {
+			t.Errorf("moistureIndex(%v) = %d, want %d", tt.moisture, got, tt.want)
+		}
+	}
+}
+
+func TestFixedVolumesCountAgainstBudget(t *testing.T) {
+	config := testConfig()
+	config.VolumeBudgetL = 15
+	forecasts := testForecasts(3, 1)
+	for i := range forecasts {
+		forecasts[i].PrecipMm = 0
+	}
+	fixed := map[string]float64{forecasts[0].Date: 8}
+	p := NewIrrigationPlanner(config).WithFixedVolumes(fixed)
+
+	// 预算恰好等于非人工日每日上限之和，但人工指定的 8 L 超过每日上限
+	if sp := newStateSpace(config, forecasts, fixed); sp.budgetSteps == 0 {
+		t.Fatalf("budget not tracked with fixed volume above the daily limit")
+	}
+	plan, err := p.ComputePlan(500, forecasts)
+	if err != nil {
+		t.Fatalf("ComputePlan: %v", err)
+	}
+	total := 0.0
+	for _, day := range plan {
+		total += day.PlannedVolumeL
+	}
+	if total > config.VolumeBudgetL+1e-9 {
+		t.Errorf("planned %.1f L, budget %.1f L", total, config.VolumeBudgetL)
+	}
+}
+
+func BenchmarkComputePlan(b *testing.B) {
+	p := NewIrrigationPlanner(testConfig())
+	forecasts := testForecasts(30, 1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.ComputePlan(1500, forecasts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package planner
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// defaultEnsembleSize is the number of sampled rain scenarios
+const defaultEnsembleSize = 16
+
+// scenarioCandidates is the number of sampled scenarios whose optimal plans
+// are candidates for the robust plan. Every sampled scenario is still used to
+// score the candidates.
+const scenarioCandidates = 3
+
+// hasRainUncertainty reports whether any rainy day has a probability below 100%
+func hasRainUncertainty(forecasts []ForecastDay) bool {
+	for _, f := range forecasts {
+		if f.PrecipMm > 0 && f.PrecipProbPct != nil && *f.PrecipProbPct < 100 {
+			return true
+		}
+	}
+	return false
+}
+
+// rainProbability returns the probability that the forecast rain falls
+func rainProbability(f ForecastDay) float64 {
+	if f.PrecipProbPct == nil {
+		return 1
+	}
+	return math.Min(math.Max(*f.PrecipProbPct/100, 0), 1)
+}
+
+// expectedForecasts scales each day's rain by its probability
+func expectedForecasts(forecasts []ForecastDay) []ForecastDay {
+	expected := make([]ForecastDay, len(forecasts))
+	for i, f := range forecasts {
+		expected[i] = f
+		expected[i].PrecipMm = f.PrecipMm * rainProbability(f)
+	}
+	return expected
+}
+
+// rainScenarios samples n rain outcomes: on each day the forecast amount
+// falls with its probability, otherwise it stays dry. The seed is derived
+// from the forecast so the same input always gives the same plan.
+func rainScenarios(forecasts []ForecastDay, n int) [][]ForecastDay {
+	h := fnv.New64a()
+	for _, f := range forecasts {
+		fmt.Fprintf(h, "%s:%.2f:%v;", f.Date, f.PrecipMm, rainProbability(f))
+	}
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	scenarios := make([][]ForecastDay, n)
+	for s := range scenarios {
+		scenario := make([]ForecastDay, len(forecasts))
+		for i, f := range forecasts {
+			scenario[i] = f
+			if rng.Float64() >= rainProbability(f) {
+				scenario[i].PrecipMm = 0
+			}
+		}
+		scenarios[s] = scenario
+	}
+	return scenarios
+}
+
+// riskAdjustedKs are the pessimism levels of the risk adjusted candidates
+var riskAdjustedKs = []float64{0.5, 1, 2}
+
+// riskAdjustedForecasts scales each day's rain to p − k·√(p(1−p)), the mean
+// minus k standard deviations of the rain/no-rain outcome
+func riskAdjustedForecasts(forecasts []ForecastDay, k float64) []ForecastDay {
+	adjusted := make([]ForecastDay, len(forecasts))
+	for i, f := range forecasts {
+		prob := rainProbability(f)
+		adjusted[i] = f
+		adjusted[i].PrecipMm = f.PrecipMm * math.Max(prob-k*math.Sqrt(prob*(1-prob)), 0)
+	}
+	return adjusted
+}
+
+// dryForecasts removes every rain amount that is not certain
+func dryForecasts(forecasts []ForecastDay) []ForecastDay {
+	dry := make([]ForecastDay, len(forecasts))
+	for i, f := range forecasts {
+		dry[i] = f
+		if rainProbability(f) < 1 {
+			dry[i].PrecipMm = 0
+		}
+	}
+	return dry
+}
+
+// quantileScenarios returns up to k of the distinct scenarios, taken at evenly
+// spaced quantiles of their total rain
+func quantileScenarios(scenarios [][]ForecastDay, k int) [][]ForecastDay {
+	type ranked struct {
+		scenario []ForecastDay
+		rain     float64
+	}
+	var distinct []ranked
+	seen := make(map[string]bool)
+	for _, scenario := range scenarios {
+		key := rainKey(scenario)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		r := ranked{scenario: scenario}
+		for _, day := range scenario {
+			r.rain += day.PrecipMm
+		}
+		distinct = append(distinct, r)
+	}
+	sort.SliceStable(distinct, func(i, j int) bool { return distinct[i].rain < distinct[j].rain })
+
+	if len(distinct) <= k {
+		picked := make([][]ForecastDay, len(distinct))
+		for i, r := range distinct {
+			picked[i] = r.scenario
+		}
+		return picked
+	}
+	picked := make([][]ForecastDay, k)
+	for i := range picked {
+		picked[i] = distinct[(i+1)*len(distinct)/(k+1)].scenario
+	}
+	return picked
+}
+
+// rainKey identifies a forecast by its daily rain amounts
+func rainKey(forecasts []ForecastDay) string {
+	key := make([]byte, 0, len(forecasts)*8)
+	for _, f := range forecasts {
+		key = fmt.Appendf(key, "%g;", f.PrecipMm)
+	}
+	return string(key)
+}
+
+// computeRobustPlan chooses, among the optimal plans of the expected, the risk
+// adjusted, the dry and a few sampled rain scenarios, the plan with the lowest
+// mean + RiskAversion × standard deviation of its cost over all sampled
+// scenarios. Plans that break the moisture floor in any scenario are dropped.
+//
+// The DP runs share one workspace and inputs with the same rain are only
+// optimized once, so a robust plan costs at most 5 + scenarioCandidates DP
+// runs whatever the ensemble size.
+func (p *IrrigationPlanner) computeRobustPlan(initialSoilMoisture int, forecasts []ForecastDay) ([]float64, error) {
+	n := p.config.EnsembleSize
+	if n <= 0 {
+		n = defaultEnsembleSize
+	}
+	scenarios := rainScenarios(forecasts, n)
+
+	ws, err := p.newWorkspace(forecasts)
+	if err != nil {
+		return nil, err
+	}
+
+	// 候选计划：期望降雨、按风险调整的降雨、无降雨以及按总降雨量分位选出的采样情景下的最优计划
+	inputs := [][]ForecastDay{expectedForecasts(forecasts)}
+	for _, k := range riskAdjustedKs {
+		inputs = append(inputs, riskAdjustedForecasts(forecasts, k))
+	}
+	inputs = append(inputs, dryForecasts(forecasts))
+	inputs = append(inputs, quantileScenarios(scenarios, scenarioCandidates)...)
+	var candidates [][]float64
+	var firstErr error
+	seen := make(map[string]bool)
+	optimized := make(map[string]bool)
+	for _, input := range inputs {
+		// 降雨相同的输入只优化一次
+		rain := rainKey(input)
+		if optimized[rain] {
+			continue
+		}
+		optimized[rain] = true
+		volumes, _, err := p.optimizeWith(ws, initialSoilMoisture, input)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		key := fmt.Sprint(volumes)
+		if !seen[key] {
+			seen[key] = true
+			candidates = append(candidates, volumes)
+		}
+	}
+
+	var best []float64
+	bestScore := math.Inf(1)
+	for _, volumes := range candidates {
+		score, ok := p.scenarioScore(initialSoilMoisture, scenarios, volumes)
+		if ok && score < bestScore {
+			best, bestScore = volumes, score
+		}
+	}
+	if best == nil {
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return nil, fmt.Errorf("no plan keeps soil moisture above %d in every rain scenario", p.config.MoistureFloor)
+	}
+	return best, nil
+}
+
+// scenarioScore returns mean + RiskAversion × std of the plan's total cost
+// over the scenarios, and false when it breaks the moisture floor in any
+func (p *IrrigationPlanner) scenarioScore(initialSoilMoisture int, scenarios [][]ForecastDay, volumes []float64) (float64, bool) {
+	floor := float64(p.config.MoistureFloor)
+	costs := make([]float64, len(scenarios))
+	for s, scenario := range scenarios {
+		for _, day := range p.Explain(initialSoilMoisture, scenario, volumes) {
+			if day.PredictedMoisture < floor {
+				return 0, false
+			}
+			costs[s] += day.TotalCost()
+		}
+	}
+
+	mean := 0.0
+	for _, c := range costs {
+		mean += c
+	}
+	mean /= float64(len(costs))
+
+	variance := 0.0
+	for _, c := range costs {
+		variance += (c - mean) * (c - mean)
+	}
+	return mean + p.config.RiskAversion*math.Sqrt(variance/float64(len(costs))), true
+}
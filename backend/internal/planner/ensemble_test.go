@@ -0,0 +1,74 @@
+package planner
+
+import "testing"
+
+// uncertainForecasts returns testForecasts with every rainy day at the given
+// rain probability
+func uncertainForecasts(days int, seed int64, probPct float64) []ForecastDay {
+	forecasts := testForecasts(days, seed)
+	for i := range forecasts {
+		if forecasts[i].PrecipMm > 0 {
+			forecasts[i].PrecipProbPct = &probPct
+		}
+	}
+	return forecasts
+}
+
+func TestQuantileScenarios(t *testing.T) {
+	scenarios := rainScenarios(uncertainForecasts(30, 1, 60), defaultEnsembleSize)
+	picked := quantileScenarios(scenarios, scenarioCandidates)
+	if len(picked) != scenarioCandidates {
+		t.Fatalf("got %d scenarios, want %d", len(picked), scenarioCandidates)
+	}
+
+	prevRain := -1.0
+	seen := make(map[string]bool)
+	for _, scenario := range picked {
+		key := rainKey(scenario)
+		if seen[key] {
+			t.Errorf("scenario picked twice")
+		}
+		seen[key] = true
+		rain := 0.0
+		for _, day := range scenario {
+			rain += day.PrecipMm
+		}
+		if rain < prevRain {
+			t.Errorf("scenarios not ordered by total rain: %.2f after %.2f", rain, prevRain)
+		}
+		prevRain = rain
+	}
+
+	// 情景数不超过 k 时全部保留，重复的只保留一个
+	few := [][]ForecastDay{scenarios[0], scenarios[0], scenarios[1]}
+	if got := len(quantileScenarios(few, scenarioCandidates)); got != 2 {
+		t.Errorf("got %d distinct scenarios, want 2", got)
+	}
+}
+
+func TestComputeRobustPlanKeepsFloor(t *testing.T) {
+	config := testConfig()
+	config.MoistureFloor = 900
+	config.RiskAversion = 1
+	p := NewIrrigationPlanner(config)
+	forecasts := uncertainForecasts(15, 2, 40)
+
+	volumes, err := p.computeRobustPlan(1200, forecasts)
+	if err != nil {
+		t.Fatalf("computeRobustPlan: %v", err)
+	}
+	if _, ok := p.scenarioScore(1200, rainScenarios(forecasts, defaultEnsembleSize), volumes); !ok {
+		t.Errorf("robust plan breaks the moisture floor in a sampled scenario")
+	}
+}
+
+func BenchmarkComputeRobustPlan(b *testing.B) {
+	p := NewIrrigationPlanner(testConfig())
+	forecasts := uncertainForecasts(30, 1, 60)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.ComputePlan(1500, forecasts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package planner
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ETModel estimates the daily water loss of a pot in liters
+type ETModel interface {
+	Name() string
+	DailyET(forecast ForecastDay) float64
+}
+
+// ET model names accepted in profiles
+const (
+	ETModelLinear = "linear"
+	ETModelFAO56  = "fao56"
+)
+
+// LinearET is the original empirical model: BaseET + TempFactor × (Tavg − 20)
+type LinearET struct {
+	BaseET     float64
+	TempFactor float64
+}
+
+// Name implements ETModel
+func (m LinearET) Name() string {
+	return ETModelLinear
+}
+
+// DailyET implements ETModel
+func (m LinearET) DailyET(forecast ForecastDay) float64 {
+	tAvg := (forecast.TempMax + forecast.TempMin) / 2.0
+	return m.BaseET + m.TempFactor*(tAvg-20.0)
+}
+
+// CropCoefficients is the FAO-56 single crop coefficient curve
+type CropCoefficients struct {
+	KcIni     float64 `json:"kc_ini"`
+	KcMid     float64 `json:"kc_mid"`
+	KcEnd     float64 `json:"kc_end"`
+	StageDays [4]int  `json:"stage_days"` // 初期、发育期、中期、后期天数
+}
+
+// At returns Kc for the given number of days since planting
+func (k CropCoefficients) At(day int) float64 {
+	ini, dev, mid, late := k.StageDays[0], k.StageDays[1], k.StageDays[2], k.StageDays[3]
+	switch {
+	case day < ini:
+		return k.KcIni
+	case day < ini+dev:
+		return k.KcIni + float64(day-ini)/float64(dev)*(k.KcMid-k.KcIni)
+	case day < ini+dev+mid:
+		return k.KcMid
+	case day < ini+dev+mid+late:
+		return k.KcMid + float64(day-ini-dev-mid)/float64(late)*(k.KcEnd-k.KcMid)
+	default:
+		return k.KcEnd
+	}
+}
+
+// FAO56ET computes crop evapotranspiration as Kc × ET0, with ET0 from the
+// FAO-56 Penman-Monteith equation. Days without humidity fall back to the
+// Hargreaves equation, which only needs temperatures.
+type FAO56ET struct {
+	Latitude     float64           // 纬度（度），用于计算地外辐射
+	CanopyAreaM2 float64           // 蒸散面积，1mm × 1m² = 1L
+	WindSpeedMs  float64           // 2m高处风速，预报无风速时使用 FAO-56 建议的 2 m/s
+	Crop         *CropCoefficients // 为空时 Kc = 1（参考作物）
+	PlantingDate time.Time         // 为零值时按生长中期计算
+}
+
+// Name implements ETModel
+func (m FAO56ET) Name() string {
+	return ETModelFAO56
+}
+
+// DailyET implements ETModel
+func (m FAO56ET) DailyET(forecast ForecastDay) float64 {
+	date, err := time.ParseInLocation("2006-01-02", forecast.Date, time.Local)
+	if err != nil {
+		date = time.Now()
+	}
+
+	var et0 float64
+	if forecast.HumidityPct != nil {
+		et0 = m.penmanMonteith(forecast, date.YearDay())
+	} else {
+		et0 = hargreaves(forecast, m.Latitude, date.YearDay())
+	}
+
+	return m.kc(date) * et0 * m.CanopyAreaM2
+}
+
+// kc returns the crop coefficient on a date
+func (m FAO56ET) kc(date time.Time) float64 {
+	if m.Crop == nil {
+		return 1.0
+	}
+	if m.PlantingDate.IsZero() {
+		return m.Crop.KcMid
+	}
+	return m.Crop.At(int(date.Sub(m.PlantingDate).Hours() / 24))
+}
+
+// penmanMonteith returns the FAO-56 reference ET0 in mm/day (equation 6)
+func (m FAO56ET) penmanMonteith(f ForecastDay, dayOfYear int) float64 {
+	u2 := m.WindSpeedMs
+	if u2 <= 0 {
+		u2 = 2.0
+	}
+
+	// 按海平面气压计算干湿表常数
+	const pressure = 101.3
+	gamma := 0.000665 * pressure
+
+	es := (saturationVaporPressure(f.TempMax) + saturationVaporPressure(f.TempMin)) / 2
+	ea := *f.HumidityPct / 100 * es
+
+	// 辐射：无实测值时由温差估算 (Hargreaves 辐射公式，kRs = 0.16)
+	ra := extraterrestrialRadiation(m.Latitude, dayOfYear)
+	rs := 0.16 * math.Sqrt(math.Max(f.TempMax-f.TempMin, 0)) * ra
+	rn := netRadiation(f.TempMax, f.TempMin, ea, rs, 0.75*ra)
+
+	return referenceET0(f.TempMax, f.TempMin, ea, rn, u2, gamma)
+}
+
+// referenceET0 evaluates the FAO-56 Penman-Monteith equation (equation 6)
+// in mm/day. rn is the net radiation minus the soil heat flux in
+// MJ/m²/day, ea the actual vapour pressure in kPa, u2 the wind speed at 2 m
+// in m/s and gamma the psychrometric constant in kPa/°C.
+func referenceET0(tMax, tMin, ea, rn, u2, gamma float64) float64 {
+	tMean := (tMax + tMin) / 2
+	es := (saturationVaporPressure(tMax) + saturationVaporPressure(tMin)) / 2
+	delta := 4098 * saturationVaporPressure(tMean) / math.Pow(tMean+237.3, 2)
+
+	et0 := (0.408*delta*rn + gamma*900/(tMean+273)*u2*(es-ea)) / (delta + gamma*(1+0.34*u2))
+	return math.Max(et0, 0)
+}
+
+// netRadiation returns Rn in MJ/m²/day from the solar radiation rs and the
+// clear-sky radiation rso (FAO-56 equations 38 to 40)
+func netRadiation(tMax, tMin, ea, rs, rso float64) float64 {
+	rns := (1 - 0.23) * rs
+
+	const sigma = 4.903e-9
+	ratio := 1.0
+	if rso > 0 {
+		ratio = math.Min(rs/rso, 1.0)
+	}
+	rnl := sigma * (math.Pow(tMax+273.16, 4) + math.Pow(tMin+273.16, 4)) / 2 *
+		(0.34 - 0.14*math.Sqrt(math.Max(ea, 0))) * (1.35*ratio - 0.35)
+	return rns - rnl
+}
+
+// hargreaves returns the Hargreaves reference ET0 in mm/day
+func hargreaves(f ForecastDay, latitude float64, dayOfYear int) float64 {
+	tMean := (f.TempMax + f.TempMin) / 2
+	ra := extraterrestrialRadiation(latitude, dayOfYear)
+	et0 := 0.0023 * (tMean + 17.8) * math.Sqrt(math.Max(f.TempMax-f.TempMin, 0)) * 0.408 * ra
+	return math.Max(et0, 0)
+}
+
+// saturationVaporPressure returns e°(T) in kPa
+func saturationVaporPressure(t float64) float64 {
+	return 0.6108 * math.Exp(17.27*t/(t+237.3))
+}
+
+// extraterrestrialRadiation returns Ra in MJ/m²/day (FAO-56 equation 21)
+func extraterrestrialRadiation(latitude float64, dayOfYear int) float64 {
+	phi := latitude * math.Pi / 180
+	j := float64(dayOfYear)
+	dr := 1 + 0.033*math.Cos(2*math.Pi*j/365)
+	decl := 0.409 * math.Sin(2*math.Pi*j/365-1.39)
+	ws := math.Acos(math.Max(-1, math.Min(1, -math.Tan(phi)*math.Tan(decl))))
+	return 24 * 60 / math.Pi * 0.0820 * dr *
+		(ws*math.Sin(phi)*math.Sin(decl) + math.Cos(phi)*math.Cos(decl)*math.Sin(ws))
+}
+
+// NewETModel builds the ET model selected by cfg.ETModel. latitude, crop and
+// plantingDate are only used by the FAO-56 model.
+func NewETModel(cfg PlannerConfig, latitude float64, crop, plantingDate string) (ETModel, error) {
+	switch cfg.ETModel {
+	case "", ETModelLinear:
+		return LinearET{BaseET: cfg.BaseET, TempFactor: cfg.TempFactor}, nil
+	case ETModelFAO56:
+		model := FAO56ET{Latitude: latitude, CanopyAreaM2: cfg.CanopyAreaM2}
+		if crop != "" {
+			preset, ok := cropPresets[crop]
+			if !ok {
+				return nil, fmt.Errorf("unknown crop preset: %s", crop)
+			}
+			kc := preset.Kc
+			model.Crop = &kc
+		}
+		if plantingDate != "" {
+			t, err := time.ParseInLocation("2006-01-02", plantingDate, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("invalid planting date %q, expected YYYY-MM-DD", plantingDate)
+			}
+			model.PlantingDate = t
+		}
+		return model, nil
+	default:
+		return nil, fmt.Errorf("unknown ET model: %s", cfg.ETModel)
+	}
+}
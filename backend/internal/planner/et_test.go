@@ -0,0 +1,136 @@
+package planner
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// FAO-56 Example 17 (Bangkok, April, monthly data) and Example 18
+// (Brussels, 6 July, daily data)
+var faoExamples = []struct {
+	name      string
+	latitude  float64
+	dayOfYear int
+	tMax      float64
+	tMin      float64
+	ea        float64 // kPa
+	rs        float64 // 由日照时数计算的太阳辐射
+	rso       float64
+	u2        float64
+	gamma     float64
+	soilHeat  float64 // 月数据的土壤热通量 G
+	ra        float64 // 书中结果
+	rn        float64
+	et0       float64
+}{
+	{
+		name: "example 17", latitude: 13.73, dayOfYear: 105,
+		tMax: 34.8, tMin: 25.6, ea: 2.85, rs: 22.65, rso: 28.54, u2: 2, gamma: 0.0674, soilHeat: 0.14,
+		ra: 38.06, rn: 14.33, et0: 5.72,
+	},
+	{
+		name: "example 18", latitude: 50.80, dayOfYear: 187,
+		tMax: 21.5, tMin: 12.3, ea: 1.409, rs: 22.07, rso: 31.08, u2: 2.078, gamma: 0.0666,
+		ra: 41.09, rn: 13.28, et0: 3.9,
+	},
+}
+
+func TestExtraterrestrialRadiation(t *testing.T) {
+	for _, ex := range faoExamples {
+		if got := extraterrestrialRadiation(ex.latitude, ex.dayOfYear); math.Abs(got-ex.ra) > 0.05 {
+			t.Errorf("%s: Ra = %.2f, want %.2f", ex.name, got, ex.ra)
+		}
+	}
+	// FAO-56 Example 8: 20°S, 3 September
+	if got := extraterrestrialRadiation(-20, 246); math.Abs(got-32.2) > 0.05 {
+		t.Errorf("example 8: Ra = %.2f, want 32.2", got)
+	}
+}
+
+func TestPenmanMonteith(t *testing.T) {
+	for _, ex := range faoExamples {
+		t.Run(ex.name, func(t *testing.T) {
+			rn := netRadiation(ex.tMax, ex.tMin, ex.ea, ex.rs, ex.rso)
+			if math.Abs(rn-ex.rn) > 0.05 {
+				t.Errorf("Rn = %.2f, want %.2f", rn, ex.rn)
+			}
+			et0 := referenceET0(ex.tMax, ex.tMin, ex.ea, ex.rn-ex.soilHeat, ex.u2, ex.gamma)
+			if math.Abs(et0-ex.et0) > 0.05 {
+				t.Errorf("ET0 = %.3f, want %.2f", et0, ex.et0)
+			}
+		})
+	}
+}
+
+func TestHargreaves(t *testing.T) {
+	// 按 FAO-56 式 52 和书中的 Ra 手算
+	tests := []struct {
+		name string
+		want float64
+	}{
+		{name: "example 17", want: 5.20},
+		{name: "example 18", want: 4.06},
+	}
+
+	for i, tt := range tests {
+		ex := faoExamples[i]
+		f := ForecastDay{TempMax: ex.tMax, TempMin: ex.tMin}
+		if got := hargreaves(f, ex.latitude, ex.dayOfYear); math.Abs(got-tt.want) > 0.02 {
+			t.Errorf("%s: ET0 = %.3f, want %.2f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCropCoefficientsAt(t *testing.T) {
+	kc := CropCoefficients{KcIni: 0.3, KcMid: 1.1, KcEnd: 0.5, StageDays: [4]int{10, 20, 30, 20}}
+	tests := []struct {
+		day  int
+		want float64
+	}{
+		{day: -1, want: 0.3},
+		{day: 0, want: 0.3},
+		{day: 9, want: 0.3},
+		{day: 10, want: 0.3}, // 发育期开始
+		{day: 20, want: 0.7},
+		{day: 29, want: 1.06},
+		{day: 30, want: 1.1}, // 中期开始
+		{day: 59, want: 1.1},
+		{day: 60, want: 1.1}, // 后期开始
+		{day: 70, want: 0.8},
+		{day: 79, want: 0.53},
+		{day: 80, want: 0.5}, // 生长期结束
+		{day: 365, want: 0.5},
+	}
+
+	for _, tt := range tests {
+		if got := kc.At(tt.day); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("At(%d) = %.4f, want %.4f", tt.day, got, tt.want)
+		}
+	}
+
+	// 没有发育期和后期时直接切换
+	steps := CropCoefficients{KcIni: 0.4, KcMid: 1.0, KcEnd: 0.6, StageDays: [4]int{5, 0, 5, 0}}
+	for day, want := range map[int]float64{4: 0.4, 5: 1.0, 9: 1.0, 10: 0.6} {
+		if got := steps.At(day); got != want {
+			t.Errorf("without development and late stages: At(%d) = %.2f, want %.2f", day, got, want)
+		}
+	}
+}
+
+func TestFAO56ETUsesCropCurve(t *testing.T) {
+	planting := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	crop := &CropCoefficients{KcIni: 0.3, KcMid: 1.1, KcEnd: 0.5, StageDays: [4]int{10, 20, 30, 20}}
+	f := ForecastDay{Date: "2024-06-21", TempMax: 30, TempMin: 18}
+
+	reference := FAO56ET{Latitude: 40, CanopyAreaM2: 0.5}.DailyET(f)
+	withCrop := FAO56ET{Latitude: 40, CanopyAreaM2: 0.5, Crop: crop, PlantingDate: planting}.DailyET(f)
+	// 种植后第20天处于发育期中点
+	if math.Abs(withCrop-0.7*reference) > 1e-9 {
+		t.Errorf("ETc = %.4f L, want 0.7 × %.4f L", withCrop, reference)
+	}
+	noPlanting := FAO56ET{Latitude: 40, CanopyAreaM2: 0.5, Crop: crop}.DailyET(f)
+	if math.Abs(noPlanting-1.1*reference) > 1e-9 {
+		t.Errorf("without planting date: ETc = %.4f L, want Kc mid × %.4f L", noPlanting, reference)
+	}
+}
@@ -0,0 +1,108 @@
+package planner
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ReferencePotSizeL is the pot size the YAML planner defaults are tuned for
+const ReferencePotSizeL = 10.0
+
+// CropPreset holds crop-specific moisture targets and water demand
+type CropPreset struct {
+	Name           string           `json:"name"`
+	Description    string           `json:"description"`
+	SoilOptimalMin int              `json:"soil_optimal_min"`
+	SoilOptimalMax int              `json:"soil_optimal_max"`
+	ETFactor       float64          `json:"et_factor"` // 相对默认蒸散量的倍数（linear 模型）
+	Kc             CropCoefficients `json:"kc"`        // FAO-56 作物系数（fao56 模型）
+}
+
+// SoilPreset adjusts how water is retained by the substrate
+type SoilPreset struct {
+	Name           string  `json:"name"`
+	Description    string  `json:"description"`
+	RainFactor     float64 `json:"rain_factor"`     // 降雨入渗比例倍数
+	MoistureFactor float64 `json:"moisture_factor"` // 每升水引起的ADC变化倍数
+}
+
+// Kc 取自 FAO-56 表12；香草、多肉为经验值
+var cropPresets = map[string]CropPreset{
+	"tomato":     {Name: "tomato", Description: "番茄：需水量大，保持中高湿度", SoilOptimalMin: 1800, SoilOptimalMax: 2700, ETFactor: 1.3, Kc: CropCoefficients{KcIni: 0.6, KcMid: 1.15, KcEnd: 0.8, StageDays: [4]int{30, 40, 40, 25}}},
+	"pepper":     {Name: "pepper", Description: "辣椒：中等需水", SoilOptimalMin: 1600, SoilOptimalMax: 2500, ETFactor: 1.1, Kc: CropCoefficients{KcIni: 0.6, KcMid: 1.05, KcEnd: 0.9, StageDays: [4]int{25, 35, 40, 20}}},
+	"lettuce":    {Name: "lettuce", Description: "生菜：根浅，需要持续湿润", SoilOptimalMin: 2000, SoilOptimalMax: 2800, ETFactor: 1.0, Kc: CropCoefficients{KcIni: 0.7, KcMid: 1.0, KcEnd: 0.95, StageDays: [4]int{20, 30, 15, 10}}},
+	"strawberry": {Name: "strawberry", Description: "草莓：怕涝，湿度适中", SoilOptimalMin: 1700, SoilOptimalMax: 2400, ETFactor: 1.0, Kc: CropCoefficients{KcIni: 0.4, KcMid: 0.85, KcEnd: 0.75, StageDays: [4]int{20, 30, 60, 30}}},
+	"herbs":      {Name: "herbs", Description: "香草（罗勒、薄荷等）：中等需水", SoilOptimalMin: 1500, SoilOptimalMax: 2300, ETFactor: 0.9, Kc: CropCoefficients{KcIni: 0.6, KcMid: 1.1, KcEnd: 1.0, StageDays: [4]int{20, 30, 60, 20}}},
+	"succulent":  {Name: "succulent", Description: "多肉：耐旱，偏干管理", SoilOptimalMin: 800, SoilOptimalMax: 1500, ETFactor: 0.4, Kc: CropCoefficients{KcIni: 0.3, KcMid: 0.4, KcEnd: 0.4, StageDays: [4]int{30, 60, 90, 30}}},
+}
+
+var soilPresets = map[string]SoilPreset{
+	"loam":        {Name: "loam", Description: "壤土（默认）", RainFactor: 1.0, MoistureFactor: 1.0},
+	"sandy":       {Name: "sandy", Description: "沙土：排水快，保水差", RainFactor: 0.7, MoistureFactor: 0.8},
+	"clay":        {Name: "clay", Description: "黏土：保水好，入渗慢", RainFactor: 0.8, MoistureFactor: 1.2},
+	"potting_mix": {Name: "potting_mix", Description: "营养土：疏松保水", RainFactor: 1.0, MoistureFactor: 1.1},
+}
+
+// CropPresets returns all crop presets sorted by name
+func CropPresets() []CropPreset {
+	presets := make([]CropPreset, 0, len(cropPresets))
+	for _, p := range cropPresets {
+		presets = append(presets, p)
+	}
+	sort.Slice(presets, func(i, j int) bool { return presets[i].Name < presets[j].Name })
+	return presets
+}
+
+// SoilPresets returns all soil presets sorted by name
+func SoilPresets() []SoilPreset {
+	presets := make([]SoilPreset, 0, len(soilPresets))
+	for _, p := range soilPresets {
+		presets = append(presets, p)
+	}
+	sort.Slice(presets, func(i, j int) bool { return presets[i].Name < presets[j].Name })
+	return presets
+}
+
+// ApplyPresets derives a planner configuration from the defaults for a crop,
+// soil type and pot size. Empty crop/soil and a zero pot size leave the
+// corresponding defaults unchanged.
+func ApplyPresets(base PlannerConfig, crop, soil string, potSizeL float64) (PlannerConfig, error) {
+	cfg := base
+
+	if crop != "" {
+		preset, ok := cropPresets[crop]
+		if !ok {
+			return cfg, fmt.Errorf("unknown crop preset: %s", crop)
+		}
+		cfg.SoilOptimalMin = preset.SoilOptimalMin
+		cfg.SoilOptimalMax = preset.SoilOptimalMax
+		cfg.BaseET *= preset.ETFactor
+		cfg.TempFactor *= preset.ETFactor
+	}
+
+	if soil != "" {
+		preset, ok := soilPresets[soil]
+		if !ok {
+			return cfg, fmt.Errorf("unknown soil type: %s", soil)
+		}
+		cfg.RainConversion *= preset.RainFactor
+		cfg.ADCToMoisture *= preset.MoistureFactor
+	}
+
+	// 按花盆容积缩放：以升为单位的量与容积成正比，每升水引起的湿度变化与容积成反比
+	if potSizeL < 0 {
+		return cfg, fmt.Errorf("pot size must be positive")
+	}
+	if potSizeL > 0 {
+		scale := potSizeL / ReferencePotSizeL
+		cfg.BaseET *= scale
+		cfg.TempFactor *= scale
+		cfg.RainConversion *= scale
+		cfg.MaxIrrigationPerDay *= scale
+		cfg.ADCToMoisture /= scale
+		cfg.CanopyAreaM2 *= math.Pow(scale, 2.0/3.0) // 面积随容积的2/3次方变化
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+const calibrationColumns = `id, device_id, status, start_date, end_date, samples, weather_source,
+	base_et, temp_factor, rain_conversion, adc_to_moisture, fixed_params,
+	r_squared, rmse, baseline_rmse, created_at, reviewed_at`
+
+type CalibrationRepository struct {
+	db *sql.DB
+}
+
+func NewCalibrationRepository(db *sql.DB) *CalibrationRepository {
+	return &CalibrationRepository{db: db}
+}
+
+// Create inserts a calibration run
+func (r *CalibrationRepository) Create(run *models.CalibrationRun) error {
+	query := `
+		INSERT INTO calibration_runs (device_id, status, start_date, end_date, samples, weather_source,
+			base_et, temp_factor, rain_conversion, adc_to_moisture, fixed_params,
+			r_squared, rmse, baseline_rmse, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := r.db.Exec(query,
+		run.DeviceID,
+		run.Status,
+		run.StartDate,
+		run.EndDate,
+		run.Samples,
+		run.WeatherSource,
+		run.BaseET,
+		run.TempFactor,
+		run.RainConversion,
+		run.ADCToMoisture,
+		strings.Join(run.Fixed, ","),
+		run.RSquared,
+		run.RMSE,
+		run.BaselineRMSE,
+		run.CreatedAt.Format(time.RFC3339),
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	run.ID = id
+	return nil
+}
+
+// GetByID retrieves a calibration run
+func (r *CalibrationRepository) GetByID(id int64) (*models.CalibrationRun, error) {
+	query := `SELECT ` + calibrationColumns + ` FROM calibration_runs WHERE id = ?`
+	return scanCalibration(r.db.QueryRow(query, id))
+}
+
+// Query retrieves calibration runs, newest first. Empty filters match all.
+func (r *CalibrationRepository) Query(deviceID, status string, limit, offset int) ([]*models.CalibrationRun, int, error) {
+	where := "WHERE 1=1"
+	var args []interface{}
+	if deviceID != "" {
+		where += " AND device_id = ?"
+		args = append(args, deviceID)
+	}
+	if status != "" {
+		where += " AND status = ?"
+		args = append(args, status)
+	}
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM calibration_runs `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + calibrationColumns + ` FROM calibration_runs ` + where + `
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?`
+	rows, err := r.db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var runs []*models.CalibrationRun
+	for rows.Next() {
+		run, err := scanCalibration(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, total, rows.Err()
+}
+
+// UpdateStatus marks a pending calibration run as reviewed. It returns false
+// when the run is no longer pending.
+func (r *CalibrationRepository) UpdateStatus(id int64, status string) (bool, error) {
+	query := `UPDATE calibration_runs SET status = ?, reviewed_at = ? WHERE id = ? AND status = 'pending'`
+	result, err := r.db.Exec(query, status, time.Now().Format(time.RFC3339), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func scanCalibration(row rowScanner) (*models.CalibrationRun, error) {
+	var run models.CalibrationRun
+	var fixed, reviewedAt sql.NullString
+	var createdAt string
+
+	err := row.Scan(
+		&run.ID,
+		&run.DeviceID,
+		&run.Status,
+		&run.StartDate,
+		&run.EndDate,
+		&run.Samples,
+		&run.WeatherSource,
+		&run.BaseET,
+		&run.TempFactor,
+		&run.RainConversion,
+		&run.ADCToMoisture,
+		&fixed,
+		&run.RSquared,
+		&run.RMSE,
+		&run.BaselineRMSE,
+		&createdAt,
+		&reviewedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	run.Fixed = []string{}
+	if fixed.String != "" {
+		run.Fixed = strings.Split(fixed.String, ",")
+	}
+	run.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	if reviewedAt.Valid {
+		t, _ := time.Parse(time.RFC3339, reviewedAt.String)
+		run.ReviewedAt = &t
+	}
+	return &run, nil
+}
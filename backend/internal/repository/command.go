@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+	"strings"
 	"time"
 
 	"irrigation-system/backend/internal/models"
@@ -18,15 +19,20 @@ func NewCommandRepository(db *sql.DB) *CommandRepository {
 // Create inserts a new command
 func (r *CommandRepository) Create(cmd *models.DeviceCommand) error {
 	query := `
-		INSERT INTO device_commands (device_id, command_type, parameters, status, created_at)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO device_commands (device_id, command_type, parameters, status, created_at, plan_id, updated_at, idempotency_key, issued_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
+	cmd.UpdatedAt = cmd.CreatedAt
 	result, err := r.db.Exec(query,
 		cmd.DeviceID,
 		cmd.CommandType,
 		cmd.Parameters,
 		cmd.Status,
 		cmd.CreatedAt.Format(time.RFC3339),
+		cmd.PlanID,
+		cmd.UpdatedAt.Format(time.RFC3339),
+		cmd.IdempotencyKey,
+		cmd.IssuedBy,
 	)
 	if err != nil {
 		return err
@@ -40,10 +46,71 @@ func (r *CommandRepository) Create(cmd *models.DeviceCommand) error {
 	return nil
 }
 
+// commandColumns is the column list shared by all command queries
+const commandColumns = `id, device_id, command_type, parameters, status, created_at, executed_at, result, plan_id, attempts, delivered_at, updated_at, idempotency_key, issued_by`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanCommand scans one device_commands row selected with commandColumns
+func scanCommand(row rowScanner) (*models.DeviceCommand, error) {
+	var cmd models.DeviceCommand
+	var createdAt string
+	var executedAt sql.NullString
+	var parameters sql.NullString
+	var result sql.NullString
+	var planID sql.NullInt64
+	var deliveredAt, updatedAt, idempotencyKey, issuedBy sql.NullString
+
+	if err := row.Scan(
+		&cmd.ID,
+		&cmd.DeviceID,
+		&cmd.CommandType,
+		&parameters,
+		&cmd.Status,
+		&createdAt,
+		&executedAt,
+		&result,
+		&planID,
+		&cmd.Attempts,
+		&deliveredAt,
+		&updatedAt,
+		&idempotencyKey,
+		&issuedBy,
+	); err != nil {
+		return nil, err
+	}
+
+	cmd.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	if parameters.Valid {
+		cmd.Parameters = &parameters.String
+	}
+	if executedAt.Valid {
+		t, _ := time.Parse(time.RFC3339, executedAt.String)
+		cmd.ExecutedAt = &t
+	}
+	if result.Valid {
+		cmd.Result = &result.String
+	}
+	if planID.Valid {
+		cmd.PlanID = &planID.Int64
+	}
+	if deliveredAt.Valid {
+		t, _ := time.Parse(time.RFC3339, deliveredAt.String)
+		cmd.DeliveredAt = &t
+	}
+	cmd.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt.String)
+	cmd.IdempotencyKey = idempotencyKey.String
+	cmd.IssuedBy = issuedBy.String
+	return &cmd, nil
+}
+
 // GetPendingCommands retrieves all pending commands for a device
 func (r *CommandRepository) GetPendingCommands(deviceID string) ([]*models.DeviceCommand, error) {
 	query := `
-		SELECT id, device_id, command_type, parameters, status, created_at, executed_at, result
+		SELECT ` + commandColumns + `
 		FROM device_commands
 		WHERE device_id = ? AND status = 'pending'
 		ORDER BY created_at ASC
@@ -56,71 +123,208 @@ func (r *CommandRepository) GetPendingCommands(deviceID string) ([]*models.Devic
 
 	var commands []*models.DeviceCommand
 	for rows.Next() {
-		var cmd models.DeviceCommand
-		var createdAt string
-		var executedAt sql.NullString
-		var parameters sql.NullString
-		var result sql.NullString
-
-		if err := rows.Scan(
-			&cmd.ID,
-			&cmd.DeviceID,
-			&cmd.CommandType,
-			&parameters,
-			&cmd.Status,
-			&createdAt,
-			&executedAt,
-			&result,
-		); err != nil {
+		cmd, err := scanCommand(rows)
+		if err != nil {
 			return nil, err
 		}
+		commands = append(commands, cmd)
+	}
 
-		cmd.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
-		if parameters.Valid {
-			cmd.Parameters = &parameters.String
-		}
-		if executedAt.Valid {
-			t, _ := time.Parse(time.RFC3339, executedAt.String)
-			cmd.ExecutedAt = &t
+	return commands, nil
+}
+
+// Query retrieves a device's commands, newest first, filtered by status,
+// command type and creation time, with the total count for pagination
+func (r *CommandRepository) Query(deviceID string, status, commandType *string, startTime, endTime *time.Time, limit, offset int) ([]*models.DeviceCommand, int, error) {
+	where := ` WHERE device_id = ?`
+	args := []interface{}{deviceID}
+
+	if status != nil && *status != "" {
+		where += ` AND status = ?`
+		args = append(args, *status)
+	}
+	if commandType != nil && *commandType != "" {
+		where += ` AND command_type = ?`
+		args = append(args, *commandType)
+	}
+	if startTime != nil {
+		where += ` AND created_at >= ?`
+		args = append(args, startTime.In(time.Local).Format(time.RFC3339))
+	}
+	if endTime != nil {
+		where += ` AND created_at <= ?`
+		args = append(args, endTime.In(time.Local).Format(time.RFC3339))
+	}
+
+	// Get total count
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM device_commands`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	// Get data
+	query := `SELECT ` + commandColumns + ` FROM device_commands` + where + ` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`
+	rows, err := r.db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	commands := []*models.DeviceCommand{}
+	for rows.Next() {
+		cmd, err := scanCommand(rows)
+		if err != nil {
+			return nil, 0, err
 		}
-		if result.Valid {
-			cmd.Result = &result.String
+		commands = append(commands, cmd)
+	}
+
+	return commands, total, rows.Err()
+}
+
+// GetByPlanID retrieves all commands dispatched for an irrigation plan row
+func (r *CommandRepository) GetByPlanID(planID int64) ([]*models.DeviceCommand, error) {
+	query := `
+		SELECT ` + commandColumns + `
+		FROM device_commands
+		WHERE plan_id = ?
+		ORDER BY created_at ASC
+	`
+	rows, err := r.db.Query(query, planID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var commands []*models.DeviceCommand
+	for rows.Next() {
+		cmd, err := scanCommand(rows)
+		if err != nil {
+			return nil, err
 		}
-		commands = append(commands, &cmd)
+		commands = append(commands, cmd)
 	}
 
 	return commands, nil
 }
 
-// MarkExecuted marks a command as executed
-func (r *CommandRepository) MarkExecuted(commandID int64) error {
+// GetByID retrieves a command by ID
+func (r *CommandRepository) GetByID(commandID int64) (*models.DeviceCommand, error) {
 	query := `
-		UPDATE device_commands
-		SET status = 'completed', executed_at = ?
+		SELECT ` + commandColumns + `
+		FROM device_commands
 		WHERE id = ?
 	`
-	_, err := r.db.Exec(query, time.Now().Format(time.RFC3339), commandID)
-	return err
+	return scanCommand(r.db.QueryRow(query, commandID))
 }
 
-// MarkFailed marks a command as failed
-func (r *CommandRepository) MarkFailed(commandID int64) error {
+// GetCompleted retrieves completed commands of a type executed in [start, end)
+func (r *CommandRepository) GetCompleted(deviceID, commandType string, start, end time.Time) ([]*models.DeviceCommand, error) {
+	query := `
+		SELECT ` + commandColumns + `
+		FROM device_commands
+		WHERE device_id = ? AND command_type = ? AND status = 'completed'
+			AND executed_at >= ? AND executed_at < ?
+		ORDER BY executed_at ASC
+	`
+	rows, err := r.db.Query(query, deviceID, commandType, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var commands []*models.DeviceCommand
+	for rows.Next() {
+		cmd, err := scanCommand(rows)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+
+	return commands, nil
+}
+
+// Transition moves a command to status if its current status is one of
+// from. It reports false when the command does not exist or is in another
+// status. result replaces the stored result unless nil.
+func (r *CommandRepository) Transition(commandID int64, from []string, status string, result *string) (bool, error) {
+	now := time.Now().Format(time.RFC3339)
+	// 执行结束（完成或失败）时记录执行时间
+	var executedAt *string
+	if status == "completed" || status == "failed" {
+		executedAt = &now
+	}
+
+	args := []interface{}{status, result, executedAt, now, commandID}
+	for _, f := range from {
+		args = append(args, f)
+	}
 	query := `
 		UPDATE device_commands
-		SET status = 'failed', executed_at = ?
-		WHERE id = ?
+		SET status = ?, result = COALESCE(?, result), executed_at = COALESCE(?, executed_at), updated_at = ?
+		WHERE id = ? AND status IN (` + placeholders(len(from)) + `)
 	`
-	_, err := r.db.Exec(query, time.Now().Format(time.RFC3339), commandID)
-	return err
+	res, err := r.db.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
 }
 
-// UpdateCommandStatus updates command status with result
-func (r *CommandRepository) UpdateCommandStatus(commandID int64, status string, result *string) error {
+// MarkDelivered moves a pending command to delivered and counts the attempt;
+// commands requeued by the sweeper are counted again on each redelivery.
+// It reports false when the command is no longer pending.
+func (r *CommandRepository) MarkDelivered(commandID int64) (bool, error) {
+	now := time.Now().Format(time.RFC3339)
 	query := `
 		UPDATE device_commands
-		SET status = ?, executed_at = ?, result = ?
-		WHERE id = ?
+		SET status = 'delivered', attempts = attempts + 1, delivered_at = ?, updated_at = ?
+		WHERE id = ? AND status = 'pending'
+	`
+	res, err := r.db.Exec(query, now, now, commandID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// GetStale retrieves commands of all devices that have been in status since
+// before the given time
+func (r *CommandRepository) GetStale(status string, before time.Time) ([]*models.DeviceCommand, error) {
+	query := `
+		SELECT ` + commandColumns + `
+		FROM device_commands
+		WHERE status = ? AND updated_at < ?
+		ORDER BY updated_at ASC
 	`
-	_, err := r.db.Exec(query, status, time.Now().Format(time.RFC3339), result, commandID)
-	return err
+	rows, err := r.db.Query(query, status, before.Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var commands []*models.DeviceCommand
+	for rows.Next() {
+		cmd, err := scanCommand(rows)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+
+	return commands, nil
+}
+
+// placeholders returns n comma separated SQL placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
 }
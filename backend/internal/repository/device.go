@@ -151,6 +151,13 @@ func (r *DeviceRepository) GetDeviceByUserID(userID int64) (*models.Device, erro
 	return &device, nil
 }
 
+// Exists 检查设备是否已注册
+func (r *DeviceRepository) Exists(deviceID string) (bool, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM devices WHERE device_id = ?`, deviceID).Scan(&count)
+	return count > 0, err
+}
+
 // UpdateDeviceName 更新设备名称
 func (r *DeviceRepository) UpdateDeviceName(deviceID string, deviceName string) error {
 	now := time.Now().Format(time.RFC3339)
@@ -238,3 +245,121 @@ func (r *DeviceRepository) GetAllDevices() ([]*models.Device, error) {
 
 	return devices, nil
 }
+
+// GetCredentials 获取设备密钥信息（仅哈希）
+func (r *DeviceRepository) GetCredentials(deviceID string) (*models.DeviceCredentials, error) {
+	query := `
+		SELECT device_id, api_key_hash, api_key_issued_at, previous_api_key_hash, previous_api_key_expires_at, api_key_revoked_at,
+			api_signing_secret, previous_api_signing_secret
+		FROM devices
+		WHERE device_id = ?
+	`
+
+	var creds models.DeviceCredentials
+	var keyHash, issuedAt, previousKeyHash, previousExpiresAt, revokedAt sql.NullString
+	var signingSecret, previousSigningSecret sql.NullString
+
+	err := r.db.QueryRow(query, deviceID).Scan(
+		&creds.DeviceID,
+		&keyHash,
+		&issuedAt,
+		&previousKeyHash,
+		&previousExpiresAt,
+		&revokedAt,
+		&signingSecret,
+		&previousSigningSecret,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyHash.Valid {
+		creds.KeyHash = &keyHash.String
+		creds.Provisioned = true
+	}
+	if previousKeyHash.Valid {
+		creds.PreviousKeyHash = &previousKeyHash.String
+	}
+	if signingSecret.Valid {
+		creds.SigningSecret = &signingSecret.String
+		creds.SigningEnabled = keyHash.Valid
+	}
+	if previousSigningSecret.Valid {
+		creds.PreviousSigningSecret = &previousSigningSecret.String
+	}
+	if issuedAt.Valid {
+		t, _ := time.Parse(time.RFC3339, issuedAt.String)
+		creds.IssuedAt = &t
+	}
+	if previousExpiresAt.Valid {
+		t, _ := time.Parse(time.RFC3339, previousExpiresAt.String)
+		creds.PreviousKeyExpiresAt = &t
+	}
+	if revokedAt.Valid {
+		t, _ := time.Parse(time.RFC3339, revokedAt.String)
+		creds.RevokedAt = &t
+	}
+
+	return &creds, nil
+}
+
+// SetAPIKey 设置设备密钥哈希和加密的签名密钥；previousKeyHash 为空时旧密钥立即失效
+func (r *DeviceRepository) SetAPIKey(deviceID, keyHash, signingSecret string, previousKeyHash, previousSigningSecret *string, previousExpiresAt *time.Time, issuedAt time.Time) error {
+	var expiresAt *string
+	if previousKeyHash != nil && previousExpiresAt != nil {
+		s := previousExpiresAt.Format(time.RFC3339)
+		expiresAt = &s
+	} else {
+		previousKeyHash, previousSigningSecret = nil, nil
+	}
+
+	query := `
+		UPDATE devices
+		SET api_key_hash = ?, api_signing_secret = ?, api_key_issued_at = ?,
+			previous_api_key_hash = ?, previous_api_signing_secret = ?, previous_api_key_expires_at = ?,
+			api_key_revoked_at = NULL, updated_at = ?
+		WHERE device_id = ?
+	`
+	now := issuedAt.Format(time.RFC3339)
+	_, err := r.db.Exec(query, keyHash, signingSecret, now, previousKeyHash, previousSigningSecret, expiresAt, now, deviceID)
+	return err
+}
+
+// RevokeAPIKey 吊销设备的当前密钥和旧密钥
+func (r *DeviceRepository) RevokeAPIKey(deviceID string, revokedAt time.Time) error {
+	query := `
+		UPDATE devices
+		SET api_key_hash = NULL, api_signing_secret = NULL,
+			previous_api_key_hash = NULL, previous_api_signing_secret = NULL, previous_api_key_expires_at = NULL,
+			api_key_revoked_at = ?, updated_at = ?
+		WHERE device_id = ?
+	`
+	now := revokedAt.Format(time.RFC3339)
+	_, err := r.db.Exec(query, now, now, deviceID)
+	return err
+}
+
+// RecordNonce 记录设备请求的随机数直到 expiresAt，并删除该设备已过期的随机数；
+// 随机数已使用且未过期时返回 false
+func (r *DeviceRepository) RecordNonce(deviceID, nonce string, expiresAt, now time.Time) (bool, error) {
+	var recorded bool
+	err := WithTx(r.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(
+			`DELETE FROM device_nonces WHERE device_id = ? AND expires_at < ?`,
+			deviceID, now.Unix(),
+		); err != nil {
+			return err
+		}
+		result, err := tx.Exec(
+			`INSERT OR IGNORE INTO device_nonces (device_id, nonce, expires_at) VALUES (?, ?, ?)`,
+			deviceID, nonce, expiresAt.Unix(),
+		)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		recorded = affected == 1
+		return err
+	})
+	return recorded, err
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+type DeviceConfigRepository struct {
+	db *sql.DB
+}
+
+func NewDeviceConfigRepository(db *sql.DB) *DeviceConfigRepository {
+	return &DeviceConfigRepository{db: db}
+}
+
+// Get retrieves the desired and reported settings of a device. Drift and
+// Converged are left for the caller to compute.
+func (r *DeviceConfigRepository) Get(deviceID string) (*models.DeviceTwin, error) {
+	query := `
+		SELECT device_id, desired, desired_updated_at, desired_updated_by, reported, reported_at, last_command_id
+		FROM device_config
+		WHERE device_id = ?
+	`
+	var twin models.DeviceTwin
+	var desired, desiredUpdatedAt, desiredUpdatedBy, reported, reportedAt sql.NullString
+	var lastCommandID sql.NullInt64
+	err := r.db.QueryRow(query, deviceID).Scan(
+		&twin.DeviceID,
+		&desired,
+		&desiredUpdatedAt,
+		&desiredUpdatedBy,
+		&reported,
+		&reportedAt,
+		&lastCommandID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if desired.Valid {
+		if err := json.Unmarshal([]byte(desired.String), &twin.Desired); err != nil {
+			return nil, err
+		}
+	}
+	if desiredUpdatedAt.Valid {
+		t, _ := time.Parse(time.RFC3339, desiredUpdatedAt.String)
+		twin.DesiredUpdatedAt = &t
+	}
+	twin.DesiredUpdatedBy = desiredUpdatedBy.String
+	if reported.Valid {
+		twin.Reported = &models.DeviceSettings{}
+		if err := json.Unmarshal([]byte(reported.String), twin.Reported); err != nil {
+			return nil, err
+		}
+	}
+	if reportedAt.Valid {
+		t, _ := time.Parse(time.RFC3339, reportedAt.String)
+		twin.ReportedAt = &t
+	}
+	if lastCommandID.Valid {
+		twin.LastCommandID = &lastCommandID.Int64
+	}
+	return &twin, nil
+}
+
+// SetDesired stores the desired settings of a device
+func (r *DeviceConfigRepository) SetDesired(deviceID string, settings *models.DeviceSettings, updatedBy string, updatedAt time.Time) error {
+	desired, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO device_config (device_id, desired, desired_updated_at, desired_updated_by)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET
+			desired = excluded.desired,
+			desired_updated_at = excluded.desired_updated_at,
+			desired_updated_by = excluded.desired_updated_by
+	`
+	_, err = r.db.Exec(query, deviceID, string(desired), updatedAt.Format(time.RFC3339), updatedBy)
+	return err
+}
+
+// SetReported stores the settings a device reported
+func (r *DeviceConfigRepository) SetReported(deviceID string, settings *models.DeviceSettings, reportedAt time.Time) error {
+	reported, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO device_config (device_id, reported, reported_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET
+			reported = excluded.reported,
+			reported_at = excluded.reported_at
+	`
+	_, err = r.db.Exec(query, deviceID, string(reported), reportedAt.Format(time.RFC3339))
+	return err
+}
+
+// SetLastCommand records the latest update_config command queued to
+// converge the device's settings
+func (r *DeviceConfigRepository) SetLastCommand(deviceID string, commandID int64) error {
+	_, err := r.db.Exec(`UPDATE device_config SET last_command_id = ? WHERE device_id = ?`, commandID, deviceID)
+	return err
+}
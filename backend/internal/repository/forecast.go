@@ -2,6 +2,8 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"irrigation-system/backend/internal/models"
@@ -15,10 +17,16 @@ func NewForecastRepository(db *sql.DB) *ForecastRepository {
 	return &ForecastRepository{db: db}
 }
 
-// DeleteFutureForecasts deletes all forecasts from today onwards
-func (r *ForecastRepository) DeleteFutureForecasts() error {
-	query := `DELETE FROM rain_forecast WHERE date >= date('now')`
-	_, err := r.db.Exec(query)
+// LocationKey returns the storage key of a location. Coordinates are rounded
+// to two decimals, the same precision used for QWeather requests.
+func LocationKey(latitude, longitude float64) string {
+	return fmt.Sprintf("%.2f,%.2f", latitude, longitude)
+}
+
+// DeleteFutureForecasts deletes all forecasts of a location from today onwards
+func (r *ForecastRepository) DeleteFutureForecasts(locationKey string) error {
+	query := `DELETE FROM rain_forecast WHERE location_key = ? AND date >= date('now')`
+	_, err := r.db.Exec(query, locationKey)
 	return err
 }
 
@@ -31,8 +39,19 @@ func (r *ForecastRepository) CreateBatch(forecasts []*models.RainForecast) error
 	defer tx.Rollback()
 
 	stmt, err := tx.Prepare(`
-		INSERT INTO rain_forecast (date, temp_max, temp_min, precip_mm, humidity_pct, raw_json, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO rain_forecast (location_key, latitude, longitude, date, temp_max, temp_min, precip_mm, humidity_pct,
+			precip_prob_pct, raw_json, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(location_key, date) DO UPDATE SET
+			latitude = excluded.latitude,
+			longitude = excluded.longitude,
+			temp_max = excluded.temp_max,
+			temp_min = excluded.temp_min,
+			precip_mm = excluded.precip_mm,
+			humidity_pct = excluded.humidity_pct,
+			precip_prob_pct = excluded.precip_prob_pct,
+			raw_json = excluded.raw_json,
+			created_at = excluded.created_at
 	`)
 	if err != nil {
 		return err
@@ -41,11 +60,15 @@ func (r *ForecastRepository) CreateBatch(forecasts []*models.RainForecast) error
 
 	for _, forecast := range forecasts {
 		_, err := stmt.Exec(
+			forecast.LocationKey,
+			forecast.Latitude,
+			forecast.Longitude,
 			forecast.Date,
 			forecast.TempMax,
 			forecast.TempMin,
 			forecast.PrecipMm,
 			forecast.HumidityPct,
+			forecast.PrecipProbPct,
 			forecast.RawJSON,
 			forecast.CreatedAt.Format(time.RFC3339),
 		)
@@ -57,40 +80,123 @@ func (r *ForecastRepository) CreateBatch(forecasts []*models.RainForecast) error
 	return tx.Commit()
 }
 
-// GetForecastDays retrieves forecast data for the next N days
-func (r *ForecastRepository) GetForecastDays(days int) ([]*models.RainForecast, error) {
+// GetForecastDays retrieves forecast data of a location for the next N days
+func (r *ForecastRepository) GetForecastDays(locationKey string, days int) ([]*models.RainForecast, error) {
 	query := `
-		SELECT id, date, temp_max, temp_min, precip_mm, humidity_pct, raw_json, created_at
+		SELECT id, location_key, latitude, longitude, date, temp_max, temp_min, precip_mm, humidity_pct,
+			precip_prob_pct, raw_json, created_at
 		FROM rain_forecast
-		WHERE date >= date('now')
+		WHERE location_key = ? AND date >= date('now')
 		ORDER BY date ASC
 		LIMIT ?
 	`
-	rows, err := r.db.Query(query, days)
+	rows, err := r.db.Query(query, locationKey, days)
+	if err != nil {
+		return nil, err
+	}
+	return scanForecasts(rows)
+}
+
+// GetRange retrieves stored forecasts of a location for dates in [startDate, endDate].
+// 过去日期保留的是最后一次预报，可近似作为实际天气
+func (r *ForecastRepository) GetRange(locationKey, startDate, endDate string) ([]*models.RainForecast, error) {
+	query := `
+		SELECT id, location_key, latitude, longitude, date, temp_max, temp_min, precip_mm, humidity_pct,
+			precip_prob_pct, raw_json, created_at
+		FROM rain_forecast
+		WHERE location_key = ? AND date >= ? AND date <= ?
+		ORDER BY date ASC
+	`
+	rows, err := r.db.Query(query, locationKey, startDate, endDate)
 	if err != nil {
 		return nil, err
 	}
+	return scanForecasts(rows)
+}
+
+func scanForecasts(rows *sql.Rows) ([]*models.RainForecast, error) {
 	defer rows.Close()
 
 	var forecasts []*models.RainForecast
 	for rows.Next() {
 		var f models.RainForecast
+		var latitude, longitude sql.NullFloat64
 		var createdAt string
 		if err := rows.Scan(
 			&f.ID,
+			&f.LocationKey,
+			&latitude,
+			&longitude,
 			&f.Date,
 			&f.TempMax,
 			&f.TempMin,
 			&f.PrecipMm,
 			&f.HumidityPct,
+			&f.PrecipProbPct,
 			&f.RawJSON,
 			&createdAt,
 		); err != nil {
 			return nil, err
 		}
+		if latitude.Valid {
+			f.Latitude = &latitude.Float64
+		}
+		if longitude.Valid {
+			f.Longitude = &longitude.Float64
+		}
 		f.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
 		forecasts = append(forecasts, &f)
 	}
 
 	return forecasts, nil
 }
+
+// SaveSnapshot stores the forecast days a plan was computed from. When the
+// location's latest snapshot has the same days it is reused; snapshot.ID is
+// set either way. The snapshot is written within tx.
+func (r *ForecastRepository) SaveSnapshot(tx *sql.Tx, snapshot *models.ForecastSnapshot) error {
+	days, err := json.Marshal(snapshot.Days)
+	if err != nil {
+		return err
+	}
+
+	var latestID int64
+	var latestDays string
+	err = tx.QueryRow(`
+		SELECT id, days FROM forecast_snapshots
+		WHERE location_key = ?
+		ORDER BY id DESC
+		LIMIT 1
+	`, snapshot.LocationKey).Scan(&latestID, &latestDays)
+	if err == nil && latestDays == string(days) {
+		snapshot.ID = latestID
+		return nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	result, err := tx.Exec(`INSERT INTO forecast_snapshots (location_key, days, created_at) VALUES (?, ?, ?)`,
+		snapshot.LocationKey, string(days), snapshot.CreatedAt.Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	snapshot.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetSnapshot retrieves a forecast snapshot
+func (r *ForecastRepository) GetSnapshot(id int64) (*models.ForecastSnapshot, error) {
+	var snapshot models.ForecastSnapshot
+	var days, createdAt string
+	err := r.db.QueryRow(`SELECT id, location_key, days, created_at FROM forecast_snapshots WHERE id = ?`, id).
+		Scan(&snapshot.ID, &snapshot.LocationKey, &days, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(days), &snapshot.Days); err != nil {
+		return nil, fmt.Errorf("invalid forecast snapshot %d: %w", id, err)
+	}
+	snapshot.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	return &snapshot, nil
+}
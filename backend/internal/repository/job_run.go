@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+type JobRunRepository struct {
+	db *sql.DB
+}
+
+func NewJobRunRepository(db *sql.DB) *JobRunRepository {
+	return &JobRunRepository{db: db}
+}
+
+// Create inserts a new job run record
+func (r *JobRunRepository) Create(run *models.JobRun) error {
+	query := `
+		INSERT INTO job_runs (job_name, trigger_type, started_at, status, message)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	result, err := r.db.Exec(query,
+		run.JobName,
+		run.Trigger,
+		run.StartedAt.Format(time.RFC3339),
+		run.Status,
+		run.Message,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	run.ID = id
+	return nil
+}
+
+// Finish records the outcome of a job run
+func (r *JobRunRepository) Finish(run *models.JobRun) error {
+	query := `
+		UPDATE job_runs
+		SET finished_at = ?, status = ?, message = ?
+		WHERE id = ?
+	`
+	var finishedAt *string
+	if run.FinishedAt != nil {
+		s := run.FinishedAt.Format(time.RFC3339)
+		finishedAt = &s
+	}
+	_, err := r.db.Exec(query, finishedAt, run.Status, run.Message, run.ID)
+	return err
+}
+
+// GetLatest retrieves the most recent run of a job
+func (r *JobRunRepository) GetLatest(jobName string) (*models.JobRun, error) {
+	runs, _, err := r.Query(jobName, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return runs[0], nil
+}
+
+// Query retrieves run history for a job, newest first
+func (r *JobRunRepository) Query(jobName string, limit, offset int) ([]*models.JobRun, int, error) {
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM job_runs WHERE job_name = ?`, jobName).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT id, job_name, trigger_type, started_at, finished_at, status, message
+		FROM job_runs
+		WHERE job_name = ?
+		ORDER BY started_at DESC, id DESC
+		LIMIT ? OFFSET ?
+	`
+	rows, err := r.db.Query(query, jobName, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var runs []*models.JobRun
+	for rows.Next() {
+		var run models.JobRun
+		var startedAt string
+		var finishedAt sql.NullString
+		var message sql.NullString
+
+		if err := rows.Scan(
+			&run.ID,
+			&run.JobName,
+			&run.Trigger,
+			&startedAt,
+			&finishedAt,
+			&run.Status,
+			&message,
+		); err != nil {
+			return nil, 0, err
+		}
+
+		run.StartedAt, _ = time.Parse(time.RFC3339, startedAt)
+		if finishedAt.Valid {
+			t, _ := time.Parse(time.RFC3339, finishedAt.String)
+			run.FinishedAt = &t
+		}
+		if message.Valid {
+			run.Message = &message.String
+		}
+		runs = append(runs, &run)
+	}
+
+	return runs, total, nil
+}
+
+// MarkInterrupted marks runs left in 'running' state (e.g. by a crash) as failed
+func (r *JobRunRepository) MarkInterrupted() error {
+	query := `
+		UPDATE job_runs
+		SET status = 'failed', message = 'interrupted by server restart'
+		WHERE status = 'running'
+	`
+	_, err := r.db.Exec(query)
+	return err
+}
+
+// SetPaused stores whether a job is paused
+func (r *JobRunRepository) SetPaused(jobName string, paused bool) error {
+	query := `
+		INSERT INTO job_states (job_name, paused, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(job_name) DO UPDATE SET
+			paused = excluded.paused,
+			updated_at = excluded.updated_at
+	`
+	value := 0
+	if paused {
+		value = 1
+	}
+	_, err := r.db.Exec(query, jobName, value, time.Now().Format(time.RFC3339))
+	return err
+}
+
+// GetPaused returns the names of the paused jobs
+func (r *JobRunRepository) GetPaused() (map[string]bool, error) {
+	rows, err := r.db.Query(`SELECT job_name FROM job_states WHERE paused = 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paused := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		paused[name] = true
+	}
+	return paused, rows.Err()
+}
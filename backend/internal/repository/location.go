@@ -65,3 +65,43 @@ func (r *LocationRepository) Upsert(loc *models.DeviceLocation) error {
 	)
 	return err
 }
+
+// GetAll retrieves all device locations
+func (r *LocationRepository) GetAll() ([]*models.DeviceLocation, error) {
+	query := `
+		SELECT id, device_id, latitude, longitude, address, updated_at
+		FROM device_locations
+		ORDER BY device_id ASC
+	`
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locations []*models.DeviceLocation
+	for rows.Next() {
+		var loc models.DeviceLocation
+		var updatedAt string
+		var address sql.NullString
+
+		if err := rows.Scan(
+			&loc.ID,
+			&loc.DeviceID,
+			&loc.Latitude,
+			&loc.Longitude,
+			&address,
+			&updatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		if address.Valid {
+			loc.Address = &address.String
+		}
+		loc.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+		locations = append(locations, &loc)
+	}
+
+	return locations, nil
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+type OverrideRepository struct {
+	db *sql.DB
+}
+
+func NewOverrideRepository(db *sql.DB) *OverrideRepository {
+	return &OverrideRepository{db: db}
+}
+
+// Upsert inserts or replaces the override of a device for one date
+func (r *OverrideRepository) Upsert(override *models.PlanOverride) error {
+	query := `
+		INSERT INTO plan_overrides (device_id, date, volume_l, reason, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(device_id, date) DO UPDATE SET
+			volume_l = excluded.volume_l,
+			reason = excluded.reason,
+			created_by = excluded.created_by,
+			created_at = excluded.created_at
+	`
+	_, err := r.db.Exec(query,
+		override.DeviceID,
+		override.Date,
+		override.VolumeL,
+		override.Reason,
+		override.CreatedBy,
+		override.CreatedAt.Format(time.RFC3339),
+	)
+	return err
+}
+
+// Get retrieves the override of a device for one date
+func (r *OverrideRepository) Get(deviceID, date string) (*models.PlanOverride, error) {
+	query := `
+		SELECT id, device_id, date, volume_l, reason, created_by, created_at
+		FROM plan_overrides
+		WHERE device_id = ? AND date = ?
+	`
+	return scanOverride(r.db.QueryRow(query, deviceID, date))
+}
+
+// GetFrom retrieves the overrides of a device on or after a date
+func (r *OverrideRepository) GetFrom(deviceID, date string) ([]*models.PlanOverride, error) {
+	query := `
+		SELECT id, device_id, date, volume_l, reason, created_by, created_at
+		FROM plan_overrides
+		WHERE device_id = ? AND date >= ?
+		ORDER BY date ASC
+	`
+	rows, err := r.db.Query(query, deviceID, date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overrides []*models.PlanOverride
+	for rows.Next() {
+		override, err := scanOverride(rows)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, override)
+	}
+	return overrides, rows.Err()
+}
+
+func scanOverride(row rowScanner) (*models.PlanOverride, error) {
+	var o models.PlanOverride
+	var reason, createdBy sql.NullString
+	var createdAt string
+	if err := row.Scan(&o.ID, &o.DeviceID, &o.Date, &o.VolumeL, &reason, &createdBy, &createdAt); err != nil {
+		return nil, err
+	}
+	o.Reason = reason.String
+	o.CreatedBy = createdBy.String
+	o.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	return &o, nil
+}
+
+// Delete removes the override of a device for one date
+func (r *OverrideRepository) Delete(deviceID, date string) error {
+	result, err := r.db.Exec(`DELETE FROM plan_overrides WHERE device_id = ? AND date = ?`, deviceID, date)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("override not found")
+	}
+
+	return nil
+}
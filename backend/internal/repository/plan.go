@@ -2,11 +2,19 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"irrigation-system/backend/internal/models"
 )
 
+const planColumns = `id, device_id, date, version_id, planned_volume_l, start_moisture, predicted_moisture,
+	et_l, rain_l, cost_deviation, cost_water, cost_smoothness, locked, created_at`
+
+const versionColumns = `id, device_id, version, reason, soil_reading, soil_reading_at, drift,
+	forecast_snapshot_id, params, days, total_volume_l, total_cost, created_at`
+
 type PlanRepository struct {
 	db *sql.DB
 }
@@ -15,41 +23,37 @@ func NewPlanRepository(db *sql.DB) *PlanRepository {
 	return &PlanRepository{db: db}
 }
 
-// GetByDate retrieves irrigation plan for a specific date
+// GetByDate retrieves the irrigation plan for a specific date from the newest
+// version that covers it
 func (r *PlanRepository) GetByDate(deviceID, date string) (*models.IrrigationPlan, error) {
-	query := `
-		SELECT id, device_id, date, planned_volume_l, created_at
-		FROM irrigation_plan
+	query := `SELECT ` + planColumns + ` FROM irrigation_plan
 		WHERE device_id = ? AND date = ?
-	`
-	var plan models.IrrigationPlan
-	var createdAt string
-
-	err := r.db.QueryRow(query, deviceID, date).Scan(
-		&plan.ID,
-		&plan.DeviceID,
-		&plan.Date,
-		&plan.PlannedVolumeL,
-		&createdAt,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	plan.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
-	return &plan, nil
+		ORDER BY version_id DESC
+		LIMIT 1`
+	return scanPlan(r.db.QueryRow(query, deviceID, date))
 }
 
-// GetFuturePlans retrieves all future irrigation plans for a device
+// GetFuturePlans retrieves the future days of the device's latest plan version
 func (r *PlanRepository) GetFuturePlans(deviceID string, days int) ([]*models.IrrigationPlan, error) {
 	query := `
-		SELECT id, device_id, date, planned_volume_l, created_at
+		SELECT ` + planColumns + `
 		FROM irrigation_plan
 		WHERE device_id = ? AND date >= date('now')
+			AND version_id = (SELECT MAX(version_id) FROM irrigation_plan WHERE device_id = ?)
 		ORDER BY date ASC
 		LIMIT ?
 	`
-	rows, err := r.db.Query(query, deviceID, days)
+	return r.queryPlans(query, deviceID, deviceID, days)
+}
+
+// GetByVersion retrieves all days of one plan version
+func (r *PlanRepository) GetByVersion(versionID int64) ([]*models.IrrigationPlan, error) {
+	query := `SELECT ` + planColumns + ` FROM irrigation_plan WHERE version_id = ? ORDER BY date ASC`
+	return r.queryPlans(query, versionID)
+}
+
+func (r *PlanRepository) queryPlans(query string, args ...interface{}) ([]*models.IrrigationPlan, error) {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -57,45 +61,69 @@ func (r *PlanRepository) GetFuturePlans(deviceID string, days int) ([]*models.Ir
 
 	var plans []*models.IrrigationPlan
 	for rows.Next() {
-		var plan models.IrrigationPlan
-		var createdAt string
-		if err := rows.Scan(
-			&plan.ID,
-			&plan.DeviceID,
-			&plan.Date,
-			&plan.PlannedVolumeL,
-			&createdAt,
-		); err != nil {
+		plan, err := scanPlan(rows)
+		if err != nil {
 			return nil, err
 		}
-		plan.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
-		plans = append(plans, &plan)
+		plans = append(plans, plan)
 	}
 
-	return plans, nil
+	return plans, rows.Err()
 }
 
-// DeleteFuturePlans deletes all future plans for a device
-func (r *PlanRepository) DeleteFuturePlans(deviceID string) error {
-	query := `DELETE FROM irrigation_plan WHERE device_id = ? AND date >= date('now')`
-	_, err := r.db.Exec(query, deviceID)
-	return err
-}
+// CreateVersion stores a new plan version with its days. The version number
+// is assigned here, one above the device's latest; version.ID, version.Version
+// and each plan's VersionID are set on success. The rows are written within tx.
+func (r *PlanRepository) CreateVersion(tx *sql.Tx, version *models.PlanVersion, plans []*models.IrrigationPlan) error {
+	params, err := json.Marshal(version.Params)
+	if err != nil {
+		return err
+	}
+
+	var latest int
+	err = tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM plan_versions WHERE device_id = ?`, version.DeviceID).Scan(&latest)
+	if err != nil {
+		return err
+	}
 
-// CreateBatch inserts multiple irrigation plans
-func (r *PlanRepository) CreateBatch(plans []*models.IrrigationPlan) error {
-	tx, err := r.db.Begin()
+	var soilReadingAt interface{}
+	if version.SoilReadingAt != nil {
+		soilReadingAt = version.SoilReadingAt.Format(time.RFC3339)
+	}
+	var snapshotID interface{}
+	if version.ForecastSnapshotID > 0 {
+		snapshotID = version.ForecastSnapshotID
+	}
+	result, err := tx.Exec(`
+		INSERT INTO plan_versions (device_id, version, reason, soil_reading, soil_reading_at, drift,
+			forecast_snapshot_id, params, days, total_volume_l, total_cost, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		version.DeviceID,
+		latest+1,
+		version.Reason,
+		version.SoilReading,
+		soilReadingAt,
+		version.Drift,
+		snapshotID,
+		string(params),
+		version.Days,
+		version.TotalVolumeL,
+		version.TotalCost,
+		version.CreatedAt.Format(time.RFC3339),
+	)
+	if err != nil {
+		return err
+	}
+	versionID, err := result.LastInsertId()
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
 
 	stmt, err := tx.Prepare(`
-		INSERT INTO irrigation_plan (device_id, date, planned_volume_l, created_at)
-		VALUES (?, ?, ?, ?)
-		ON CONFLICT(device_id, date) DO UPDATE SET
-			planned_volume_l = excluded.planned_volume_l,
-			created_at = excluded.created_at
+		INSERT INTO irrigation_plan (device_id, date, version_id, planned_volume_l, start_moisture, predicted_moisture,
+			et_l, rain_l, cost_deviation, cost_water, cost_smoothness, locked, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		return err
@@ -103,10 +131,24 @@ func (r *PlanRepository) CreateBatch(plans []*models.IrrigationPlan) error {
 	defer stmt.Close()
 
 	for _, plan := range plans {
+		plan.VersionID = versionID
+		locked := 0
+		if plan.Locked {
+			locked = 1
+		}
 		_, err := stmt.Exec(
 			plan.DeviceID,
 			plan.Date,
+			plan.VersionID,
 			plan.PlannedVolumeL,
+			plan.StartMoisture,
+			plan.PredictedMoisture,
+			plan.ETL,
+			plan.RainL,
+			plan.Cost.Deviation,
+			plan.Cost.Water,
+			plan.Cost.Smoothness,
+			locked,
 			plan.CreatedAt.Format(time.RFC3339),
 		)
 		if err != nil {
@@ -114,5 +156,131 @@ func (r *PlanRepository) CreateBatch(plans []*models.IrrigationPlan) error {
 		}
 	}
 
-	return tx.Commit()
+	version.ID, version.Version = versionID, latest+1
+	return nil
+}
+
+// GetVersion retrieves one plan version of a device by its version number
+func (r *PlanRepository) GetVersion(deviceID string, version int) (*models.PlanVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM plan_versions WHERE device_id = ? AND version = ?`
+	return scanVersion(r.db.QueryRow(query, deviceID, version))
+}
+
+// GetLatestVersion retrieves the newest plan version of a device
+func (r *PlanRepository) GetLatestVersion(deviceID string) (*models.PlanVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM plan_versions WHERE device_id = ? ORDER BY version DESC LIMIT 1`
+	return scanVersion(r.db.QueryRow(query, deviceID))
+}
+
+// QueryVersions lists plan versions of a device, newest first
+func (r *PlanRepository) QueryVersions(deviceID string, limit, offset int) ([]*models.PlanVersion, int, error) {
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM plan_versions WHERE device_id = ?`, deviceID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + versionColumns + ` FROM plan_versions
+		WHERE device_id = ?
+		ORDER BY version DESC
+		LIMIT ? OFFSET ?`
+	rows, err := r.db.Query(query, deviceID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	versions := []*models.PlanVersion{}
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, total, rows.Err()
+}
+
+func scanVersion(row rowScanner) (*models.PlanVersion, error) {
+	var v models.PlanVersion
+	var soilReadingAt sql.NullString
+	var drift sql.NullFloat64
+	var snapshotID sql.NullInt64
+	var params, createdAt string
+
+	err := row.Scan(
+		&v.ID,
+		&v.DeviceID,
+		&v.Version,
+		&v.Reason,
+		&v.SoilReading,
+		&soilReadingAt,
+		&drift,
+		&snapshotID,
+		&params,
+		&v.Days,
+		&v.TotalVolumeL,
+		&v.TotalCost,
+		&createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if soilReadingAt.Valid {
+		if t, err := time.Parse(time.RFC3339, soilReadingAt.String); err == nil {
+			v.SoilReadingAt = &t
+		}
+	}
+	if drift.Valid {
+		v.Drift = &drift.Float64
+	}
+	v.ForecastSnapshotID = snapshotID.Int64
+	v.Params = &models.PlannerProfile{}
+	if err := json.Unmarshal([]byte(params), v.Params); err != nil {
+		return nil, fmt.Errorf("invalid params of plan version %d: %w", v.ID, err)
+	}
+	v.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	return &v, nil
+}
+
+func scanPlan(row rowScanner) (*models.IrrigationPlan, error) {
+	var plan models.IrrigationPlan
+	var start, predicted, et, rain, deviation, water, smoothness sql.NullFloat64
+	var locked int
+	var createdAt string
+
+	err := row.Scan(
+		&plan.ID,
+		&plan.DeviceID,
+		&plan.Date,
+		&plan.VersionID,
+		&plan.PlannedVolumeL,
+		&start,
+		&predicted,
+		&et,
+		&rain,
+		&deviation,
+		&water,
+		&smoothness,
+		&locked,
+		&createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// 旧计划没有解释数据，按0返回
+	plan.StartMoisture = start.Float64
+	plan.PredictedMoisture = predicted.Float64
+	plan.ETL = et.Float64
+	plan.RainL = rain.Float64
+	plan.Cost = models.PlanCost{
+		Deviation:  deviation.Float64,
+		Water:      water.Float64,
+		Smoothness: smoothness.Float64,
+		Total:      deviation.Float64 + water.Float64 + smoothness.Float64,
+	}
+	plan.Locked = locked != 0
+	plan.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	return &plan, nil
 }
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+type ProfileRepository struct {
+	db *sql.DB
+}
+
+func NewProfileRepository(db *sql.DB) *ProfileRepository {
+	return &ProfileRepository{db: db}
+}
+
+// Get retrieves the planner profile of a device
+func (r *ProfileRepository) Get(deviceID string) (*models.PlannerProfile, error) {
+	query := `
+		SELECT device_id, crop, soil_type, pot_size_l, soil_optimal_min, soil_optimal_max,
+			max_irrigation_per_day, base_et, temp_factor, rain_conversion, adc_to_moisture,
+			cost_w1, cost_w2, cost_w3, et_model, canopy_area_m2, planting_date,
+			volume_budget_l, allowed_weekdays, min_days_between, moisture_floor, risk_aversion, updated_at
+		FROM device_planner_profiles
+		WHERE device_id = ?
+	`
+	var p models.PlannerProfile
+	var crop, soilType, plantingDate, weekdays sql.NullString
+	var potSize sql.NullFloat64
+	var updatedAt string
+
+	err := r.db.QueryRow(query, deviceID).Scan(
+		&p.DeviceID,
+		&crop,
+		&soilType,
+		&potSize,
+		&p.SoilOptimalMin,
+		&p.SoilOptimalMax,
+		&p.MaxIrrigationPerDay,
+		&p.BaseET,
+		&p.TempFactor,
+		&p.RainConversion,
+		&p.ADCToMoisture,
+		&p.CostW1,
+		&p.CostW2,
+		&p.CostW3,
+		&p.ETModel,
+		&p.CanopyAreaM2,
+		&plantingDate,
+		&p.VolumeBudgetL,
+		&weekdays,
+		&p.MinDaysBetween,
+		&p.MoistureFloor,
+		&p.RiskAversion,
+		&updatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Source = "device"
+	p.Crop = crop.String
+	p.SoilType = soilType.String
+	p.PotSizeL = potSize.Float64
+	p.PlantingDate = plantingDate.String
+	p.AllowedWeekdays = []string{}
+	if weekdays.String != "" {
+		p.AllowedWeekdays = strings.Split(weekdays.String, ",")
+	}
+	if t, err := time.Parse(time.RFC3339, updatedAt); err == nil {
+		p.UpdatedAt = &t
+	}
+	return &p, nil
+}
+
+// Upsert inserts or updates the planner profile of a device
+func (r *ProfileRepository) Upsert(p *models.PlannerProfile) error {
+	query := `
+		INSERT INTO device_planner_profiles
+		(device_id, crop, soil_type, pot_size_l, soil_optimal_min, soil_optimal_max,
+			max_irrigation_per_day, base_et, temp_factor, rain_conversion, adc_to_moisture,
+			cost_w1, cost_w2, cost_w3, et_model, canopy_area_m2, planting_date,
+			volume_budget_l, allowed_weekdays, min_days_between, moisture_floor, risk_aversion, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET
+			crop = excluded.crop,
+			soil_type = excluded.soil_type,
+			pot_size_l = excluded.pot_size_l,
+			soil_optimal_min = excluded.soil_optimal_min,
+			soil_optimal_max = excluded.soil_optimal_max,
+			max_irrigation_per_day = excluded.max_irrigation_per_day,
+			base_et = excluded.base_et,
+			temp_factor = excluded.temp_factor,
+			rain_conversion = excluded.rain_conversion,
+			adc_to_moisture = excluded.adc_to_moisture,
+			cost_w1 = excluded.cost_w1,
+			cost_w2 = excluded.cost_w2,
+			cost_w3 = excluded.cost_w3,
+			et_model = excluded.et_model,
+			canopy_area_m2 = excluded.canopy_area_m2,
+			planting_date = excluded.planting_date,
+			volume_budget_l = excluded.volume_budget_l,
+			allowed_weekdays = excluded.allowed_weekdays,
+			min_days_between = excluded.min_days_between,
+			moisture_floor = excluded.moisture_floor,
+			risk_aversion = excluded.risk_aversion,
+			updated_at = excluded.updated_at
+	`
+	_, err := r.db.Exec(query,
+		p.DeviceID,
+		nullIfEmpty(p.Crop),
+		nullIfEmpty(p.SoilType),
+		p.PotSizeL,
+		p.SoilOptimalMin,
+		p.SoilOptimalMax,
+		p.MaxIrrigationPerDay,
+		p.BaseET,
+		p.TempFactor,
+		p.RainConversion,
+		p.ADCToMoisture,
+		p.CostW1,
+		p.CostW2,
+		p.CostW3,
+		p.ETModel,
+		p.CanopyAreaM2,
+		nullIfEmpty(p.PlantingDate),
+		p.VolumeBudgetL,
+		nullIfEmpty(strings.Join(p.AllowedWeekdays, ",")),
+		p.MinDaysBetween,
+		p.MoistureFloor,
+		p.RiskAversion,
+		time.Now().Format(time.RFC3339),
+	)
+	return err
+}
+
+// Delete removes the planner profile of a device
+func (r *ProfileRepository) Delete(deviceID string) error {
+	result, err := r.db.Exec(`DELETE FROM device_planner_profiles WHERE device_id = ?`, deviceID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("profile not found")
+	}
+
+	return nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+type QuarantineRepository struct {
+	db *sql.DB
+}
+
+func NewQuarantineRepository(db *sql.DB) *QuarantineRepository {
+	return &QuarantineRepository{db: db}
+}
+
+// Create stores an upload from an unregistered device and drops the
+// device's oldest uploads beyond limit. Uploads from a device not yet in
+// quarantine are not stored once maxDevices devices are quarantined; Create
+// reports whether the upload was stored.
+func (r *QuarantineRepository) Create(data *models.QuarantinedData, limit, maxDevices int) (bool, error) {
+	stored := false
+	err := WithTx(r.db, func(tx *sql.Tx) error {
+		var known bool
+		if err := tx.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM device_quarantine WHERE device_id = ?)`, data.DeviceID,
+		).Scan(&known); err != nil {
+			return err
+		}
+		if !known {
+			var devices int
+			if err := tx.QueryRow(`SELECT COUNT(DISTINCT device_id) FROM device_quarantine`).Scan(&devices); err != nil {
+				return err
+			}
+			if devices >= maxDevices {
+				return nil
+			}
+		}
+
+		result, err := tx.Exec(
+			`INSERT INTO device_quarantine (device_id, payload, received_at) VALUES (?, ?, ?)`,
+			data.DeviceID, data.Payload, data.ReceivedAt.Format(time.RFC3339),
+		)
+		if err != nil {
+			return err
+		}
+		data.ID, err = result.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			DELETE FROM device_quarantine
+			WHERE device_id = ? AND id NOT IN (
+				SELECT id FROM device_quarantine WHERE device_id = ? ORDER BY id DESC LIMIT ?
+			)
+		`, data.DeviceID, data.DeviceID, limit)
+		stored = err == nil
+		return err
+	})
+	return stored, err
+}
+
+// GetDevices summarizes the quarantined uploads per device, most recently
+// seen first
+func (r *QuarantineRepository) GetDevices() ([]models.QuarantinedDevice, error) {
+	rows, err := r.db.Query(`
+		SELECT device_id, COUNT(*), MIN(received_at), MAX(received_at)
+		FROM device_quarantine
+		GROUP BY device_id
+		ORDER BY MAX(received_at) DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []models.QuarantinedDevice{}
+	for rows.Next() {
+		var d models.QuarantinedDevice
+		var firstSeen, lastSeen string
+		if err := rows.Scan(&d.DeviceID, &d.Uploads, &firstSeen, &lastSeen); err != nil {
+			return nil, err
+		}
+		d.FirstSeen, _ = time.Parse(time.RFC3339, firstSeen)
+		d.LastSeen, _ = time.Parse(time.RFC3339, lastSeen)
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// Query retrieves a device's quarantined uploads, newest first. A limit of
+// 0 or less returns all of them.
+func (r *QuarantineRepository) Query(deviceID string, limit, offset int) ([]*models.QuarantinedData, int, error) {
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM device_quarantine WHERE device_id = ?`, deviceID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT id, device_id, payload, received_at
+		FROM device_quarantine
+		WHERE device_id = ?
+		ORDER BY id DESC
+	`
+	args := []interface{}{deviceID}
+	if limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var entries []*models.QuarantinedData
+	for rows.Next() {
+		var entry models.QuarantinedData
+		var receivedAt string
+		if err := rows.Scan(&entry.ID, &entry.DeviceID, &entry.Payload, &receivedAt); err != nil {
+			return nil, 0, err
+		}
+		entry.ReceivedAt, _ = time.Parse(time.RFC3339, receivedAt)
+		entries = append(entries, &entry)
+	}
+	return entries, total, rows.Err()
+}
+
+// DeleteByDevice removes all quarantined uploads of a device
+func (r *QuarantineRepository) DeleteByDevice(deviceID string) (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM device_quarantine WHERE device_id = ?`, deviceID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+	"sort"
 	"time"
 
 	"irrigation-system/backend/internal/models"
@@ -144,9 +145,97 @@ func (r *SensorDataRepository) GetHistory(deviceID string, startTime, endTime *t
 	return dataList, total, nil
 }
 
-// GetTodayIrrigationVolume calculates the total irrigation volume for today
-func (r *SensorDataRepository) GetTodayIrrigationVolume(deviceID string) (float64, error) {
-	// 这里简化处理，实际应该根据水泵开启时间和流量计算
-	// 目前返回 0，由后续任务完善
-	return 0, nil
+// GetSoilSamples retrieves soil moisture readings between start and end (inclusive)
+func (r *SensorDataRepository) GetSoilSamples(deviceID string, start, end time.Time) ([]models.SoilSample, error) {
+	const margin = 14 * time.Hour // 最大时区偏移
+
+	query := `
+		SELECT timestamp, soil_raw
+		FROM sensor_data
+		WHERE device_id = ? AND timestamp >= ? AND timestamp <= ? AND soil_raw IS NOT NULL
+		ORDER BY timestamp ASC
+	`
+	rows, err := r.db.Query(query, deviceID,
+		start.Add(-margin).Format(time.RFC3339),
+		end.Add(margin).Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []models.SoilSample
+	for rows.Next() {
+		var timestamp string
+		var sample models.SoilSample
+		if err := rows.Scan(&timestamp, &sample.SoilRaw); err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil || t.Before(start) || t.After(end) {
+			continue
+		}
+		sample.Timestamp = t
+		samples = append(samples, sample)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Timestamp.Before(samples[j].Timestamp) })
+	return samples, nil
+}
+
+// GetPumpSamples retrieves pump state samples between start and end (inclusive),
+// plus the last sample before start so the state at the start is known.
+// 设备上报的时间戳可能带不同时区，按字符串粗筛后再按实际时间过滤
+func (r *SensorDataRepository) GetPumpSamples(deviceID string, start, end time.Time) ([]models.PumpSample, error) {
+	const margin = 14 * time.Hour // 最大时区偏移
+
+	query := `
+		SELECT timestamp, pump_state
+		FROM sensor_data
+		WHERE device_id = ? AND timestamp >= ? AND timestamp <= ?
+		ORDER BY timestamp ASC
+	`
+	rows, err := r.db.Query(query, deviceID,
+		start.Add(-24*time.Hour-margin).Format(time.RFC3339),
+		end.Add(margin).Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []models.PumpSample
+	for rows.Next() {
+		var timestamp string
+		var pumpState sql.NullString
+		if err := rows.Scan(&timestamp, &pumpState); err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			continue
+		}
+		all = append(all, models.PumpSample{Timestamp: t, PumpState: pumpState.String})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].Timestamp.Before(all[j].Timestamp) })
+
+	var samples []models.PumpSample
+	for i, sample := range all {
+		if sample.Timestamp.Before(start) {
+			if i+1 < len(all) && all[i+1].Timestamp.Before(start) {
+				continue
+			}
+		} else if sample.Timestamp.After(end) {
+			break
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
 }
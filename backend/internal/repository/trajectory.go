@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+type TrajectoryRepository struct {
+	db *sql.DB
+}
+
+func NewTrajectoryRepository(db *sql.DB) *TrajectoryRepository {
+	return &TrajectoryRepository{db: db}
+}
+
+// Create inserts a trajectory together with its points within tx
+func (r *TrajectoryRepository) Create(tx *sql.Tx, t *models.PlanTrajectory) error {
+	result, err := tx.Exec(`
+		INSERT INTO plan_trajectories (device_id, reason, start_moisture, drift, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, t.DeviceID, t.Reason, t.StartMoisture, t.Drift, t.CreatedAt.Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO plan_trajectory_points (trajectory_id, date, predicted_moisture, planned_volume_l)
+		VALUES (?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, p := range t.Points {
+		if _, err := stmt.Exec(id, p.Date, p.PredictedMoisture, p.PlannedVolumeL); err != nil {
+			return err
+		}
+	}
+
+	t.ID = id
+	return nil
+}
+
+// GetLatest retrieves the most recent trajectory of a device
+func (r *TrajectoryRepository) GetLatest(deviceID string) (*models.PlanTrajectory, error) {
+	query := `
+		SELECT id, device_id, reason, start_moisture, drift, created_at
+		FROM plan_trajectories
+		WHERE device_id = ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	`
+	t, err := scanTrajectory(r.db.QueryRow(query, deviceID))
+	if err != nil {
+		return nil, err
+	}
+	if t.Points, err = r.getPoints(t.ID); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetSince retrieves all trajectories of a device created at or after since,
+// oldest first
+func (r *TrajectoryRepository) GetSince(deviceID string, since time.Time) ([]*models.PlanTrajectory, error) {
+	query := `
+		SELECT id, device_id, reason, start_moisture, drift, created_at
+		FROM plan_trajectories
+		WHERE device_id = ? AND created_at >= ?
+		ORDER BY created_at ASC, id ASC
+	`
+	rows, err := r.db.Query(query, deviceID, since.Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trajectories []*models.PlanTrajectory
+	for rows.Next() {
+		t, err := scanTrajectory(rows)
+		if err != nil {
+			return nil, err
+		}
+		trajectories = append(trajectories, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, t := range trajectories {
+		if t.Points, err = r.getPoints(t.ID); err != nil {
+			return nil, err
+		}
+	}
+	return trajectories, nil
+}
+
+func (r *TrajectoryRepository) getPoints(trajectoryID int64) ([]models.TrajectoryPoint, error) {
+	query := `
+		SELECT date, predicted_moisture, planned_volume_l
+		FROM plan_trajectory_points
+		WHERE trajectory_id = ?
+		ORDER BY date ASC
+	`
+	rows, err := r.db.Query(query, trajectoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []models.TrajectoryPoint{}
+	for rows.Next() {
+		var p models.TrajectoryPoint
+		if err := rows.Scan(&p.Date, &p.PredictedMoisture, &p.PlannedVolumeL); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+func scanTrajectory(row rowScanner) (*models.PlanTrajectory, error) {
+	var t models.PlanTrajectory
+	var drift sql.NullFloat64
+	var createdAt string
+	if err := row.Scan(&t.ID, &t.DeviceID, &t.Reason, &t.StartMoisture, &drift, &createdAt); err != nil {
+		return nil, err
+	}
+	if drift.Valid {
+		t.Drift = &drift.Float64
+	}
+	t.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	return &t, nil
+}
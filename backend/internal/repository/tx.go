@@ -0,0 +1,19 @@
+package repository
+
+import "database/sql"
+
+// WithTx runs fn in a transaction, which is committed if fn returns nil and
+// rolled back otherwise. Repository methods that take a *sql.Tx can be
+// combined this way into one atomic write.
+func WithTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+type VolumeRepository struct {
+	db *sql.DB
+}
+
+func NewVolumeRepository(db *sql.DB) *VolumeRepository {
+	return &VolumeRepository{db: db}
+}
+
+// Upsert inserts or updates the executed volume of a device for one day
+func (r *VolumeRepository) Upsert(v *models.DailyIrrigationVolume) error {
+	query := `
+		INSERT INTO irrigation_volume_daily
+		(device_id, date, command_volume_l, pump_runtime_sec, pump_volume_l, executed_volume_l, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(device_id, date) DO UPDATE SET
+			command_volume_l = excluded.command_volume_l,
+			pump_runtime_sec = excluded.pump_runtime_sec,
+			pump_volume_l = excluded.pump_volume_l,
+			executed_volume_l = excluded.executed_volume_l,
+			updated_at = excluded.updated_at
+	`
+	_, err := r.db.Exec(query,
+		v.DeviceID,
+		v.Date,
+		v.CommandVolumeL,
+		v.PumpRuntimeSec,
+		v.PumpVolumeL,
+		v.ExecutedVolumeL,
+		v.UpdatedAt.Format(time.RFC3339),
+	)
+	return err
+}
+
+// GetRange retrieves executed volumes of a device between two dates (inclusive)
+func (r *VolumeRepository) GetRange(deviceID, startDate, endDate string) ([]*models.DailyIrrigationVolume, error) {
+	query := `
+		SELECT device_id, date, command_volume_l, pump_runtime_sec, pump_volume_l, executed_volume_l, updated_at
+		FROM irrigation_volume_daily
+		WHERE device_id = ? AND date >= ? AND date <= ?
+		ORDER BY date ASC
+	`
+	rows, err := r.db.Query(query, deviceID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var volumes []*models.DailyIrrigationVolume
+	for rows.Next() {
+		var v models.DailyIrrigationVolume
+		var updatedAt string
+		if err := rows.Scan(
+			&v.DeviceID,
+			&v.Date,
+			&v.CommandVolumeL,
+			&v.PumpRuntimeSec,
+			&v.PumpVolumeL,
+			&v.ExecutedVolumeL,
+			&updatedAt,
+		); err != nil {
+			return nil, err
+		}
+		v.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+		volumes = append(volumes, &v)
+	}
+
+	return volumes, nil
+}
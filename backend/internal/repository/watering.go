@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+type WateringRepository struct {
+	db *sql.DB
+}
+
+func NewWateringRepository(db *sql.DB) *WateringRepository {
+	return &WateringRepository{db: db}
+}
+
+// GetSchedule retrieves the watering windows of a device
+func (r *WateringRepository) GetSchedule(deviceID string) (*models.WateringSchedule, error) {
+	query := `
+		SELECT device_id, windows, enabled, updated_at
+		FROM watering_schedules
+		WHERE device_id = ?
+	`
+	var schedule models.WateringSchedule
+	var windows, updatedAt string
+	var enabled int
+
+	err := r.db.QueryRow(query, deviceID).Scan(
+		&schedule.DeviceID,
+		&windows,
+		&enabled,
+		&updatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.Windows = splitWindows(windows)
+	schedule.Enabled = enabled != 0
+	schedule.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+	return &schedule, nil
+}
+
+// UpsertSchedule inserts or updates the watering windows of a device
+func (r *WateringRepository) UpsertSchedule(schedule *models.WateringSchedule) error {
+	query := `
+		INSERT INTO watering_schedules (device_id, windows, enabled, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET
+			windows = excluded.windows,
+			enabled = excluded.enabled,
+			updated_at = excluded.updated_at
+	`
+	enabled := 0
+	if schedule.Enabled {
+		enabled = 1
+	}
+	_, err := r.db.Exec(query,
+		schedule.DeviceID,
+		strings.Join(schedule.Windows, ","),
+		enabled,
+		schedule.UpdatedAt.Format(time.RFC3339),
+	)
+	return err
+}
+
+// GetDispatches retrieves executor records of a device for one day
+func (r *WateringRepository) GetDispatches(deviceID, date string) ([]*models.PlanDispatch, error) {
+	query := `
+		SELECT id, device_id, date, window, plan_id, command_id, volume_l, status, created_at
+		FROM plan_dispatches
+		WHERE device_id = ? AND date = ?
+		ORDER BY window ASC
+	`
+	rows, err := r.db.Query(query, deviceID, date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dispatches []*models.PlanDispatch
+	for rows.Next() {
+		var d models.PlanDispatch
+		var planID, commandID sql.NullInt64
+		var createdAt string
+
+		if err := rows.Scan(
+			&d.ID,
+			&d.DeviceID,
+			&d.Date,
+			&d.Window,
+			&planID,
+			&commandID,
+			&d.VolumeL,
+			&d.Status,
+			&createdAt,
+		); err != nil {
+			return nil, err
+		}
+
+		if planID.Valid {
+			d.PlanID = &planID.Int64
+		}
+		if commandID.Valid {
+			d.CommandID = &commandID.Int64
+		}
+		d.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		dispatches = append(dispatches, &d)
+	}
+
+	return dispatches, nil
+}
+
+// CreateDispatch records an executor decision. It returns false without error
+// when the window has already been handled.
+func (r *WateringRepository) CreateDispatch(d *models.PlanDispatch) (bool, error) {
+	query := `
+		INSERT INTO plan_dispatches (device_id, date, window, plan_id, command_id, volume_l, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(device_id, date, window) DO NOTHING
+	`
+	result, err := r.db.Exec(query,
+		d.DeviceID,
+		d.Date,
+		d.Window,
+		d.PlanID,
+		d.CommandID,
+		d.VolumeL,
+		d.Status,
+		d.CreatedAt.Format(time.RFC3339),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return false, err
+	}
+	d.ID = id
+	return true, nil
+}
+
+// SetDispatchCommand links a dispatch record to the command it created
+func (r *WateringRepository) SetDispatchCommand(dispatchID, commandID int64) error {
+	_, err := r.db.Exec(`UPDATE plan_dispatches SET command_id = ? WHERE id = ?`, commandID, dispatchID)
+	return err
+}
+
+func splitWindows(s string) []string {
+	var windows []string
+	for _, w := range strings.Split(s, ",") {
+		if w = strings.TrimSpace(w); w != "" {
+			windows = append(windows, w)
+		}
+	}
+	return windows
+}
+
+// DeleteDispatch removes a dispatch record, releasing its window
+func (r *WateringRepository) DeleteDispatch(dispatchID int64) error {
+	_, err := r.db.Exec(`DELETE FROM plan_dispatches WHERE id = ?`, dispatchID)
+	return err
+}
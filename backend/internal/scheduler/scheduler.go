@@ -0,0 +1,296 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+	"irrigation-system/backend/internal/repository"
+)
+
+// ErrJobNotFound is returned for job names that were never registered
+var ErrJobNotFound = errors.New("job not found")
+
+// JobFunc is the work performed by a scheduled job. The returned string is
+// stored as the run message on success.
+type JobFunc func() (string, error)
+
+type job struct {
+	name     string
+	schedule *Schedule
+	fn       JobFunc
+	paused   bool
+	running  bool
+}
+
+// Scheduler runs registered jobs on cron-like schedules and records each run
+type Scheduler struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	runRepo *repository.JobRunRepository
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewScheduler creates a new scheduler instance
+func NewScheduler(runRepo *repository.JobRunRepository) *Scheduler {
+	return &Scheduler{
+		jobs:    make(map[string]*job),
+		runRepo: runRepo,
+	}
+}
+
+// Register adds a job. An empty spec registers the job for manual triggering only.
+func (s *Scheduler) Register(name, spec string, fn JobFunc) error {
+	var schedule *Schedule
+	if spec != "" {
+		var err error
+		schedule, err = ParseSchedule(spec)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.jobs[name]; exists {
+		return fmt.Errorf("job %s already registered", name)
+	}
+	s.jobs[name] = &job{name: name, schedule: schedule, fn: fn}
+	return nil
+}
+
+// Start launches the scheduling loop in a background goroutine
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	if s.stop != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.stop = make(chan struct{})
+	s.mu.Unlock()
+
+	// 上次异常退出时残留的running记录
+	if err := s.runRepo.MarkInterrupted(); err != nil {
+		log.Printf("[Scheduler] Failed to clean up interrupted runs: %v", err)
+	}
+
+	// 恢复重启前暂停的任务
+	paused, err := s.runRepo.GetPaused()
+	if err != nil {
+		log.Printf("[Scheduler] Failed to load paused jobs: %v", err)
+	}
+	s.mu.Lock()
+	for name := range paused {
+		if j, ok := s.jobs[name]; ok {
+			j.paused = true
+			log.Printf("[Scheduler] Job %s is paused", name)
+		}
+	}
+	s.mu.Unlock()
+
+	go s.loop()
+}
+
+// Stop stops the scheduling loop and waits for running jobs to finish
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if s.stop == nil {
+		s.mu.Unlock()
+		return
+	}
+	close(s.stop)
+	s.stop = nil
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop() {
+	s.mu.Lock()
+	stop := s.stop
+	s.mu.Unlock()
+
+	for {
+		// 对齐到下一分钟整点
+		now := time.Now()
+		wait := now.Truncate(time.Minute).Add(time.Minute).Sub(now)
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case tick := <-timer.C:
+			s.runDue(tick)
+		}
+	}
+}
+
+// runDue starts every unpaused job whose schedule matches the given minute
+func (s *Scheduler) runDue(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range s.jobs {
+		if j.schedule == nil || j.paused || !j.schedule.Matches(t) {
+			continue
+		}
+		if j.running {
+			log.Printf("[Scheduler] Skipping %s: previous run still in progress", j.name)
+			continue
+		}
+		s.launch(j, "schedule")
+	}
+}
+
+// launch runs a job asynchronously; callers must hold s.mu
+func (s *Scheduler) launch(j *job, trigger string) {
+	j.running = true
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+		s.execute(j, trigger)
+
+		s.mu.Lock()
+		j.running = false
+		s.mu.Unlock()
+	}()
+}
+
+func (s *Scheduler) execute(j *job, trigger string) {
+	run := &models.JobRun{
+		JobName:   j.name,
+		Trigger:   trigger,
+		StartedAt: time.Now(),
+		Status:    "running",
+	}
+	if err := s.runRepo.Create(run); err != nil {
+		log.Printf("[Scheduler] Failed to record run of %s: %v", j.name, err)
+	}
+
+	msg, err := safeRun(j.fn)
+
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	if err != nil {
+		run.Status = "failed"
+		errMsg := err.Error()
+		run.Message = &errMsg
+		log.Printf("[Scheduler] Job %s failed: %v", j.name, err)
+	} else {
+		run.Status = "success"
+		if msg != "" {
+			run.Message = &msg
+		}
+		log.Printf("[Scheduler] Job %s completed in %v", j.name, finishedAt.Sub(run.StartedAt))
+	}
+
+	if run.ID != 0 {
+		if err := s.runRepo.Finish(run); err != nil {
+			log.Printf("[Scheduler] Failed to record result of %s: %v", j.name, err)
+		}
+	}
+}
+
+// safeRun executes fn and converts a panic into an error
+func safeRun(fn JobFunc) (msg string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// Trigger runs a job immediately, regardless of its schedule or paused state
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, name)
+	}
+	if j.running {
+		return fmt.Errorf("job %s is already running", name)
+	}
+	s.launch(j, "manual")
+	return nil
+}
+
+// Pause stops a job from running on its schedule. The paused state is kept
+// across restarts.
+func (s *Scheduler) Pause(name string) error {
+	return s.setPaused(name, true)
+}
+
+// Resume re-enables a paused job
+func (s *Scheduler) Resume(name string) error {
+	return s.setPaused(name, false)
+}
+
+func (s *Scheduler) setPaused(name string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, name)
+	}
+	if err := s.runRepo.SetPaused(name, paused); err != nil {
+		return fmt.Errorf("failed to save job state: %w", err)
+	}
+	j.paused = paused
+	return nil
+}
+
+// Jobs returns the state of all registered jobs, sorted by name
+func (s *Scheduler) Jobs() []models.JobInfo {
+	s.mu.Lock()
+	infos := make([]models.JobInfo, 0, len(s.jobs))
+	now := time.Now()
+	for _, j := range s.jobs {
+		info := models.JobInfo{
+			Name:    j.name,
+			Paused:  j.paused,
+			Running: j.running,
+		}
+		if j.schedule != nil {
+			info.Schedule = j.schedule.String()
+			if !j.paused {
+				if next := j.schedule.Next(now); !next.IsZero() {
+					info.NextRun = &next
+				}
+			}
+		}
+		infos = append(infos, info)
+	}
+	s.mu.Unlock()
+
+	for i := range infos {
+		if last, err := s.runRepo.GetLatest(infos[i].Name); err == nil {
+			infos[i].LastRun = last
+		}
+	}
+
+	sort.Slice(infos, func(a, b int) bool { return infos[a].Name < infos[b].Name })
+	return infos
+}
+
+// Runs returns the run history of a job
+func (s *Scheduler) Runs(name string, limit, offset int) ([]*models.JobRun, int, error) {
+	s.mu.Lock()
+	_, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: %s", ErrJobNotFound, name)
+	}
+	return s.runRepo.Query(name, limit, offset)
+}
@@ -0,0 +1,94 @@
+package scheduler
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"irrigation-system/backend/internal/database"
+	"irrigation-system/backend/internal/repository"
+)
+
+// newTestDB returns a fresh database with the application schema
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.InitSchema("../../configs/schema.sql"); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+	return db.DB
+}
+
+// newTestScheduler registers two manual jobs on a new scheduler
+func newTestScheduler(t *testing.T, db *sql.DB) *Scheduler {
+	t.Helper()
+	s := NewScheduler(repository.NewJobRunRepository(db))
+	noop := func() (string, error) { return "ok", nil }
+	for _, name := range []string{"job_a", "job_b"} {
+		if err := s.Register(name, "0 * * * *", noop); err != nil {
+			t.Fatalf("register %s: %v", name, err)
+		}
+	}
+	return s
+}
+
+// pausedJobs returns the paused state of the registered jobs
+func pausedJobs(s *Scheduler) map[string]bool {
+	paused := make(map[string]bool)
+	for _, info := range s.Jobs() {
+		paused[info.Name] = info.Paused
+	}
+	return paused
+}
+
+func TestPausedJobsSurviveRestart(t *testing.T) {
+	db := newTestDB(t)
+
+	first := newTestScheduler(t, db)
+	first.Start()
+	if err := first.Pause("job_a"); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	if err := first.Pause("job_b"); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	if err := first.Resume("job_b"); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	first.Stop()
+
+	// 模拟重启：新的调度器在 Start 时恢复暂停状态
+	second := newTestScheduler(t, db)
+	if paused := pausedJobs(second); paused["job_a"] {
+		t.Fatalf("paused state loaded before Start")
+	}
+	second.Start()
+	defer second.Stop()
+
+	paused := pausedJobs(second)
+	if !paused["job_a"] || paused["job_b"] {
+		t.Errorf("got paused %v, want only job_a paused", paused)
+	}
+	for _, info := range second.Jobs() {
+		if info.Name == "job_a" && info.NextRun != nil {
+			t.Errorf("paused job has a next run")
+		}
+	}
+}
+
+func TestUnknownJob(t *testing.T) {
+	s := newTestScheduler(t, newTestDB(t))
+	for name, fn := range map[string]func(string) error{"Pause": s.Pause, "Resume": s.Resume, "Trigger": s.Trigger} {
+		if err := fn("missing"); !errors.Is(err, ErrJobNotFound) {
+			t.Errorf("%s: got %v, want ErrJobNotFound", name, err)
+		}
+	}
+	if _, _, err := s.Runs("missing", 10, 0); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Runs: got %v, want ErrJobNotFound", err)
+	}
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+	"irrigation-system/backend/internal/planner"
+	"irrigation-system/backend/internal/repository"
+	"irrigation-system/backend/internal/weather"
+)
+
+// dailyWeather is the weather of one past day used for calibration
+type dailyWeather struct {
+	tempAvg  float64
+	precipMm float64
+}
+
+// CalibrateAllDevices fits planner coefficients for every device. Results are
+// stored as pending runs for an admin to review.
+func (s *Service) CalibrateAllDevices() (string, error) {
+	devices, err := s.deviceRepo.GetAllDevices()
+	if err != nil {
+		return "", fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	var skipped []string
+	for _, device := range devices {
+		if _, err := s.CalibrateDevice(device.DeviceID); err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", device.DeviceID, err))
+		}
+	}
+
+	// 数据不足的设备很常见，不视为任务失败
+	summary := fmt.Sprintf("calibrated %d/%d devices", len(devices)-len(skipped), len(devices))
+	if len(skipped) > 0 {
+		summary += "; skipped: " + strings.Join(skipped, "; ")
+	}
+	return summary, nil
+}
+
+// CalibrateDevice fits the device's moisture model to its recent history and
+// stores the result as a pending calibration run
+func (s *Service) CalibrateDevice(deviceID string) (*models.CalibrationRun, error) {
+	base, err := s.plannerConfigFor(deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	today, _ := time.ParseInLocation("2006-01-02", time.Now().Format("2006-01-02"), time.Local)
+	start := today.AddDate(0, 0, -s.cfg.Calibration.LookbackDays)
+	end := today.AddDate(0, 0, -1)
+
+	samples, source, err := s.calibrationSamples(deviceID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) < s.cfg.Calibration.MinSamples {
+		return nil, fmt.Errorf("only %d usable days, need %d", len(samples), s.cfg.Calibration.MinSamples)
+	}
+
+	result, err := planner.Calibrate(base, samples)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &models.CalibrationRun{
+		DeviceID:       deviceID,
+		Status:         "pending",
+		StartDate:      start.Format("2006-01-02"),
+		EndDate:        end.Format("2006-01-02"),
+		Samples:        result.Samples,
+		WeatherSource:  source,
+		BaseET:         roundTo(result.BaseET, 4),
+		TempFactor:     roundTo(result.TempFactor, 4),
+		RainConversion: roundTo(result.RainConversion, 4),
+		ADCToMoisture:  roundTo(result.ADCToMoisture, 4),
+		Fixed:          result.Fixed,
+		RSquared:       roundTo(result.RSquared, 4),
+		RMSE:           roundTo(result.RMSE, 2),
+		BaselineRMSE:   roundTo(result.BaselineRMSE, 2),
+		CreatedAt:      time.Now(),
+	}
+	if run.Fixed == nil {
+		run.Fixed = []string{}
+	}
+	if err := s.calibrationRepo.Create(run); err != nil {
+		return nil, fmt.Errorf("failed to store calibration: %w", err)
+	}
+	return run, nil
+}
+
+// calibrationSamples assembles one sample per day in [start, end] that has
+// soil readings on that and the previous day, executed volume and weather
+func (s *Service) calibrationSamples(deviceID string, start, end time.Time) ([]planner.CalibrationSample, string, error) {
+	soil, err := s.sensorDataRepo.GetSoilSamples(deviceID, start.AddDate(0, 0, -1), end.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get soil samples: %w", err)
+	}
+	// 每天最后一次读数作为当日结束时的湿度
+	endOfDay := make(map[string]float64)
+	for _, sample := range soil {
+		endOfDay[sample.Timestamp.In(time.Local).Format("2006-01-02")] = float64(sample.SoilRaw)
+	}
+
+	volumes, err := s.GetExecutedVolumes(deviceID, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return nil, "", err
+	}
+	executed := make(map[string]float64, len(volumes))
+	for _, v := range volumes {
+		executed[v.Date] = v.ExecutedVolumeL
+	}
+
+	weatherByDate, source := s.weatherHistory(deviceID, start, end)
+
+	var samples []planner.CalibrationSample
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		date := d.Format("2006-01-02")
+		prev := d.AddDate(0, 0, -1).Format("2006-01-02")
+
+		startMoisture, ok1 := endOfDay[prev]
+		endMoisture, ok2 := endOfDay[date]
+		w, ok3 := weatherByDate[date]
+		if !ok1 || !ok2 || !ok3 {
+			continue
+		}
+		samples = append(samples, planner.CalibrationSample{
+			Date:          date,
+			StartMoisture: startMoisture,
+			EndMoisture:   endMoisture,
+			IrrigationL:   executed[date],
+			TempAvg:       w.tempAvg,
+			PrecipMm:      w.precipMm,
+		})
+	}
+	return samples, source, nil
+}
+
+// weatherHistory returns past weather for the device's location. Observed
+// data from the weather provider is preferred; days it lacks are filled from
+// the last stored forecast.
+func (s *Service) weatherHistory(deviceID string, start, end time.Time) (map[string]dailyWeather, string) {
+	byDate := make(map[string]dailyWeather)
+	latitude, longitude := s.forecastLocation(deviceID)
+
+	var sources []string
+	if hp, ok := s.weatherClient.(weather.HistoricalProvider); ok {
+		if days, err := hp.HistoricalDaily(latitude, longitude, start, end); err == nil && len(days) > 0 {
+			for _, d := range days {
+				byDate[d.Date] = dailyWeather{tempAvg: (d.TempMax + d.TempMin) / 2, precipMm: d.PrecipMm}
+			}
+			sources = append(sources, "observed")
+		}
+	}
+
+	filled := false
+	for _, key := range []string{repository.LocationKey(latitude, longitude), ""} {
+		forecasts, err := s.forecastRepo.GetRange(key, start.Format("2006-01-02"), end.Format("2006-01-02"))
+		if err != nil {
+			continue
+		}
+		for _, f := range forecasts {
+			if _, ok := byDate[f.Date]; ok || f.TempMax == nil || f.TempMin == nil || f.PrecipMm == nil {
+				continue
+			}
+			byDate[f.Date] = dailyWeather{tempAvg: (*f.TempMax + *f.TempMin) / 2, precipMm: *f.PrecipMm}
+			filled = true
+		}
+	}
+	if filled {
+		sources = append(sources, "forecast")
+	}
+	if len(sources) == 0 {
+		return byDate, "none"
+	}
+	return byDate, strings.Join(sources, "+")
+}
+
+// GetCalibrations lists calibration runs
+func (s *Service) GetCalibrations(deviceID, status string, limit, offset int) ([]*models.CalibrationRun, int, error) {
+	return s.calibrationRepo.Query(deviceID, status, limit, offset)
+}
+
+// GetCalibration returns one calibration run
+func (s *Service) GetCalibration(id int64) (*models.CalibrationRun, error) {
+	return s.calibrationRepo.GetByID(id)
+}
+
+// ApplyCalibration writes the fitted coefficients into the device's planner
+// profile, keeping its other parameters
+func (s *Service) ApplyCalibration(id int64) (*models.PlannerProfile, error) {
+	run, err := s.calibrationRepo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if run.Status != "pending" {
+		return nil, fmt.Errorf("calibration is already %s", run.Status)
+	}
+
+	profile, err := s.GetPlannerProfile(run.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	profile.BaseET = run.BaseET
+	profile.TempFactor = run.TempFactor
+	profile.RainConversion = run.RainConversion
+	profile.ADCToMoisture = run.ADCToMoisture
+	if err := profileToPlannerConfig(profile).Validate(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	profile.Source = "device"
+	profile.UpdatedAt = &now
+
+	ok, err := s.calibrationRepo.UpdateStatus(id, "applied")
+	if err != nil {
+		return nil, fmt.Errorf("failed to update calibration: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("calibration is no longer pending")
+	}
+	if err := s.profileRepo.Upsert(profile); err != nil {
+		return nil, fmt.Errorf("failed to save planner profile: %w", err)
+	}
+
+	s.logRepo.Create(&models.DeviceLog{
+		DeviceID:  run.DeviceID,
+		Timestamp: now,
+		Level:     "INFO",
+		Message:   fmt.Sprintf("Planner calibration #%d applied (R²=%.2f, RMSE %.1f → %.1f)", id, run.RSquared, run.BaselineRMSE, run.RMSE),
+	})
+	return profile, nil
+}
+
+// RejectCalibration marks a pending calibration run as rejected
+func (s *Service) RejectCalibration(id int64) error {
+	ok, err := s.calibrationRepo.UpdateStatus(id, "rejected")
+	if err != nil {
+		return fmt.Errorf("failed to update calibration: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("calibration not found or no longer pending")
+	}
+	return nil
+}
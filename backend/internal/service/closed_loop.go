@@ -0,0 +1,224 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+// CheckPlanDrift compares every device's latest soil reading with its
+// predicted trajectory and replans the devices that drifted too far
+func (s *Service) CheckPlanDrift() (string, error) {
+	if !s.cfg.ClosedLoop.Enabled {
+		return "closed loop disabled", nil
+	}
+
+	devices, err := s.deviceRepo.GetAllDevices()
+	if err != nil {
+		return "", fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	replanned := 0
+	var failures []string
+	for _, device := range devices {
+		ok, err := s.checkDeviceDrift(device.DeviceID, time.Now())
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", device.DeviceID, err))
+			continue
+		}
+		if ok {
+			replanned++
+		}
+	}
+
+	summary := fmt.Sprintf("checked %d devices, replanned %d", len(devices), replanned)
+	if len(failures) > 0 {
+		return summary, fmt.Errorf("%s; failures: %s", summary, strings.Join(failures, "; "))
+	}
+	return summary, nil
+}
+
+// checkDeviceDrift replans one device when its drift exceeds the threshold.
+// It returns whether a new plan was computed.
+func (s *Service) checkDeviceDrift(deviceID string, now time.Time) (bool, error) {
+	trajectory, err := s.trajectoryRepo.GetLatest(deviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil // 尚未计算过计划
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get trajectory: %w", err)
+	}
+
+	// 避免频繁重新规划
+	minInterval := time.Duration(s.cfg.ClosedLoop.MinReplanMinutes) * time.Minute
+	if now.Sub(trajectory.CreatedAt) < minInterval {
+		return false, nil
+	}
+
+	status, err := s.driftStatus(deviceID, trajectory)
+	if err != nil || status == nil {
+		return false, err
+	}
+	if math.Abs(status.Drift) <= float64(status.Threshold) {
+		return false, nil
+	}
+
+	drift := status.Drift
+	if _, err := s.recomputePlan(deviceID, "drift", &drift); err != nil {
+		return false, fmt.Errorf("failed to replan: %w", err)
+	}
+
+	s.logRepo.Create(&models.DeviceLog{
+		DeviceID:  deviceID,
+		Timestamp: time.Now(),
+		Level:     "WARN",
+		Message: fmt.Sprintf("Soil moisture drifted from prediction (observed %.0f, predicted %.0f), plan recomputed",
+			status.ObservedMoisture, status.PredictedMoisture),
+	})
+	return true, nil
+}
+
+// GetDriftStatus compares the latest soil reading with the active trajectory.
+// It returns nil when there is no trajectory or no reading since it was computed.
+func (s *Service) GetDriftStatus(deviceID string) (*models.DriftStatus, error) {
+	trajectory, err := s.trajectoryRepo.GetLatest(deviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trajectory: %w", err)
+	}
+	return s.driftStatus(deviceID, trajectory)
+}
+
+func (s *Service) driftStatus(deviceID string, trajectory *models.PlanTrajectory) (*models.DriftStatus, error) {
+	latest, err := s.sensorDataRepo.GetLatest(deviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest sensor data: %w", err)
+	}
+	if latest.SoilRaw == nil || latest.Timestamp.Before(trajectory.CreatedAt) {
+		return nil, nil
+	}
+
+	predicted, ok := predictedAt(trajectory, latest.Timestamp)
+	if !ok {
+		return nil, nil
+	}
+
+	observed := float64(*latest.SoilRaw)
+	return &models.DriftStatus{
+		PredictedMoisture: roundTo(predicted, 1),
+		ObservedMoisture:  observed,
+		Drift:             roundTo(observed-predicted, 1),
+		Threshold:         s.cfg.ClosedLoop.DriftThreshold,
+		ObservedAt:        latest.Timestamp,
+	}, nil
+}
+
+// predictedAt interpolates the trajectory at time t. The trajectory starts at
+// the moisture measured when it was computed and each point is the predicted
+// moisture at the end of its day.
+func predictedAt(trajectory *models.PlanTrajectory, t time.Time) (float64, bool) {
+	prevTime := trajectory.CreatedAt
+	prevMoisture := float64(trajectory.StartMoisture)
+	if t.Before(prevTime) {
+		return 0, false
+	}
+
+	for _, point := range trajectory.Points {
+		day, err := time.ParseInLocation("2006-01-02", point.Date, time.Local)
+		if err != nil {
+			continue
+		}
+		endOfDay := day.AddDate(0, 0, 1)
+		if !endOfDay.After(prevTime) {
+			continue
+		}
+		if !t.After(endOfDay) {
+			frac := t.Sub(prevTime).Seconds() / endOfDay.Sub(prevTime).Seconds()
+			return prevMoisture + frac*(point.PredictedMoisture-prevMoisture), true
+		}
+		prevTime, prevMoisture = endOfDay, point.PredictedMoisture
+	}
+	return 0, false
+}
+
+// GetPlanTrajectory returns the latest predicted trajectory and a day-by-day
+// comparison of predicted and observed moisture for the past N days and the
+// remaining plan horizon
+func (s *Service) GetPlanTrajectory(deviceID string, days int) (*models.PlanTrajectory, []models.MoistureComparison, error) {
+	latest, err := s.trajectoryRepo.GetLatest(deviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, []models.MoistureComparison{}, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get trajectory: %w", err)
+	}
+
+	now := time.Now()
+	today, _ := time.ParseInLocation("2006-01-02", now.Format("2006-01-02"), time.Local)
+	start := today.AddDate(0, 0, -days)
+
+	// 计划最多覆盖15天，向前多取一些以覆盖起始日期的预测
+	trajectories, err := s.trajectoryRepo.GetSince(deviceID, start.AddDate(0, 0, -16))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get trajectories: %w", err)
+	}
+	samples, err := s.sensorDataRepo.GetSoilSamples(deviceID, start, now)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get soil samples: %w", err)
+	}
+
+	// 每天最后一次读数作为当日结束时的实测湿度
+	observed := make(map[string]float64)
+	for _, sample := range samples {
+		observed[sample.Timestamp.In(time.Local).Format("2006-01-02")] = float64(sample.SoilRaw)
+	}
+
+	end := start
+	if n := len(latest.Points); n > 0 {
+		if last, err := time.ParseInLocation("2006-01-02", latest.Points[n-1].Date, time.Local); err == nil {
+			end = last
+		}
+	}
+
+	var comparison []models.MoistureComparison
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		date := d.Format("2006-01-02")
+		c := models.MoistureComparison{Date: date}
+
+		// 使用当天结束前最后一次计算的预测
+		endOfDay := d.AddDate(0, 0, 1)
+		for _, t := range trajectories {
+			if !t.CreatedAt.Before(endOfDay) {
+				break
+			}
+			for _, p := range t.Points {
+				if p.Date == date {
+					predicted := p.PredictedMoisture
+					c.PredictedMoisture = &predicted
+				}
+			}
+		}
+
+		// 当天尚未结束时不计算实测值
+		if v, ok := observed[date]; ok && d.Before(today) {
+			c.ObservedMoisture = &v
+		}
+		if c.PredictedMoisture != nil && c.ObservedMoisture != nil {
+			drift := roundTo(*c.ObservedMoisture-*c.PredictedMoisture, 1)
+			c.Drift = &drift
+		}
+		comparison = append(comparison, c)
+	}
+
+	return latest, comparison, nil
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+var (
+	// ErrCommandNotFound is returned for unknown command IDs
+	ErrCommandNotFound = errors.New("command not found")
+	// ErrInvalidTransition is returned when a command cannot move to the
+	// requested status from its current one
+	ErrInvalidTransition = errors.New("invalid command status transition")
+	// ErrCommandDeviceMismatch is returned when a device reports the status
+	// of another device's command
+	ErrCommandDeviceMismatch = errors.New("command belongs to another device")
+	// ErrIdempotencyKeyMismatch is returned when a status report carries an
+	// idempotency key the command was not issued with
+	ErrIdempotencyKeyMismatch = errors.New("idempotency key does not match command")
+)
+
+// newIdempotencyKey returns a random key for a new command
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// commandTransitions lists the statuses a command may move to from each
+// status. completed, failed, expired and cancelled are final.
+//
+//	pending → delivered → executing → completed / failed
+//
+// A delivered command the device does not confirm goes back to pending for
+// redelivery, at most Commands.MaxRetries times; irrigate commands are never
+// redelivered. Devices report executing before the result, but that report
+// may be lost, so a delivered command may also move straight to completed
+// or failed. Commands that stall expire; only commands the device has not
+// started executing can be cancelled.
+var commandTransitions = map[string][]string{
+	"pending":   {"delivered", "expired", "cancelled"},
+	"delivered": {"pending", "executing", "completed", "failed", "expired", "cancelled"},
+	"executing": {"completed", "failed", "expired"},
+}
+
+// deviceReportedStatuses are the statuses a device may report
+var deviceReportedStatuses = map[string]bool{
+	"executing": true,
+	"completed": true,
+	"failed":    true,
+}
+
+// transitionSources returns the statuses from which a command may move to status
+func transitionSources(status string) []string {
+	var sources []string
+	for from, targets := range commandTransitions {
+		for _, to := range targets {
+			if to == status {
+				sources = append(sources, from)
+			}
+		}
+	}
+	return sources
+}
+
+// transitionCommand moves a command to status, or returns ErrCommandNotFound
+// or ErrInvalidTransition
+func (s *Service) transitionCommand(commandID int64, status string, result *string) error {
+	ok, err := s.commandRepo.Transition(commandID, transitionSources(status), status, result)
+	if err != nil {
+		return fmt.Errorf("failed to update command status: %w", err)
+	}
+	if ok {
+		return nil
+	}
+
+	cmd, err := s.commandRepo.GetByID(commandID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %d", ErrCommandNotFound, commandID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get command: %w", err)
+	}
+	return fmt.Errorf("%w: %s → %s", ErrInvalidTransition, cmd.Status, status)
+}
+
+// deliverPendingCommands marks the device's pending commands as delivered
+// and returns them
+func (s *Service) deliverPendingCommands(deviceID string) ([]*models.DeviceCommand, error) {
+	commands, err := s.commandRepo.GetPendingCommands(deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var delivered []*models.DeviceCommand
+	for _, cmd := range commands {
+		ok, err := s.commandRepo.MarkDelivered(cmd.ID)
+		if err != nil {
+			return nil, err
+		}
+		// 并发请求已取走或用户已取消的命令不再下发
+		if !ok {
+			continue
+		}
+		now := time.Now()
+		cmd.Status = "delivered"
+		cmd.Attempts++
+		cmd.DeliveredAt = &now
+		cmd.UpdatedAt = now
+		delivered = append(delivered, cmd)
+	}
+	return delivered, nil
+}
+
+// GetCommands lists a device's commands, newest first
+func (s *Service) GetCommands(deviceID string, status, commandType *string, startTime, endTime *time.Time, limit, offset int) ([]*models.DeviceCommand, int, error) {
+	return s.commandRepo.Query(deviceID, status, commandType, startTime, endTime, limit, offset)
+}
+
+// GetCommand returns one command of a device, or ErrCommandNotFound
+func (s *Service) GetCommand(deviceID string, commandID int64) (*models.DeviceCommand, error) {
+	cmd, err := s.commandRepo.GetByID(commandID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && cmd.DeviceID != deviceID) {
+		return nil, fmt.Errorf("%w: %d", ErrCommandNotFound, commandID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get command: %w", err)
+	}
+	return cmd, nil
+}
+
+// CancelCommand cancels a command of a device that has not started executing
+func (s *Service) CancelCommand(deviceID string, commandID int64, cancelledBy string) (*models.DeviceCommand, error) {
+	cmd, err := s.GetCommand(deviceID, commandID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := "Cancelled by " + cancelledBy
+	if err := s.transitionCommand(commandID, "cancelled", &result); err != nil {
+		return nil, err
+	}
+
+	s.logRepo.Create(&models.DeviceLog{
+		DeviceID:  deviceID,
+		Timestamp: time.Now(),
+		Level:     "INFO",
+		Message:   fmt.Sprintf("Command %d (%s) cancelled by %s", commandID, cmd.CommandType, cancelledBy),
+	})
+	return s.commandRepo.GetByID(commandID)
+}
+
+// SweepCommands expires commands stuck in pending or executing and queues
+// delivered commands the device did not confirm for redelivery, until
+// their retries run out. Delivered irrigate commands are never redelivered:
+// the device may have started the pump, so they expire and are flagged in
+// the device log for the operator.
+func (s *Service) SweepCommands() (string, error) {
+	requeued, expired, err := s.sweepCommands()
+	summary := fmt.Sprintf("%d commands requeued, %d expired", requeued, expired)
+	if err != nil {
+		return summary, fmt.Errorf("%s; %w", summary, err)
+	}
+	return summary, nil
+}
+
+// sweepCommands does the work of SweepCommands and returns the counts
+func (s *Service) sweepCommands() (requeued, expired int, err error) {
+	now := time.Now()
+	cfg := s.cfg.Commands
+	var failures []string
+
+	expire := func(cmd *models.DeviceCommand, reason, level string) {
+		if err := s.transitionCommand(cmd.ID, "expired", &reason); err != nil {
+			// 扫描期间设备已上报状态
+			if !errors.Is(err, ErrInvalidTransition) {
+				failures = append(failures, fmt.Sprintf("command %d: %v", cmd.ID, err))
+			}
+			return
+		}
+		expired++
+		s.logRepo.Create(&models.DeviceLog{
+			DeviceID:  cmd.DeviceID,
+			Timestamp: now,
+			Level:     level,
+			Message:   fmt.Sprintf("Command %d (%s) expired: %s", cmd.ID, cmd.CommandType, reason),
+		})
+	}
+
+	pending, err := s.commandRepo.GetStale("pending", now.Add(-cfg.PendingTTL))
+	if err != nil {
+		return requeued, expired, fmt.Errorf("failed to get pending commands: %w", err)
+	}
+	for _, cmd := range pending {
+		expire(cmd, fmt.Sprintf("not picked up by the device within %s", cfg.PendingTTL), "WARN")
+	}
+
+	delivered, err := s.commandRepo.GetStale("delivered", now.Add(-cfg.DeliveryTimeout))
+	if err != nil {
+		return requeued, expired, fmt.Errorf("failed to get delivered commands: %w", err)
+	}
+	for _, cmd := range delivered {
+		// 浇水命令可能已开始执行，重新下发会重复浇水，交由管理员确认
+		if cmd.CommandType == "irrigate" {
+			expire(cmd, "delivered but not confirmed by the device, not redelivered because the pump may already have run; check the device before issuing it again", "ERROR")
+			continue
+		}
+		// 首次下发之外最多重新下发 MaxRetries 次
+		if cmd.Attempts > cfg.MaxRetries {
+			expire(cmd, fmt.Sprintf("not confirmed by the device after %d deliveries", cmd.Attempts), "WARN")
+			continue
+		}
+		if err := s.transitionCommand(cmd.ID, "pending", nil); err != nil {
+			if !errors.Is(err, ErrInvalidTransition) {
+				failures = append(failures, fmt.Sprintf("command %d: %v", cmd.ID, err))
+			}
+			continue
+		}
+		requeued++
+	}
+
+	executing, err := s.commandRepo.GetStale("executing", now.Add(-cfg.ExecutingTTL))
+	if err != nil {
+		return requeued, expired, fmt.Errorf("failed to get executing commands: %w", err)
+	}
+	for _, cmd := range executing {
+		expire(cmd, fmt.Sprintf("no result reported within %s", cfg.ExecutingTTL), "WARN")
+	}
+
+	if len(failures) > 0 {
+		return requeued, expired, fmt.Errorf("failures: %s", strings.Join(failures, "; "))
+	}
+	return requeued, expired, nil
+}
+
+// StartCommandSweeper runs SweepCommands every configured sweep interval
+// until the returned stop function is called
+func (s *Service) StartCommandSweeper() func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.cfg.Commands.SweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				requeued, expired, err := s.sweepCommands()
+				if err != nil {
+					log.Printf("[Commands] Sweep failed: %v", err)
+				}
+				if requeued > 0 || expired > 0 {
+					log.Printf("[Commands] %d commands requeued, %d expired", requeued, expired)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
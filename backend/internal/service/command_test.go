@@ -0,0 +1,237 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+var commandStatuses = []string{"pending", "delivered", "executing", "completed", "failed", "expired", "cancelled"}
+
+// setCommandState overwrites a command's status, delivery count and last
+// update time
+func setCommandState(t *testing.T, s *Service, commandID int64, status string, attempts int, updatedAt time.Time) {
+	t.Helper()
+	if _, err := s.db.Exec(
+		`UPDATE device_commands SET status = ?, attempts = ?, updated_at = ? WHERE id = ?`,
+		status, attempts, updatedAt.Format(time.RFC3339), commandID,
+	); err != nil {
+		t.Fatalf("set command state: %v", err)
+	}
+}
+
+func commandStatus(t *testing.T, s *Service, commandID int64) *models.DeviceCommand {
+	t.Helper()
+	cmd, err := s.commandRepo.GetByID(commandID)
+	if err != nil {
+		t.Fatalf("get command: %v", err)
+	}
+	return cmd
+}
+
+func TestCommandTransitions(t *testing.T) {
+	allowed := map[string]bool{
+		"pending→delivered":   true,
+		"pending→expired":     true,
+		"pending→cancelled":   true,
+		"delivered→pending":   true, // 重新下发
+		"delivered→executing": true,
+		"delivered→completed": true, // executing 上报丢失
+		"delivered→failed":    true,
+		"delivered→expired":   true,
+		"delivered→cancelled": true,
+		"executing→completed": true,
+		"executing→failed":    true,
+		"executing→expired":   true,
+	}
+
+	s := newTestService(t)
+	registerDevice(t, s, "dev1")
+	cmd, err := s.queueCommand("dev1", "set_shade", map[string]string{"mode": "open"}, "admin")
+	if err != nil {
+		t.Fatalf("queue command: %v", err)
+	}
+
+	for _, from := range commandStatuses {
+		for _, to := range commandStatuses {
+			if from == to {
+				continue
+			}
+			name := from + "→" + to
+			t.Run(name, func(t *testing.T) {
+				setCommandState(t, s, cmd.ID, from, 1, time.Now())
+				err := s.transitionCommand(cmd.ID, to, nil)
+				if allowed[name] {
+					if err != nil {
+						t.Fatalf("transition rejected: %v", err)
+					}
+					if got := commandStatus(t, s, cmd.ID).Status; got != to {
+						t.Errorf("status %s, want %s", got, to)
+					}
+					return
+				}
+				if !errors.Is(err, ErrInvalidTransition) {
+					t.Fatalf("got %v, want ErrInvalidTransition", err)
+				}
+				if got := commandStatus(t, s, cmd.ID).Status; got != from {
+					t.Errorf("status changed to %s", got)
+				}
+			})
+		}
+	}
+
+	if err := s.transitionCommand(cmd.ID+100, "expired", nil); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("unknown command: got %v, want ErrCommandNotFound", err)
+	}
+}
+
+func TestUpdateCommandStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   string // 上报前的状态
+		attempts int
+		report   string
+		device   string
+		want     string // 上报后的状态
+		wantErr  error
+	}{
+		{name: "executing after delivery", status: "delivered", attempts: 1, report: "executing", want: "executing"},
+		{name: "result after executing", status: "executing", attempts: 1, report: "completed", want: "completed"},
+		{name: "result without executing report", status: "delivered", attempts: 1, report: "completed", want: "completed"},
+		{name: "failure without executing report", status: "delivered", attempts: 1, report: "failed", want: "failed"},
+		{name: "result for requeued command", status: "pending", attempts: 1, report: "completed", want: "completed"},
+		{name: "never delivered", status: "pending", attempts: 0, report: "completed", want: "pending", wantErr: ErrInvalidTransition},
+		{name: "result after expiry", status: "expired", attempts: 1, report: "completed", want: "expired", wantErr: ErrInvalidTransition},
+		{name: "executing after result", status: "completed", attempts: 1, report: "executing", want: "completed", wantErr: ErrInvalidTransition},
+		{name: "other device", status: "delivered", attempts: 1, report: "completed", device: "dev2", want: "delivered", wantErr: ErrCommandDeviceMismatch},
+	}
+
+	s := newTestService(t)
+	registerDevice(t, s, "dev1")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := s.queueCommand("dev1", "set_shade", map[string]string{"mode": "open"}, "admin")
+			if err != nil {
+				t.Fatalf("queue command: %v", err)
+			}
+			setCommandState(t, s, cmd.ID, tt.status, tt.attempts, time.Now())
+
+			device := tt.device
+			if device == "" {
+				device = "dev1"
+			}
+			_, err = s.UpdateCommandStatus(device, &models.CommandExecutionRequest{
+				CommandID:      cmd.ID,
+				Status:         tt.report,
+				IdempotencyKey: cmd.IdempotencyKey,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got := commandStatus(t, s, cmd.ID).Status; got != tt.want {
+				t.Errorf("status %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSweepCommands(t *testing.T) {
+	tests := []struct {
+		name        string
+		commandType string
+		status      string
+		attempts    int
+		age         time.Duration
+		want        string
+		wantLog     string // 设备日志中应出现的级别
+	}{
+		{name: "fresh pending", commandType: "set_shade", status: "pending", age: time.Minute, want: "pending"},
+		{name: "stale pending", commandType: "set_shade", status: "pending", age: time.Hour, want: "expired", wantLog: "WARN"},
+		{name: "fresh delivered", commandType: "set_shade", status: "delivered", attempts: 1, age: time.Minute, want: "delivered"},
+		{name: "unconfirmed delivery is requeued", commandType: "set_shade", status: "delivered", attempts: 1, age: 10 * time.Minute, want: "pending"},
+		{name: "last retry is requeued", commandType: "set_shade", status: "delivered", attempts: 3, age: 10 * time.Minute, want: "pending"},
+		{name: "retries used up", commandType: "set_shade", status: "delivered", attempts: 4, age: 10 * time.Minute, want: "expired", wantLog: "WARN"},
+		{name: "irrigate is never redelivered", commandType: "irrigate", status: "delivered", attempts: 1, age: 10 * time.Minute, want: "expired", wantLog: "ERROR"},
+		{name: "fresh executing", commandType: "irrigate", status: "executing", attempts: 1, age: 10 * time.Minute, want: "executing"},
+		{name: "stale executing", commandType: "irrigate", status: "executing", attempts: 1, age: time.Hour, want: "expired", wantLog: "WARN"},
+		{name: "completed is final", commandType: "irrigate", status: "completed", attempts: 1, age: 24 * time.Hour, want: "completed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t)
+			s.cfg.Commands.PendingTTL = 30 * time.Minute
+			s.cfg.Commands.DeliveryTimeout = 2 * time.Minute
+			s.cfg.Commands.ExecutingTTL = 30 * time.Minute
+			s.cfg.Commands.MaxRetries = 3
+			registerDevice(t, s, "dev1")
+
+			var cmd *models.DeviceCommand
+			var err error
+			if tt.commandType == "irrigate" {
+				cmd, err = s.enqueueIrrigation("dev1", 2, nil, nil, "admin")
+			} else {
+				cmd, err = s.queueCommand("dev1", tt.commandType, map[string]string{"mode": "open"}, "admin")
+			}
+			if err != nil {
+				t.Fatalf("queue command: %v", err)
+			}
+			setCommandState(t, s, cmd.ID, tt.status, tt.attempts, time.Now().Add(-tt.age))
+
+			if _, err := s.SweepCommands(); err != nil {
+				t.Fatalf("SweepCommands: %v", err)
+			}
+			swept := commandStatus(t, s, cmd.ID)
+			if swept.Status != tt.want {
+				t.Errorf("status %s, want %s", swept.Status, tt.want)
+			}
+			if swept.Attempts != tt.attempts {
+				t.Errorf("attempts changed from %d to %d", tt.attempts, swept.Attempts)
+			}
+			if tt.wantLog != "" {
+				found := false
+				for _, msg := range deviceLogs(t, s, "dev1", tt.wantLog) {
+					found = found || strings.Contains(msg, "expired")
+				}
+				if !found {
+					t.Errorf("no %s log for the expired command", tt.wantLog)
+				}
+			}
+		})
+	}
+}
+func TestRedeliveryCountsAttempts(t *testing.T) {
+	s := newTestService(t)
+	s.cfg.Commands.MaxRetries = 1
+	registerDevice(t, s, "dev1")
+	cmd, err := s.queueCommand("dev1", "reboot", map[string]string{}, "admin")
+	if err != nil {
+		t.Fatalf("queue command: %v", err)
+	}
+
+	// 首次下发和一次重新下发，之后置为 expired
+	for attempt := 1; attempt <= 2; attempt++ {
+		delivered, err := s.deliverPendingCommands("dev1")
+		if err != nil {
+			t.Fatalf("deliver: %v", err)
+		}
+		if len(delivered) != 1 || delivered[0].Attempts != attempt {
+			t.Fatalf("delivery %d: got %+v", attempt, delivered)
+		}
+		setCommandState(t, s, cmd.ID, "delivered", attempt, time.Now().Add(-time.Hour))
+		if _, err := s.SweepCommands(); err != nil {
+			t.Fatalf("SweepCommands: %v", err)
+		}
+	}
+
+	swept := commandStatus(t, s, cmd.ID)
+	if swept.Status != "expired" || swept.Attempts != 2 {
+		t.Errorf("got status %s after %d deliveries, want expired after 2", swept.Status, swept.Attempts)
+	}
+	if delivered, _ := s.deliverPendingCommands("dev1"); len(delivered) != 0 {
+		t.Errorf("expired command delivered again")
+	}
+}
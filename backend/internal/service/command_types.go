@@ -0,0 +1,125 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+var (
+	// ErrUnknownCommandType is returned for command types missing from the registry
+	ErrUnknownCommandType = errors.New("unknown command type")
+	// ErrInvalidCommandParams is returned when command parameters fail validation
+	ErrInvalidCommandParams = errors.New("invalid command parameters")
+	// ErrCommandForbidden is returned when the user's role may not issue a command type
+	ErrCommandForbidden = errors.New("not allowed to issue this command")
+)
+
+// commandSpec describes a command type users can issue through IssueCommand
+type commandSpec struct {
+	adminOnly bool                           // 仅管理员可下发
+	validate  func(params interface{}) error // 绑定校验之外的参数检查
+}
+
+// commandSpecs is the registry of command types users can issue. irrigate
+// is created by TriggerIrrigation and the plan executor instead.
+var commandSpecs = map[string]commandSpec{
+	"set_shade": {
+		validate: func(params interface{}) error {
+			if _, ok := params.(*models.SetShadeRequest); !ok {
+				return fmt.Errorf("expected shade parameters")
+			}
+			return nil
+		},
+	},
+	"update_config": {
+		validate: func(params interface{}) error {
+			req, ok := params.(*models.DeviceConfigRequest)
+			if !ok {
+				return fmt.Errorf("expected device config parameters")
+			}
+			if req.ReportIntervalS == nil && req.ShadeOnTemp == nil && req.ShadeOffTemp == nil && req.SoilDryThreshold == nil {
+				return fmt.Errorf("at least one setting is required")
+			}
+			// 关闭阈值需低于开启阈值，避免遮阳反复开关
+			if req.ShadeOnTemp != nil && req.ShadeOffTemp != nil && *req.ShadeOffTemp >= *req.ShadeOnTemp {
+				return fmt.Errorf("shade_off_temp must be below shade_on_temp")
+			}
+			return nil
+		},
+	},
+	"reboot": {
+		adminOnly: true,
+		validate: func(params interface{}) error {
+			if _, ok := params.(*models.RebootRequest); !ok {
+				return fmt.Errorf("expected reboot parameters")
+			}
+			return nil
+		},
+	},
+	"ota_update": {
+		adminOnly: true,
+		validate: func(params interface{}) error {
+			req, ok := params.(*models.OTAUpdateRequest)
+			if !ok {
+				return fmt.Errorf("expected firmware update parameters")
+			}
+			u, err := url.Parse(req.URL)
+			if err != nil || u.Scheme != "https" || u.Host == "" {
+				return fmt.Errorf("firmware url must be an https URL")
+			}
+			return nil
+		},
+	},
+}
+
+// IssueCommand validates and queues a command of a registered type for a
+// device. role is the issuing user's role, checked against the type's
+// authorization rule.
+func (s *Service) IssueCommand(deviceID, commandType string, params interface{}, issuedBy, role string) (*models.DeviceCommand, error) {
+	spec, ok := commandSpecs[commandType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCommandType, commandType)
+	}
+	if spec.adminOnly && role != "admin" {
+		return nil, fmt.Errorf("%w: %s requires admin", ErrCommandForbidden, commandType)
+	}
+	if err := spec.validate(params); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCommandParams, err)
+	}
+	return s.queueCommand(deviceID, commandType, params, issuedBy)
+}
+
+// queueCommand creates a pending command with already validated parameters
+func (s *Service) queueCommand(deviceID, commandType string, params interface{}, issuedBy string) (*models.DeviceCommand, error) {
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode command parameters: %w", err)
+	}
+	paramsStr := string(paramsJSON)
+
+	cmd := &models.DeviceCommand{
+		DeviceID:       deviceID,
+		CommandType:    commandType,
+		Parameters:     &paramsStr,
+		Status:         "pending",
+		CreatedAt:      time.Now(),
+		IdempotencyKey: newIdempotencyKey(),
+		IssuedBy:       issuedBy,
+	}
+	if err := s.commandRepo.Create(cmd); err != nil {
+		return nil, fmt.Errorf("failed to create command: %w", err)
+	}
+
+	s.logRepo.Create(&models.DeviceLog{
+		DeviceID:  deviceID,
+		Timestamp: cmd.CreatedAt,
+		Level:     "INFO",
+		Message:   fmt.Sprintf("Command %d (%s) issued by %s: %s", cmd.ID, commandType, issuedBy, paramsStr),
+	})
+	return cmd, nil
+}
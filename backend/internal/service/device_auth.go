@@ -0,0 +1,347 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"irrigation-system/backend/internal/models"
+)
+
+var (
+	// ErrDeviceNotFound is returned for device IDs that are not registered
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrDeviceKeyExists is returned when provisioning a device that already
+	// has an API key; rotate it instead
+	ErrDeviceKeyExists = errors.New("device already has an API key")
+	// ErrDeviceKeyMissing is returned when rotating or revoking the key of a
+	// device that has none
+	ErrDeviceKeyMissing = errors.New("device has no API key")
+)
+
+// newDeviceAPIKey returns a random device API key
+func newDeviceAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate device API key: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashDeviceAPIKey hashes a device API key for storage. The keys are 256
+// random bits, so a fast hash suffices where passwords need bcrypt, and
+// devices can be checked on every request.
+func hashDeviceAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// deviceKeyMatches compares a key against a stored hash in constant time
+func deviceKeyMatches(key, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashDeviceAPIKey(key)), []byte(hash)) == 1
+}
+
+// deviceSigningInfo is the HKDF info string of the request signing secret
+const deviceSigningInfo = "irrigation-device-signing"
+
+// deviceSigningSecret derives the secret a device signs requests with from
+// its API key: HKDF-SHA256 without salt, info deviceSigningInfo, 32 bytes.
+// It cannot be computed from the stored key hash.
+func deviceSigningSecret(key string) []byte {
+	secret := make([]byte, 32)
+	io.ReadFull(hkdf.New(sha256.New, []byte(key), nil, []byte(deviceSigningInfo)), secret)
+	return secret
+}
+
+// deviceSecretCipher returns the AES-GCM cipher the signing secrets are
+// stored with. Its key is derived from the configured device secret key, or
+// the JWT secret, so the database alone does not allow signing requests.
+func (s *Service) deviceSecretCipher() (cipher.AEAD, error) {
+	secret := s.cfg.Security.DeviceSecretKey
+	if secret == "" {
+		secret = s.cfg.Security.JWTSecret
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(secret), nil, []byte("irrigation-device-secret-storage")), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealSigningSecret encrypts a signing secret for storage as hex(nonce ‖ ciphertext)
+func (s *Service) sealSigningSecret(deviceID string, secret []byte) (string, error) {
+	aead, err := s.deviceSecretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	// 设备ID作为附加数据，密文不能挪用到其他设备
+	return hex.EncodeToString(aead.Seal(nonce, nonce, secret, []byte(deviceID))), nil
+}
+
+// openSigningSecret decrypts a stored signing secret of deviceID
+func (s *Service) openSigningSecret(deviceID, sealed string) ([]byte, error) {
+	aead, err := s.deviceSecretCipher()
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("malformed signing secret")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(deviceID))
+}
+
+// GetDeviceCredentials returns the state of a device's API key
+func (s *Service) GetDeviceCredentials(deviceID string) (*models.DeviceCredentials, error) {
+	creds, err := s.deviceRepo.GetCredentials(deviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, deviceID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device credentials: %w", err)
+	}
+	return creds, nil
+}
+
+// ProvisionDeviceKey issues the first API key of a device, or a new one
+// after revocation. The key is returned only here; from then on the device
+// no longer authenticates with the shared key.
+func (s *Service) ProvisionDeviceKey(deviceID, issuedBy string) (string, *models.DeviceCredentials, error) {
+	creds, err := s.GetDeviceCredentials(deviceID)
+	if err != nil {
+		return "", nil, err
+	}
+	if creds.Provisioned {
+		return "", nil, fmt.Errorf("%w: %s", ErrDeviceKeyExists, deviceID)
+	}
+
+	return s.issueDeviceKey(deviceID, nil, fmt.Sprintf("API key provisioned by %s", issuedBy))
+}
+
+// RotateDeviceKey issues a new API key for a device. The old key keeps
+// working for the grace period so the device can be updated; nil uses the
+// configured grace period and zero retires the old key immediately.
+func (s *Service) RotateDeviceKey(deviceID string, grace *time.Duration, rotatedBy string) (string, *models.DeviceCredentials, error) {
+	creds, err := s.GetDeviceCredentials(deviceID)
+	if err != nil {
+		return "", nil, err
+	}
+	if !creds.Provisioned {
+		return "", nil, fmt.Errorf("%w: %s", ErrDeviceKeyMissing, deviceID)
+	}
+
+	period := s.cfg.Security.DeviceKeyGracePeriod
+	if grace != nil {
+		period = *grace
+	}
+	if period <= 0 {
+		return s.issueDeviceKey(deviceID, nil, fmt.Sprintf("API key rotated by %s, old key retired", rotatedBy))
+	}
+	expiresAt := time.Now().Add(period)
+	return s.issueDeviceKey(deviceID, &previousDeviceKey{creds.KeyHash, creds.SigningSecret, expiresAt},
+		fmt.Sprintf("API key rotated by %s, old key valid until %s", rotatedBy, expiresAt.Format(time.RFC3339)))
+}
+
+// previousDeviceKey is the key a rotation keeps valid for the grace period
+type previousDeviceKey struct {
+	keyHash       *string
+	signingSecret *string
+	expiresAt     time.Time
+}
+
+// issueDeviceKey stores a new key for a device and logs the change. previous
+// is the key that stays valid for the grace period, nil retires it.
+func (s *Service) issueDeviceKey(deviceID string, previous *previousDeviceKey, message string) (string, *models.DeviceCredentials, error) {
+	key, err := newDeviceAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+	signingSecret, err := s.sealSigningSecret(deviceID, deviceSigningSecret(key))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encrypt signing secret: %w", err)
+	}
+
+	var previousKeyHash, previousSigningSecret *string
+	var previousExpiresAt *time.Time
+	if previous != nil {
+		previousKeyHash, previousSigningSecret, previousExpiresAt = previous.keyHash, previous.signingSecret, &previous.expiresAt
+	}
+	now := time.Now()
+	if err := s.deviceRepo.SetAPIKey(deviceID, hashDeviceAPIKey(key), signingSecret, previousKeyHash, previousSigningSecret, previousExpiresAt, now); err != nil {
+		return "", nil, fmt.Errorf("failed to save device API key: %w", err)
+	}
+
+	s.logRepo.Create(&models.DeviceLog{
+		DeviceID:  deviceID,
+		Timestamp: now,
+		Level:     "INFO",
+		Message:   message,
+	})
+
+	creds, err := s.GetDeviceCredentials(deviceID)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, creds, nil
+}
+
+// RevokeDeviceKey revokes a device's current and previous API keys. The
+// device cannot authenticate until a new key is provisioned.
+func (s *Service) RevokeDeviceKey(deviceID, revokedBy string) (*models.DeviceCredentials, error) {
+	creds, err := s.GetDeviceCredentials(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if !creds.Provisioned {
+		return nil, fmt.Errorf("%w: %s", ErrDeviceKeyMissing, deviceID)
+	}
+
+	now := time.Now()
+	if err := s.deviceRepo.RevokeAPIKey(deviceID, now); err != nil {
+		return nil, fmt.Errorf("failed to revoke device API key: %w", err)
+	}
+
+	s.logRepo.Create(&models.DeviceLog{
+		DeviceID:  deviceID,
+		Timestamp: now,
+		Level:     "WARN",
+		Message:   fmt.Sprintf("API key revoked by %s", revokedBy),
+	})
+	return s.GetDeviceCredentials(deviceID)
+}
+
+// AuthenticateDevice reports whether apiKey authenticates deviceID
+func (s *Service) AuthenticateDevice(deviceID, apiKey string) (bool, error) {
+	if apiKey == "" {
+		return false, nil
+	}
+	hashes, shared, err := s.deviceKeyHashes(deviceID)
+	if err != nil {
+		return false, err
+	}
+	for _, hash := range hashes {
+		if deviceKeyMatches(apiKey, hash) {
+			if shared {
+				log.Printf("[DeviceAuth] WARNING: device %s authenticated with the shared device key, provision its own key", deviceID)
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DeviceSigningKeys returns the secrets deviceID may sign requests with,
+// see deviceSigningSecret. Keys provisioned before signing secrets were
+// stored have none and must be rotated before the device can sign. Devices
+// that were never provisioned sign with the shared key, see sharedKeyAllowed.
+func (s *Service) DeviceSigningKeys(deviceID string) ([][]byte, error) {
+	creds, ownKeys, err := s.deviceCredentials(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if !ownKeys {
+		if !s.sharedKeyAllowed(creds) {
+			return nil, nil
+		}
+		log.Printf("[DeviceAuth] WARNING: device %s signs with the shared device key, provision its own key", deviceID)
+		return [][]byte{deviceSigningSecret(s.cfg.Security.DeviceAPIKey)}, nil
+	}
+
+	var sealed []string
+	if creds.KeyHash != nil && creds.SigningSecret != nil {
+		sealed = append(sealed, *creds.SigningSecret)
+	}
+	if previousKeyValid(creds) && creds.PreviousSigningSecret != nil {
+		sealed = append(sealed, *creds.PreviousSigningSecret)
+	}
+	var keys [][]byte
+	for _, secret := range sealed {
+		key, err := s.openSigningSecret(deviceID, secret)
+		if err != nil {
+			// 服务器密钥变更后旧的签名密钥无法解密，需轮换设备密钥
+			log.Printf("[DeviceAuth] Cannot decrypt signing secret of %s: %v", deviceID, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// RecordDeviceNonce records the nonce of a signed request from deviceID until
+// expiresAt. It returns false for a replayed nonce. Nonces are kept in the
+// database so replays are still rejected after a restart.
+func (s *Service) RecordDeviceNonce(deviceID, nonce string, expiresAt time.Time) (bool, error) {
+	return s.deviceRepo.RecordNonce(deviceID, nonce, expiresAt, time.Now())
+}
+
+// deviceKeyHashes returns the hashes of the keys valid for deviceID: the
+// device's own key and its previous key within the grace period, or the
+// shared key for registered devices that were never provisioned, see
+// sharedKeyAllowed. shared reports the latter.
+func (s *Service) deviceKeyHashes(deviceID string) (hashes []string, shared bool, err error) {
+	creds, ownKeys, err := s.deviceCredentials(deviceID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if ownKeys {
+		if creds.KeyHash != nil {
+			hashes = append(hashes, *creds.KeyHash)
+		}
+		if previousKeyValid(creds) {
+			hashes = append(hashes, *creds.PreviousKeyHash)
+		}
+		return hashes, false, nil
+	}
+
+	if !s.sharedKeyAllowed(creds) {
+		return nil, false, nil
+	}
+	return []string{hashDeviceAPIKey(s.cfg.Security.DeviceAPIKey)}, true, nil
+}
+
+// sharedKeyAllowed reports whether a device without its own keys may use the
+// shared key: only registered devices can, and only while the shared key is
+// configured and enabled. creds is nil for unregistered devices.
+func (s *Service) sharedKeyAllowed(creds *models.DeviceCredentials) bool {
+	return creds != nil && s.cfg.Security.SharedDeviceKey && s.cfg.Security.DeviceAPIKey != ""
+}
+
+// deviceCredentials loads a device's credentials and reports whether it
+// uses its own keys rather than the shared key, which is the case once a key
+// was provisioned, even if it was revoked since
+func (s *Service) deviceCredentials(deviceID string) (*models.DeviceCredentials, bool, error) {
+	creds, err := s.deviceRepo.GetCredentials(deviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get device credentials: %w", err)
+	}
+	return creds, creds.Provisioned || creds.RevokedAt != nil, nil
+}
+
+// previousKeyValid reports whether the key replaced by the last rotation is
+// still within its grace period
+func previousKeyValid(creds *models.DeviceCredentials) bool {
+	return creds.PreviousKeyHash != nil && creds.PreviousKeyExpiresAt != nil && time.Now().Before(*creds.PreviousKeyExpiresAt)
+}
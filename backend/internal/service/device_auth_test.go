@@ -0,0 +1,66 @@
+package service
+
+import "testing"
+
+func TestAuthenticateDeviceSharedKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		device    string
+		provision bool
+		enabled   bool
+		want      bool
+	}{
+		{name: "registered device", device: "dev1", enabled: true, want: true},
+		{name: "unregistered device", device: "ghost", enabled: true, want: false},
+		{name: "provisioned device", device: "dev1", provision: true, enabled: true, want: false},
+		{name: "shared key disabled", device: "dev1", enabled: false, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t)
+			s.cfg.Security.SharedDeviceKey = tt.enabled
+			registerDevice(t, s, "dev1")
+			var ownKey string
+			if tt.provision {
+				key, _, err := s.ProvisionDeviceKey("dev1", "admin")
+				if err != nil {
+					t.Fatalf("provision: %v", err)
+				}
+				ownKey = key
+			}
+
+			ok, err := s.AuthenticateDevice(tt.device, s.cfg.Security.DeviceAPIKey)
+			if err != nil {
+				t.Fatalf("AuthenticateDevice: %v", err)
+			}
+			if ok != tt.want {
+				t.Errorf("shared key accepted: %v, want %v", ok, tt.want)
+			}
+			keys, err := s.DeviceSigningKeys(tt.device)
+			if err != nil {
+				t.Fatalf("DeviceSigningKeys: %v", err)
+			}
+			if signs := len(keys) == 1 && string(keys[0]) == string(deviceSigningSecret(s.cfg.Security.DeviceAPIKey)); signs != tt.want {
+				t.Errorf("shared signing secret returned: %v, want %v", signs, tt.want)
+			}
+
+			if ownKey != "" {
+				if ok, err := s.AuthenticateDevice(tt.device, ownKey); err != nil || !ok {
+					t.Errorf("own key rejected: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewDeviceAPIKey(t *testing.T) {
+	a, err := newDeviceAPIKey()
+	if err != nil {
+		t.Fatalf("newDeviceAPIKey: %v", err)
+	}
+	b, _ := newDeviceAPIKey()
+	if len(a) != 64 || a == b {
+		t.Errorf("got keys %q and %q", a, b)
+	}
+}
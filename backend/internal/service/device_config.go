@@ -0,0 +1,207 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+// GetDeviceTwin returns the desired and reported settings of a device and
+// the desired settings the device has not applied
+func (s *Service) GetDeviceTwin(deviceID string) (*models.DeviceTwin, error) {
+	twin, err := s.twinRepo.Get(deviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		twin = &models.DeviceTwin{DeviceID: deviceID}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get device config: %w", err)
+	}
+
+	twin.Drift, err = settingsDrift(&twin.Desired, twin.Reported)
+	if err != nil {
+		return nil, err
+	}
+	twin.Converged = len(twin.Drift) == 0
+	return twin, nil
+}
+
+// UpdateDesiredConfig changes the given desired settings of a device and
+// queues an update_config command when the device's reported settings
+// differ. Settings pushed with a plain update_config command are reverted
+// to the desired ones on the device's next report.
+func (s *Service) UpdateDesiredConfig(deviceID string, req *models.DeviceConfigRequest, updatedBy string) (*models.DeviceTwin, *models.DeviceCommand, error) {
+	validate := commandSpecs["update_config"].validate
+	if err := validate(req); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCommandParams, err)
+	}
+
+	twin, err := s.GetDeviceTwin(deviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	desired := twin.Desired
+	if req.ReportIntervalS != nil {
+		desired.ReportIntervalS = req.ReportIntervalS
+	}
+	if req.ShadeOnTemp != nil {
+		desired.ShadeOnTemp = req.ShadeOnTemp
+	}
+	if req.ShadeOffTemp != nil {
+		desired.ShadeOffTemp = req.ShadeOffTemp
+	}
+	if req.SoilDryThreshold != nil {
+		desired.SoilDryThreshold = req.SoilDryThreshold
+	}
+	// 与已有期望配置合并后再检查遮阳阈值顺序
+	merged := models.DeviceConfigRequest(desired)
+	if err := validate(&merged); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCommandParams, err)
+	}
+
+	now := time.Now()
+	if err := s.twinRepo.SetDesired(deviceID, &desired, updatedBy, now); err != nil {
+		return nil, nil, fmt.Errorf("failed to save desired config: %w", err)
+	}
+	desiredJSON, _ := json.Marshal(desired)
+	s.logRepo.Create(&models.DeviceLog{
+		DeviceID:  deviceID,
+		Timestamp: now,
+		Level:     "INFO",
+		Message:   fmt.Sprintf("Desired config updated by %s: %s", updatedBy, desiredJSON),
+	})
+
+	cmd, err := s.syncDeviceConfig(deviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	twin, err = s.GetDeviceTwin(deviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return twin, cmd, nil
+}
+
+// reportDeviceConfig stores the settings a device reported and queues an
+// update_config command when they differ from the desired ones
+func (s *Service) reportDeviceConfig(deviceID string, settings *models.DeviceSettings) error {
+	if err := s.twinRepo.SetReported(deviceID, settings, time.Now()); err != nil {
+		return fmt.Errorf("failed to save reported config: %w", err)
+	}
+	_, err := s.syncDeviceConfig(deviceID)
+	return err
+}
+
+// syncDeviceConfig queues an update_config command with the drifted
+// settings of a device. Nothing is queued while the previous sync command
+// is still active, or was created less than the sync interval ago and the
+// desired settings have not changed since. Devices that never reported
+// their settings are not synced, as their firmware cannot confirm them.
+func (s *Service) syncDeviceConfig(deviceID string) (*models.DeviceCommand, error) {
+	twin, err := s.GetDeviceTwin(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if twin.Reported == nil || twin.Converged {
+		return nil, nil
+	}
+
+	if twin.LastCommandID != nil {
+		last, err := s.commandRepo.GetByID(*twin.LastCommandID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to get last config command: %w", err)
+		}
+		if err == nil {
+			switch last.Status {
+			case "pending", "delivered", "executing":
+				return nil, nil
+			}
+			desiredChanged := twin.DesiredUpdatedAt != nil && twin.DesiredUpdatedAt.After(last.CreatedAt)
+			if !desiredChanged && time.Since(last.CreatedAt) < s.cfg.Commands.ConfigSyncInterval {
+				return nil, nil
+			}
+		}
+	}
+
+	params, err := driftedSettings(twin.Drift)
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := s.queueCommand(deviceID, "update_config", params, "device_twin")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.twinRepo.SetLastCommand(deviceID, cmd.ID); err != nil {
+		return nil, fmt.Errorf("failed to record config command: %w", err)
+	}
+	return cmd, nil
+}
+
+// settingsDrift lists the desired settings that the reported settings lack
+// or differ in
+func settingsDrift(desired, reported *models.DeviceSettings) ([]models.SettingDrift, error) {
+	desiredFields, err := settingsFields(desired)
+	if err != nil {
+		return nil, err
+	}
+	reportedFields, err := settingsFields(reported)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(desiredFields))
+	for name := range desiredFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	drift := []models.SettingDrift{}
+	for _, name := range names {
+		want, _ := desiredFields[name].(float64)
+		got, ok := reportedFields[name].(float64)
+		// 设备上报的浮点数可能有舍入误差
+		if ok && math.Abs(want-got) < 0.01 {
+			continue
+		}
+		drift = append(drift, models.SettingDrift{Setting: name, Desired: desiredFields[name], Reported: reportedFields[name]})
+	}
+	return drift, nil
+}
+
+// settingsFields returns the set settings by JSON field name
+func settingsFields(settings *models.DeviceSettings) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if settings == nil {
+		return fields, nil
+	}
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// driftedSettings returns the update_config parameters for the drifted
+// desired settings
+func driftedSettings(drift []models.SettingDrift) (*models.DeviceConfigRequest, error) {
+	fields := make(map[string]interface{}, len(drift))
+	for _, d := range drift {
+		fields[d.Setting] = d.Desired
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var params models.DeviceConfigRequest
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	return &params, nil
+}
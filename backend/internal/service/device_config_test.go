@@ -0,0 +1,42 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+func TestDesiredSoilDryThreshold(t *testing.T) {
+	s := newTestService(t)
+	registerDevice(t, s, "dev1")
+	reportInterval, threshold := 10, 1500
+
+	// 设备上报当前配置后才会同步
+	report := dataRequest("dev1", 2000, time.Now())
+	report.Config = &models.DeviceSettings{ReportIntervalS: &reportInterval, SoilDryThreshold: &threshold}
+	if _, err := s.HandleDeviceData("dev1", "10.0.0.1", report); err != nil {
+		t.Fatalf("HandleDeviceData: %v", err)
+	}
+
+	desired := 1800
+	twin, cmd, err := s.UpdateDesiredConfig("dev1", &models.DeviceConfigRequest{SoilDryThreshold: &desired}, "admin")
+	if err != nil {
+		t.Fatalf("UpdateDesiredConfig: %v", err)
+	}
+	if twin.Desired.SoilDryThreshold == nil || *twin.Desired.SoilDryThreshold != desired || twin.Converged {
+		t.Fatalf("unexpected twin %+v", twin)
+	}
+	if cmd == nil || cmd.Parameters == nil || *cmd.Parameters != `{"soil_dry_threshold":1800}` {
+		t.Fatalf("unexpected config command %+v", cmd)
+	}
+
+	report.Config = &models.DeviceSettings{ReportIntervalS: &reportInterval, SoilDryThreshold: &desired}
+	if _, err := s.HandleDeviceData("dev1", "10.0.0.1", report); err != nil {
+		t.Fatalf("HandleDeviceData: %v", err)
+	}
+	if twin, _ := s.GetDeviceTwin("dev1"); !twin.Converged {
+		t.Errorf("twin not converged: %+v", twin.Drift)
+	}
+
+}
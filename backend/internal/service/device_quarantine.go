@@ -0,0 +1,211 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+var (
+	// ErrDeviceIDMismatch is returned when a device uploads data under
+	// another device's ID
+	ErrDeviceIDMismatch = errors.New("payload device_id does not match authenticated device")
+	// ErrInvalidDeviceData is returned for data uploads that cannot be parsed
+	ErrInvalidDeviceData = errors.New("invalid device data")
+	// ErrDeviceQuarantined is returned when an upload from an unregistered
+	// device was quarantined for review
+	ErrDeviceQuarantined = errors.New("device is not registered, data quarantined")
+	// ErrUnknownDevice is returned when uploads from unregistered devices are
+	// rejected
+	ErrUnknownDevice = errors.New("device is not registered")
+	// ErrQuarantineFull is returned when an upload from a device not yet in
+	// quarantine arrives while the quarantine holds its maximum of devices
+	ErrQuarantineFull = errors.New("quarantine is full")
+	// ErrQuarantineRateLimited is returned when a source sends too many
+	// uploads from unregistered devices
+	ErrQuarantineRateLimited = errors.New("too many uploads from unregistered devices")
+	// ErrNothingQuarantined is returned for devices without quarantined uploads
+	ErrNothingQuarantined = errors.New("no quarantined data for device")
+	// ErrInvalidClaim is returned when a quarantined device cannot be claimed
+	// with the given request
+	ErrInvalidClaim = errors.New("invalid device claim")
+)
+
+// quarantineDeviceData stores an upload from an unregistered device for
+// review, or rejects it if quarantine is disabled. Each source (client IP)
+// may quarantine at most Security.QuarantineRate uploads per minute.
+func (s *Service) quarantineDeviceData(source string, req *models.DeviceDataRequest) error {
+	if s.cfg.Security.UnknownDevices == "reject" {
+		return fmt.Errorf("%w: %s", ErrUnknownDevice, req.DeviceID)
+	}
+	if !s.quarantineRate.allow(source, time.Now()) {
+		return fmt.Errorf("%w: source %s", ErrQuarantineRateLimited, source)
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode device data: %w", err)
+	}
+	entry := &models.QuarantinedData{
+		DeviceID:   req.DeviceID,
+		Payload:    string(payload),
+		ReceivedAt: time.Now(),
+	}
+	stored, err := s.quarantineRepo.Create(entry, s.cfg.Security.QuarantineLimit, s.cfg.Security.QuarantineMaxDevices)
+	if err != nil {
+		return fmt.Errorf("failed to quarantine device data: %w", err)
+	}
+	if !stored {
+		return fmt.Errorf("%w: %d devices quarantined, rejecting %s", ErrQuarantineFull, s.cfg.Security.QuarantineMaxDevices, req.DeviceID)
+	}
+	return fmt.Errorf("%w: %s", ErrDeviceQuarantined, req.DeviceID)
+}
+
+// sourceLimiter counts requests per source in one-minute windows
+type sourceLimiter struct {
+	mu        sync.Mutex
+	rate      int // 每分钟允许的次数
+	windows   map[string]*sourceWindow
+	nextPrune time.Time
+}
+
+type sourceWindow struct {
+	count   int
+	resetAt time.Time
+}
+
+func newSourceLimiter(requestsPerMinute int) *sourceLimiter {
+	return &sourceLimiter{
+		rate:    requestsPerMinute,
+		windows: make(map[string]*sourceWindow),
+	}
+}
+
+// allow counts a request from source and reports whether it is within the
+// rate
+func (l *sourceLimiter) allow(source string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// 每分钟清理一次已结束的窗口
+	if now.After(l.nextPrune) {
+		for key, w := range l.windows {
+			if now.After(w.resetAt) {
+				delete(l.windows, key)
+			}
+		}
+		l.nextPrune = now.Add(time.Minute)
+	}
+
+	w, ok := l.windows[source]
+	if !ok || now.After(w.resetAt) {
+		l.windows[source] = &sourceWindow{count: 1, resetAt: now.Add(time.Minute)}
+		return true
+	}
+	if w.count >= l.rate {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// GetQuarantinedDevices lists the unregistered devices that uploaded data
+func (s *Service) GetQuarantinedDevices() ([]models.QuarantinedDevice, error) {
+	return s.quarantineRepo.GetDevices()
+}
+
+// GetQuarantinedData lists a device's quarantined uploads, newest first
+func (s *Service) GetQuarantinedData(deviceID string, limit, offset int) ([]*models.QuarantinedData, int, error) {
+	return s.quarantineRepo.Query(deviceID, limit, offset)
+}
+
+// ClaimQuarantinedDevice registers a quarantined device under a new user
+// account, like CreateUser, and imports its quarantined readings into the
+// sensor data unless req.DiscardData is set. A device registered since its
+// uploads were quarantined only has its readings imported. The new user is
+// nil in that case.
+func (s *Service) ClaimQuarantinedDevice(deviceID string, req *models.ClaimDeviceRequest, claimedBy string) (*models.UserWithDevice, int, error) {
+	entries, total, err := s.quarantineRepo.Query(deviceID, 0, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get quarantined data: %w", err)
+	}
+	if total == 0 {
+		return nil, 0, fmt.Errorf("%w: %s", ErrNothingQuarantined, deviceID)
+	}
+
+	registered, err := s.deviceRepo.Exists(deviceID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to check device: %w", err)
+	}
+
+	var user *models.UserWithDevice
+	if registered {
+		if req.Username != "" {
+			return nil, 0, fmt.Errorf("%w: device %s is already registered", ErrInvalidClaim, deviceID)
+		}
+	} else {
+		if req.Username == "" || req.Password == "" {
+			return nil, 0, fmt.Errorf("%w: username and password are required to register device %s", ErrInvalidClaim, deviceID)
+		}
+		deviceName := req.DeviceName
+		if deviceName == "" {
+			deviceName = deviceID
+		}
+		user, err = s.CreateUser(&models.CreateUserRequest{
+			Username:   req.Username,
+			Password:   req.Password,
+			DeviceID:   deviceID,
+			DeviceName: deviceName,
+		})
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %v", ErrInvalidClaim, err)
+		}
+	}
+
+	imported := 0
+	if !req.DiscardData {
+		// 按接收顺序导入
+		for i := len(entries) - 1; i >= 0; i-- {
+			var data models.DeviceDataRequest
+			if err := json.Unmarshal([]byte(entries[i].Payload), &data); err != nil {
+				continue
+			}
+			sensorData, err := sensorDataFromRequest(&data)
+			if err != nil {
+				continue
+			}
+			if err := s.sensorDataRepo.Create(sensorData); err != nil {
+				return user, imported, fmt.Errorf("failed to import quarantined data: %w", err)
+			}
+			imported++
+		}
+	}
+
+	if _, err := s.quarantineRepo.DeleteByDevice(deviceID); err != nil {
+		return user, imported, fmt.Errorf("failed to clear quarantined data: %w", err)
+	}
+
+	s.logRepo.Create(&models.DeviceLog{
+		DeviceID:  deviceID,
+		Timestamp: time.Now(),
+		Level:     "INFO",
+		Message:   fmt.Sprintf("Device claimed from quarantine by %s, %d of %d uploads imported", claimedBy, imported, total),
+	})
+	return user, imported, nil
+}
+
+// DiscardQuarantinedDevice deletes a device's quarantined uploads
+func (s *Service) DiscardQuarantinedDevice(deviceID string) (int64, error) {
+	deleted, err := s.quarantineRepo.DeleteByDevice(deviceID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to discard quarantined data: %w", err)
+	}
+	if deleted == 0 {
+		return 0, fmt.Errorf("%w: %s", ErrNothingQuarantined, deviceID)
+	}
+	return deleted, nil
+}
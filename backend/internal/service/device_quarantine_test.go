@@ -0,0 +1,229 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+func TestHandleDeviceDataRejectsDeviceIDMismatch(t *testing.T) {
+	s := newTestService(t)
+	registerDevice(t, s, "dev1")
+	registerDevice(t, s, "dev2")
+
+	_, err := s.HandleDeviceData("dev1", "10.0.0.1", dataRequest("dev2", 2000, time.Now()))
+	if !errors.Is(err, ErrDeviceIDMismatch) {
+		t.Fatalf("got %v, want ErrDeviceIDMismatch", err)
+	}
+	if _, total, _ := s.GetDeviceHistory("dev2", nil, nil, 10, 0); total != 0 {
+		t.Errorf("%d readings stored for dev2", total)
+	}
+	if logs := deviceLogs(t, s, "dev1", "WARN"); len(logs) != 1 || !strings.Contains(logs[0], "dev2") {
+		t.Errorf("unexpected WARN logs %q", logs)
+	}
+}
+
+func TestHandleDeviceDataUnregistered(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		wantErr error
+		stored  int
+	}{
+		{name: "quarantine", mode: "quarantine", wantErr: ErrDeviceQuarantined, stored: 1},
+		{name: "reject", mode: "reject", wantErr: ErrUnknownDevice, stored: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t)
+			s.cfg.Security.UnknownDevices = tt.mode
+
+			_, err := s.HandleDeviceData("ghost", "10.0.0.1", dataRequest("ghost", 2000, time.Now()))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if _, total, _ := s.GetQuarantinedData("ghost", 10, 0); total != tt.stored {
+				t.Errorf("%d uploads quarantined, want %d", total, tt.stored)
+			}
+			if _, total, _ := s.GetDeviceHistory("ghost", nil, nil, 10, 0); total != 0 {
+				t.Errorf("%d readings stored in sensor_data", total)
+			}
+		})
+	}
+}
+
+func TestQuarantineMaxDevices(t *testing.T) {
+	s := newTestService(t)
+	s.cfg.Security.QuarantineMaxDevices = 2
+
+	upload := func(deviceID string, source int) error {
+		_, err := s.HandleDeviceData(deviceID, fmt.Sprintf("10.0.0.%d", source), dataRequest(deviceID, 2000, time.Now()))
+		return err
+	}
+	for i, deviceID := range []string{"ghost1", "ghost2"} {
+		if err := upload(deviceID, i); !errors.Is(err, ErrDeviceQuarantined) {
+			t.Fatalf("%s: got %v, want ErrDeviceQuarantined", deviceID, err)
+		}
+	}
+	if err := upload("ghost3", 3); !errors.Is(err, ErrQuarantineFull) {
+		t.Errorf("third device: got %v, want ErrQuarantineFull", err)
+	}
+	// 已在隔离区的设备不受上限影响
+	if err := upload("ghost1", 4); !errors.Is(err, ErrDeviceQuarantined) {
+		t.Errorf("quarantined device: got %v, want ErrDeviceQuarantined", err)
+	}
+
+	devices, err := s.GetQuarantinedDevices()
+	if err != nil {
+		t.Fatalf("GetQuarantinedDevices: %v", err)
+	}
+	if len(devices) != 2 {
+		t.Errorf("%d devices quarantined, want 2", len(devices))
+	}
+}
+
+func TestQuarantineLimitPerDevice(t *testing.T) {
+	s := newTestService(t)
+	s.cfg.Security.QuarantineLimit = 3
+	start := time.Now().Add(-time.Hour)
+
+	for i := 0; i < 5; i++ {
+		s.HandleDeviceData("ghost", fmt.Sprintf("10.0.0.%d", i), dataRequest("ghost", 1000+i, start.Add(time.Duration(i)*time.Minute)))
+	}
+	entries, total, err := s.GetQuarantinedData("ghost", 10, 0)
+	if err != nil {
+		t.Fatalf("GetQuarantinedData: %v", err)
+	}
+	if total != 3 || !strings.Contains(entries[0].Payload, `"soil_raw":1004`) {
+		t.Errorf("got %d uploads, newest %s; want the 3 newest", total, entries[0].Payload)
+	}
+}
+
+func TestQuarantineRatePerSource(t *testing.T) {
+	s := newTestService(t)
+	s.quarantineRate = newSourceLimiter(3)
+
+	for i := 0; i < 3; i++ {
+		deviceID := fmt.Sprintf("ghost%d", i)
+		if _, err := s.HandleDeviceData(deviceID, "10.0.0.1", dataRequest(deviceID, 2000, time.Now())); !errors.Is(err, ErrDeviceQuarantined) {
+			t.Fatalf("upload %d: got %v, want ErrDeviceQuarantined", i+1, err)
+		}
+	}
+	if _, err := s.HandleDeviceData("ghost9", "10.0.0.1", dataRequest("ghost9", 2000, time.Now())); !errors.Is(err, ErrQuarantineRateLimited) {
+		t.Errorf("fourth upload: got %v, want ErrQuarantineRateLimited", err)
+	}
+	if _, total, _ := s.GetQuarantinedData("ghost9", 10, 0); total != 0 {
+		t.Errorf("rate limited upload was quarantined")
+	}
+	// 其他来源不受影响
+	if _, err := s.HandleDeviceData("ghost9", "10.0.0.2", dataRequest("ghost9", 2000, time.Now())); !errors.Is(err, ErrDeviceQuarantined) {
+		t.Errorf("other source: got %v, want ErrDeviceQuarantined", err)
+	}
+}
+
+func TestSourceLimiterWindow(t *testing.T) {
+	l := newSourceLimiter(2)
+	now := time.Now()
+
+	for i, want := range []bool{true, true, false} {
+		if got := l.allow("a", now); got != want {
+			t.Errorf("request %d: allowed %v, want %v", i+1, got, want)
+		}
+	}
+	if !l.allow("b", now) {
+		t.Errorf("other source limited")
+	}
+	// 窗口结束后重新计数，已结束的窗口被清理
+	later := now.Add(time.Minute + time.Second)
+	if !l.allow("a", later) {
+		t.Errorf("source still limited after the window")
+	}
+	if _, ok := l.windows["b"]; ok {
+		t.Errorf("expired window not pruned")
+	}
+}
+
+func TestClaimQuarantinedDevice(t *testing.T) {
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	quarantine := func(t *testing.T, s *Service, deviceID string, n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			req := dataRequest(deviceID, 1500+i, start.Add(time.Duration(i)*time.Minute))
+			if _, err := s.HandleDeviceData(deviceID, "10.0.0.1", req); !errors.Is(err, ErrDeviceQuarantined) {
+				t.Fatalf("quarantine upload: %v", err)
+			}
+		}
+	}
+
+	t.Run("register and import", func(t *testing.T) {
+		s := newTestService(t)
+		quarantine(t, s, "ghost", 3)
+
+		user, imported, err := s.ClaimQuarantinedDevice("ghost", &models.ClaimDeviceRequest{Username: "ghostuser", Password: "password"}, "admin")
+		if err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+		if user == nil || imported != 3 {
+			t.Fatalf("got user %v and %d imported, want a new user and 3", user, imported)
+		}
+		if ok, _ := s.deviceRepo.Exists("ghost"); !ok {
+			t.Errorf("device not registered")
+		}
+
+		readings, total, err := s.GetDeviceHistory("ghost", nil, nil, 10, 0)
+		if err != nil {
+			t.Fatalf("GetDeviceHistory: %v", err)
+		}
+		if total != 3 {
+			t.Fatalf("%d readings in sensor_data, want 3", total)
+		}
+		for _, r := range readings {
+			i := int(r.Timestamp.Sub(start) / time.Minute)
+			if r.SoilRaw == nil || *r.SoilRaw != 1500+i {
+				t.Errorf("reading at %s has soil %v, want %d", r.Timestamp, r.SoilRaw, 1500+i)
+			}
+		}
+		if _, total, _ := s.GetQuarantinedData("ghost", 10, 0); total != 0 {
+			t.Errorf("%d uploads left in quarantine", total)
+		}
+	})
+
+	t.Run("discard data", func(t *testing.T) {
+		s := newTestService(t)
+		quarantine(t, s, "ghost", 2)
+
+		_, imported, err := s.ClaimQuarantinedDevice("ghost", &models.ClaimDeviceRequest{Username: "ghostuser", Password: "password", DiscardData: true}, "admin")
+		if err != nil || imported != 0 {
+			t.Fatalf("claim: %d imported, %v", imported, err)
+		}
+		if _, total, _ := s.GetDeviceHistory("ghost", nil, nil, 10, 0); total != 0 {
+			t.Errorf("%d readings imported", total)
+		}
+	})
+
+	t.Run("invalid claims", func(t *testing.T) {
+		s := newTestService(t)
+		quarantine(t, s, "ghost", 1)
+
+		if _, _, err := s.ClaimQuarantinedDevice("other", &models.ClaimDeviceRequest{Username: "u1", Password: "password"}, "admin"); !errors.Is(err, ErrNothingQuarantined) {
+			t.Errorf("nothing quarantined: got %v", err)
+		}
+		if _, _, err := s.ClaimQuarantinedDevice("ghost", &models.ClaimDeviceRequest{}, "admin"); !errors.Is(err, ErrInvalidClaim) {
+			t.Errorf("missing credentials: got %v", err)
+		}
+		// 认领前已注册的设备只导入数据
+		registerDevice(t, s, "ghost")
+		if _, _, err := s.ClaimQuarantinedDevice("ghost", &models.ClaimDeviceRequest{Username: "u2", Password: "password"}, "admin"); !errors.Is(err, ErrInvalidClaim) {
+			t.Errorf("username for registered device: got %v", err)
+		}
+		user, imported, err := s.ClaimQuarantinedDevice("ghost", &models.ClaimDeviceRequest{}, "admin")
+		if err != nil || user != nil || imported != 1 {
+			t.Errorf("registered device: user %v, %d imported, %v", user, imported, err)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+// GetPlanOverrides lists the device's overrides from today on
+func (s *Service) GetPlanOverrides(deviceID string) ([]*models.PlanOverride, error) {
+	return s.overrideRepo.GetFrom(deviceID, time.Now().Format("2006-01-02"))
+}
+
+// SetPlanOverride pins the irrigation volume of one plan day. The date must
+// lie within the 15-day planning horizon and the volume within the device's
+// daily limit.
+func (s *Service) SetPlanOverride(deviceID, date string, req *models.SetPlanOverrideRequest, createdBy string) (*models.PlanOverride, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", date)
+	}
+	today, _ := time.ParseInLocation("2006-01-02", time.Now().Format("2006-01-02"), time.Local)
+	if day.Before(today) || day.After(today.AddDate(0, 0, 14)) {
+		return nil, fmt.Errorf("date must be between today and 14 days ahead")
+	}
+
+	profile, err := s.GetPlannerProfile(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if *req.VolumeL > profile.MaxIrrigationPerDay {
+		return nil, fmt.Errorf("volume exceeds the device's limit of %.1f L/day", profile.MaxIrrigationPerDay)
+	}
+
+	override := &models.PlanOverride{
+		DeviceID:  deviceID,
+		Date:      date,
+		VolumeL:   *req.VolumeL,
+		Reason:    req.Reason,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+	if err := s.overrideRepo.Upsert(override); err != nil {
+		return nil, fmt.Errorf("failed to save override: %w", err)
+	}
+	// 重新读取以返回记录ID（覆盖已有记录时ID不变）
+	if saved, err := s.overrideRepo.Get(deviceID, date); err == nil {
+		override = saved
+	}
+
+	s.logRepo.Create(&models.DeviceLog{
+		DeviceID:  deviceID,
+		Timestamp: override.CreatedAt,
+		Level:     "INFO",
+		Message:   fmt.Sprintf("Plan override set for %s: %.1f L by %s", date, override.VolumeL, createdBy),
+	})
+	return override, nil
+}
+
+// ClearPlanOverride removes the override of one plan day
+func (s *Service) ClearPlanOverride(deviceID, date string) error {
+	if err := s.overrideRepo.Delete(deviceID, date); err != nil {
+		return err
+	}
+
+	s.logRepo.Create(&models.DeviceLog{
+		DeviceID:  deviceID,
+		Timestamp: time.Now(),
+		Level:     "INFO",
+		Message:   fmt.Sprintf("Plan override cleared for %s", date),
+	})
+	return nil
+}
+
+// RecomputePlanAfterOverride recalculates the plan so it reflects the
+// device's current overrides
+func (s *Service) RecomputePlanAfterOverride(deviceID string) ([]models.IrrigationPlan, error) {
+	return s.recomputePlan(deviceID, "override", nil)
+}
+
+// fixedVolumes returns the device's overrides from today on keyed by date
+func (s *Service) fixedVolumes(deviceID string) (map[string]float64, error) {
+	overrides, err := s.GetPlanOverrides(deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plan overrides: %w", err)
+	}
+	volumes := make(map[string]float64, len(overrides))
+	for _, o := range overrides {
+		volumes[o.Date] = o.VolumeL
+	}
+	return volumes, nil
+}
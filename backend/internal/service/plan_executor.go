@@ -0,0 +1,244 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+// GetWateringSchedule returns the device's watering windows, falling back to
+// the configured defaults when the device has none
+func (s *Service) GetWateringSchedule(deviceID string) (*models.WateringSchedule, error) {
+	schedule, err := s.wateringRepo.GetSchedule(deviceID)
+	if err == nil {
+		return schedule, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get watering schedule: %w", err)
+	}
+
+	windows, _ := normalizeWindows(s.cfg.Executor.DefaultWindows)
+	return &models.WateringSchedule{
+		DeviceID: deviceID,
+		Windows:  windows,
+		Enabled:  true,
+	}, nil
+}
+
+// UpdateWateringSchedule validates and stores the device's watering windows
+func (s *Service) UpdateWateringSchedule(deviceID string, windows []string, enabled *bool) (*models.WateringSchedule, error) {
+	normalized, err := normalizeWindows(windows)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := &models.WateringSchedule{
+		DeviceID:  deviceID,
+		Windows:   normalized,
+		Enabled:   enabled == nil || *enabled,
+		UpdatedAt: time.Now(),
+	}
+	if err := s.wateringRepo.UpsertSchedule(schedule); err != nil {
+		return nil, fmt.Errorf("failed to save watering schedule: %w", err)
+	}
+	return schedule, nil
+}
+
+// GetPlanDispatches returns what the executor did for a device on a given day
+func (s *Service) GetPlanDispatches(deviceID, date string) ([]*models.PlanDispatch, error) {
+	return s.wateringRepo.GetDispatches(deviceID, date)
+}
+
+// normalizeWindows validates HH:MM windows and returns them sorted and de-duplicated
+func normalizeWindows(windows []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var result []string
+	for _, w := range windows {
+		t, err := time.Parse("15:04", strings.TrimSpace(w))
+		if err != nil {
+			return nil, fmt.Errorf("invalid watering window %q, expected HH:MM", w)
+		}
+		key := t.Format("15:04")
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, key)
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("at least one watering window is required")
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// ExecutePlans dispatches today's planned irrigation for every device whose
+// watering window has opened
+func (s *Service) ExecutePlans() (string, error) {
+	devices, err := s.deviceRepo.GetAllDevices()
+	if err != nil {
+		return "", fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	now := time.Now()
+	dispatched := 0
+	var failures []string
+	for _, device := range devices {
+		n, err := s.executeDevicePlan(device.DeviceID, now)
+		dispatched += n
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", device.DeviceID, err))
+		}
+	}
+
+	summary := fmt.Sprintf("dispatched %d commands for %d devices", dispatched, len(devices))
+	if len(failures) > 0 {
+		return summary, fmt.Errorf("%s; failures: %s", summary, strings.Join(failures, "; "))
+	}
+	return summary, nil
+}
+
+// executeDevicePlan handles the due watering windows of one device.
+// 计划水量在剩余窗口之间平均分配，因降雨跳过的窗口水量顺延到后续窗口。
+func (s *Service) executeDevicePlan(deviceID string, now time.Time) (int, error) {
+	schedule, err := s.GetWateringSchedule(deviceID)
+	if err != nil {
+		return 0, err
+	}
+	if !schedule.Enabled {
+		return 0, nil
+	}
+
+	today := now.Format("2006-01-02")
+	plan, err := s.planRepo.GetByDate(deviceID, today)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get today's plan: %w", err)
+	}
+	// 人工指定的水量优先于计划（重新规划失败时计划可能尚未更新）
+	plannedVolume := plan.PlannedVolumeL
+	if override, err := s.overrideRepo.Get(deviceID, today); err == nil {
+		plannedVolume = override.VolumeL
+	}
+
+	dispatches, err := s.wateringRepo.GetDispatches(deviceID, today)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get dispatch records: %w", err)
+	}
+	handled := make(map[string]bool)
+	dispatchedVolume := 0.0
+	for _, d := range dispatches {
+		handled[d.Window] = true
+		if d.Status == "dispatched" {
+			dispatchedVolume += d.VolumeL
+		}
+	}
+
+	grace := time.Duration(s.cfg.Executor.GraceMinutes) * time.Minute
+	dispatched := 0
+	for i, window := range schedule.Windows {
+		if handled[window] {
+			continue
+		}
+
+		start, err := time.ParseInLocation("2006-01-02 15:04", today+" "+window, now.Location())
+		if err != nil {
+			return dispatched, fmt.Errorf("invalid watering window %q", window)
+		}
+		if now.Before(start) {
+			break
+		}
+		if now.After(start.Add(grace)) {
+			continue // 窗口已错过
+		}
+
+		remaining := plannedVolume - dispatchedVolume
+		if remaining < 0.05 {
+			return dispatched, nil
+		}
+
+		volume := remaining / float64(len(schedule.Windows)-i)
+		if volume < s.cfg.Executor.MinPulseVolumeL {
+			volume = math.Min(remaining, s.cfg.Executor.MinPulseVolumeL)
+		}
+		volume = math.Round(volume*10) / 10
+
+		record := &models.PlanDispatch{
+			DeviceID:  deviceID,
+			Date:      today,
+			Window:    window,
+			PlanID:    &plan.ID,
+			VolumeL:   volume,
+			Status:    "dispatched",
+			CreatedAt: now,
+		}
+
+		if s.isRaining(deviceID, now) {
+			record.Status = "skipped_rain"
+			if _, err := s.wateringRepo.CreateDispatch(record); err != nil {
+				return dispatched, fmt.Errorf("failed to record skipped window: %w", err)
+			}
+			s.logRepo.Create(&models.DeviceLog{
+				DeviceID:  deviceID,
+				Timestamp: now,
+				Level:     "INFO",
+				Message:   fmt.Sprintf("Planned irrigation skipped at %s: rain detected", window),
+			})
+			continue
+		}
+
+		// 先占用窗口再创建命令，避免重复下发
+		claimed, err := s.wateringRepo.CreateDispatch(record)
+		if err != nil {
+			return dispatched, fmt.Errorf("failed to record dispatch: %w", err)
+		}
+		if !claimed {
+			continue
+		}
+
+		cmd, err := s.enqueueIrrigation(deviceID, volume, map[string]interface{}{
+			"reason":  "plan",
+			"plan_id": plan.ID,
+			"window":  window,
+		}, &plan.ID, "plan_executor")
+		if err != nil {
+			s.wateringRepo.DeleteDispatch(record.ID)
+			return dispatched, err
+		}
+		if err := s.wateringRepo.SetDispatchCommand(record.ID, cmd.ID); err != nil {
+			return dispatched, fmt.Errorf("failed to link command to dispatch: %w", err)
+		}
+
+		dispatchedVolume += volume
+		dispatched++
+		s.logRepo.Create(&models.DeviceLog{
+			DeviceID:  deviceID,
+			Timestamp: now,
+			Level:     "INFO",
+			Message:   fmt.Sprintf("Planned irrigation dispatched at %s: %.1fL (plan %.1fL)", window, volume, plannedVolume),
+		})
+	}
+
+	return dispatched, nil
+}
+
+// isRaining reports whether the device's rain sensor recently detected rain
+func (s *Service) isRaining(deviceID string, now time.Time) bool {
+	latest, err := s.sensorDataRepo.GetLatest(deviceID)
+	if err != nil || latest.RainDigital == nil {
+		return false
+	}
+	lookback := time.Duration(s.cfg.Executor.RainLookbackMinutes) * time.Minute
+	if now.Sub(latest.Timestamp) > lookback {
+		return false
+	}
+	// 数字雨滴传感器低电平表示有雨
+	return *latest.RainDigital == 0
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"irrigation-system/backend/internal/models"
+	"irrigation-system/backend/internal/planner"
+)
+
+// ErrPlanVersionNotFound is returned for unknown plan version numbers
+var ErrPlanVersionNotFound = errors.New("plan version not found")
+
+// toForecastInputs converts planner forecast days for storage in a snapshot
+func toForecastInputs(forecasts []planner.ForecastDay) []models.PlannerForecastDay {
+	days := make([]models.PlannerForecastDay, len(forecasts))
+	for i, f := range forecasts {
+		days[i] = models.PlannerForecastDay{
+			Date:          f.Date,
+			TempMax:       f.TempMax,
+			TempMin:       f.TempMin,
+			PrecipMm:      f.PrecipMm,
+			HumidityPct:   f.HumidityPct,
+			PrecipProbPct: f.PrecipProbPct,
+		}
+	}
+	return days
+}
+
+// GetPlanVersions lists the plan versions of a device, newest first
+func (s *Service) GetPlanVersions(deviceID string, limit, offset int) ([]*models.PlanVersion, int, error) {
+	return s.planRepo.QueryVersions(deviceID, limit, offset)
+}
+
+// GetPlanVersion returns one plan version with its days and the forecast it
+// was computed from
+func (s *Service) GetPlanVersion(deviceID string, version int) (*models.PlanVersion, []models.IrrigationPlan, *models.ForecastSnapshot, error) {
+	v, err := s.getPlanVersion(deviceID, version)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	plans, err := s.planRepo.GetByVersion(v.ID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get plan days: %w", err)
+	}
+
+	var snapshot *models.ForecastSnapshot
+	if v.ForecastSnapshotID > 0 {
+		snapshot, err = s.forecastRepo.GetSnapshot(v.ForecastSnapshotID)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to get forecast snapshot: %w", err)
+		}
+	}
+
+	result := make([]models.IrrigationPlan, len(plans))
+	for i, p := range plans {
+		result[i] = *p
+	}
+	return v, result, snapshot, nil
+}
+
+// DiffPlanVersions compares two plan versions of a device. from = 0 means
+// the version before to; to = 0 means the latest version.
+func (s *Service) DiffPlanVersions(deviceID string, from, to int) (*models.PlanVersionDiff, error) {
+	var toVersion *models.PlanVersion
+	var err error
+	if to > 0 {
+		toVersion, err = s.getPlanVersion(deviceID, to)
+	} else {
+		toVersion, err = s.planRepo.GetLatestVersion(deviceID)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrPlanVersionNotFound
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if from <= 0 {
+		from = toVersion.Version - 1
+	}
+	fromVersion, err := s.getPlanVersion(deviceID, from)
+	if err != nil {
+		return nil, err
+	}
+
+	fromPlans, err := s.planRepo.GetByVersion(fromVersion.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plan days: %w", err)
+	}
+	toPlans, err := s.planRepo.GetByVersion(toVersion.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plan days: %w", err)
+	}
+
+	paramChanges, err := diffParams(fromVersion.Params, toVersion.Params)
+	if err != nil {
+		return nil, err
+	}
+	return &models.PlanVersionDiff{
+		From:              fromVersion,
+		To:                toVersion,
+		SoilReadingDelta:  toVersion.SoilReading - fromVersion.SoilReading,
+		ForecastChanged:   fromVersion.ForecastSnapshotID != toVersion.ForecastSnapshotID,
+		ParamChanges:      paramChanges,
+		Days:              diffPlanDays(fromPlans, toPlans),
+		TotalVolumeDeltaL: roundTo(toVersion.TotalVolumeL-fromVersion.TotalVolumeL, 2),
+		TotalCostDelta:    roundTo(toVersion.TotalCost-fromVersion.TotalCost, 2),
+	}, nil
+}
+
+// getPlanVersion returns a plan version by number, or ErrPlanVersionNotFound
+func (s *Service) getPlanVersion(deviceID string, version int) (*models.PlanVersion, error) {
+	v, err := s.planRepo.GetVersion(deviceID, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrPlanVersionNotFound, version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plan version: %w", err)
+	}
+	return v, nil
+}
+
+// diffParams lists the planner parameters that differ between two profiles
+func diffParams(from, to *models.PlannerProfile) ([]models.ParamChange, error) {
+	fromFields, err := profileFields(from)
+	if err != nil {
+		return nil, err
+	}
+	toFields, err := profileFields(to)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for name := range fromFields {
+		names[name] = true
+	}
+	for name := range toFields {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	changes := []models.ParamChange{}
+	for _, name := range sorted {
+		if !reflect.DeepEqual(fromFields[name], toFields[name]) {
+			changes = append(changes, models.ParamChange{Field: name, From: fromFields[name], To: toFields[name]})
+		}
+	}
+	return changes, nil
+}
+
+// profileFields returns a profile's parameters by JSON field name
+func profileFields(profile *models.PlannerProfile) (map[string]interface{}, error) {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	// 不属于规划参数的字段
+	delete(fields, "device_id")
+	delete(fields, "updated_at")
+	return fields, nil
+}
+
+// diffPlanDays compares two versions day by day over the dates of either
+func diffPlanDays(from, to []*models.IrrigationPlan) []models.PlanDayDiff {
+	byDate := make(map[string]*models.PlanDayDiff)
+	var dates []string
+	day := func(date string) *models.PlanDayDiff {
+		d, ok := byDate[date]
+		if !ok {
+			d = &models.PlanDayDiff{Date: date}
+			byDate[date] = d
+			dates = append(dates, date)
+		}
+		return d
+	}
+
+	for _, p := range from {
+		d := day(p.Date)
+		volume, predicted, rain := p.PlannedVolumeL, p.PredictedMoisture, p.RainL
+		d.FromVolumeL, d.FromPredictedMoisture, d.FromRainL = &volume, &predicted, &rain
+		d.FromLocked = p.Locked
+	}
+	for _, p := range to {
+		d := day(p.Date)
+		volume, predicted, rain := p.PlannedVolumeL, p.PredictedMoisture, p.RainL
+		d.ToVolumeL, d.ToPredictedMoisture, d.ToRainL = &volume, &predicted, &rain
+		d.ToLocked = p.Locked
+	}
+
+	sort.Strings(dates)
+	diffs := make([]models.PlanDayDiff, len(dates))
+	for i, date := range dates {
+		d := byDate[date]
+		var fromVolume, toVolume float64
+		if d.FromVolumeL != nil {
+			fromVolume = *d.FromVolumeL
+		}
+		if d.ToVolumeL != nil {
+			toVolume = *d.ToVolumeL
+		}
+		d.VolumeDeltaL = roundTo(toVolume-fromVolume, 2)
+		diffs[i] = *d
+	}
+	return diffs
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"irrigation-system/backend/internal/weather"
+)
+
+// useForecast serves a dry 15-day forecast starting today and stores it for
+// the default location
+func useForecast(t *testing.T, s *Service) {
+	t.Helper()
+	daily := make([]weather.DailyForecast, 15)
+	for i := range daily {
+		daily[i] = weather.DailyForecast{
+			Date:    time.Now().AddDate(0, 0, i).Format("2006-01-02"),
+			TempMax: 30,
+			TempMin: 20,
+		}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"daily": daily})
+	path := filepath.Join(t.TempDir(), "forecast.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write forecast: %v", err)
+	}
+
+	s.weatherClient = weather.NewFileProvider(path)
+	location := s.cfg.Weather.DefaultLocation
+	if err := s.UpdateForecast(location.Latitude, location.Longitude); err != nil {
+		t.Fatalf("UpdateForecast: %v", err)
+	}
+}
+
+func TestConcurrentRecomputes(t *testing.T) {
+	s := newTestService(t)
+	registerDevice(t, s, "dev1")
+	if _, err := s.HandleDeviceData("dev1", "10.0.0.1", dataRequest("dev1", 1800, time.Now())); err != nil {
+		t.Fatalf("HandleDeviceData: %v", err)
+	}
+	useForecast(t, s)
+
+	const n = 6
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.RecomputePlan("dev1"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("RecomputePlan: %v", err)
+	}
+
+	versions, total, err := s.planRepo.QueryVersions("dev1", n+1, 0)
+	if err != nil {
+		t.Fatalf("QueryVersions: %v", err)
+	}
+	if total != n {
+		t.Fatalf("%d versions stored, want %d", total, n)
+	}
+	seen := make(map[int]bool)
+	for _, v := range versions {
+		if seen[v.Version] || v.Version < 1 || v.Version > n {
+			t.Errorf("unexpected version number %d", v.Version)
+		}
+		seen[v.Version] = true
+	}
+}
+
+func TestPlanVersionRecordsGlobalParams(t *testing.T) {
+	s := newTestService(t)
+	s.cfg.Planner.MoistureStep = 20
+	s.cfg.Planner.VolumeStep = 0.25
+	s.cfg.Planner.EnsembleSize = 8
+	registerDevice(t, s, "dev1")
+	if _, err := s.HandleDeviceData("dev1", "10.0.0.1", dataRequest("dev1", 1800, time.Now())); err != nil {
+		t.Fatalf("HandleDeviceData: %v", err)
+	}
+	useForecast(t, s)
+
+	if _, err := s.RecomputePlan("dev1"); err != nil {
+		t.Fatalf("RecomputePlan: %v", err)
+	}
+	version, err := s.planRepo.GetLatestVersion("dev1")
+	if err != nil {
+		t.Fatalf("GetLatestVersion: %v", err)
+	}
+	if p := version.Params; p.MoistureStep != 20 || p.VolumeStep != 0.25 || p.EnsembleSize != 8 {
+		t.Errorf("stored grid settings %d / %.2f / %d, want 20 / 0.25 / 8", p.MoistureStep, p.VolumeStep, p.EnsembleSize)
+	}
+
+	// 设备参数本身不包含全局设置
+	profile, err := s.GetPlannerProfile("dev1")
+	if err != nil {
+		t.Fatalf("GetPlannerProfile: %v", err)
+	}
+	if profile.MoistureStep != 0 || profile.VolumeStep != 0 || profile.EnsembleSize != 0 {
+		t.Errorf("profile has grid settings %+v", profile)
+	}
+}
@@ -0,0 +1,285 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"irrigation-system/backend/internal/config"
+	"irrigation-system/backend/internal/models"
+	"irrigation-system/backend/internal/planner"
+)
+
+// plannerConfigFromYAML converts the YAML planner section to the planner's config
+func plannerConfigFromYAML(c config.PlannerConfig) planner.PlannerConfig {
+	return planner.PlannerConfig{
+		SoilOptimalMin:      c.SoilOptimalMin,
+		SoilOptimalMax:      c.SoilOptimalMax,
+		MaxIrrigationPerDay: c.MaxIrrigationPerDay,
+		BaseET:              c.BaseET,
+		TempFactor:          c.TempFactor,
+		RainConversion:      c.RainConversion,
+		ADCToMoisture:       c.ADCToMoisture,
+		CostW1:              c.CostW1,
+		CostW2:              c.CostW2,
+		CostW3:              c.CostW3,
+		ETModel:             c.ETModel,
+		CanopyAreaM2:        c.CanopyAreaM2,
+		MoistureStep:        c.MoistureStep,
+		VolumeStep:          c.VolumeStep,
+		VolumeBudgetL:       c.VolumeBudgetL,
+		AllowedWeekdays:     c.AllowedWeekdays,
+		MinDaysBetween:      c.MinDaysBetween,
+		MoistureFloor:       c.MoistureFloor,
+		RiskAversion:        c.RiskAversion,
+		EnsembleSize:        c.EnsembleSize,
+	}
+}
+
+// profileToPlannerConfig converts a stored profile to the planner's config
+func profileToPlannerConfig(p *models.PlannerProfile) planner.PlannerConfig {
+	return planner.PlannerConfig{
+		SoilOptimalMin:      p.SoilOptimalMin,
+		SoilOptimalMax:      p.SoilOptimalMax,
+		MaxIrrigationPerDay: p.MaxIrrigationPerDay,
+		BaseET:              p.BaseET,
+		TempFactor:          p.TempFactor,
+		RainConversion:      p.RainConversion,
+		ADCToMoisture:       p.ADCToMoisture,
+		CostW1:              p.CostW1,
+		CostW2:              p.CostW2,
+		CostW3:              p.CostW3,
+		ETModel:             p.ETModel,
+		CanopyAreaM2:        p.CanopyAreaM2,
+		VolumeBudgetL:       p.VolumeBudgetL,
+		AllowedWeekdays:     p.AllowedWeekdays,
+		MinDaysBetween:      p.MinDaysBetween,
+		MoistureFloor:       p.MoistureFloor,
+		RiskAversion:        p.RiskAversion,
+	}
+}
+
+// plannerConfigToProfile fills a profile's parameters from a planner config
+func plannerConfigToProfile(c planner.PlannerConfig, p *models.PlannerProfile) {
+	p.SoilOptimalMin = c.SoilOptimalMin
+	p.SoilOptimalMax = c.SoilOptimalMax
+	p.MaxIrrigationPerDay = c.MaxIrrigationPerDay
+	p.BaseET = c.BaseET
+	p.TempFactor = c.TempFactor
+	p.RainConversion = c.RainConversion
+	p.ADCToMoisture = c.ADCToMoisture
+	p.CostW1 = c.CostW1
+	p.CostW2 = c.CostW2
+	p.CostW3 = c.CostW3
+	p.ETModel = c.ETModel
+	p.CanopyAreaM2 = c.CanopyAreaM2
+	p.VolumeBudgetL = c.VolumeBudgetL
+	p.AllowedWeekdays = c.AllowedWeekdays
+	if p.AllowedWeekdays == nil {
+		p.AllowedWeekdays = []string{}
+	}
+	p.MinDaysBetween = c.MinDaysBetween
+	p.MoistureFloor = c.MoistureFloor
+	p.RiskAversion = c.RiskAversion
+}
+
+// planParams returns the parameters a plan version is computed with: the
+// device's profile and the global grid and ensemble settings
+func planParams(profile *models.PlannerProfile, global config.PlannerConfig) *models.PlannerProfile {
+	params := *profile
+	params.MoistureStep = global.MoistureStep
+	params.VolumeStep = global.VolumeStep
+	params.EnsembleSize = global.EnsembleSize
+	return &params
+}
+
+// GetPlannerProfile returns the device's planner profile, or the YAML
+// defaults when the device has none
+func (s *Service) GetPlannerProfile(deviceID string) (*models.PlannerProfile, error) {
+	profile, err := s.profileRepo.Get(deviceID)
+	if err == nil {
+		return profile, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get planner profile: %w", err)
+	}
+
+	profile = &models.PlannerProfile{
+		DeviceID: deviceID,
+		Source:   "default",
+	}
+	plannerConfigToProfile(plannerConfigFromYAML(s.cfg.Planner), profile)
+	return profile, nil
+}
+
+// UpdatePlannerProfile derives a profile from presets and explicit overrides and stores it
+func (s *Service) UpdatePlannerProfile(deviceID string, req *models.UpdatePlannerProfileRequest) (*models.PlannerProfile, error) {
+	cfg, err := planner.ApplyPresets(plannerConfigFromYAML(s.cfg.Planner), req.Crop, req.SoilType, req.PotSizeL)
+	if err != nil {
+		return nil, err
+	}
+
+	applyProfileOverrides(&cfg, req)
+	if req.PlantingDate != "" {
+		if _, err := time.Parse("2006-01-02", req.PlantingDate); err != nil {
+			return nil, fmt.Errorf("invalid planting date %q, expected YYYY-MM-DD", req.PlantingDate)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	profile := &models.PlannerProfile{
+		DeviceID:     deviceID,
+		Source:       "device",
+		Crop:         req.Crop,
+		SoilType:     req.SoilType,
+		PotSizeL:     req.PotSizeL,
+		PlantingDate: req.PlantingDate,
+		UpdatedAt:    &now,
+	}
+	plannerConfigToProfile(cfg, profile)
+
+	if err := s.profileRepo.Upsert(profile); err != nil {
+		return nil, fmt.Errorf("failed to save planner profile: %w", err)
+	}
+	return profile, nil
+}
+
+// applyProfileOverrides sets the parameters given explicitly in req on cfg
+func applyProfileOverrides(cfg *planner.PlannerConfig, req *models.UpdatePlannerProfileRequest) {
+	if req.SoilOptimalMin != nil {
+		cfg.SoilOptimalMin = *req.SoilOptimalMin
+	}
+	if req.SoilOptimalMax != nil {
+		cfg.SoilOptimalMax = *req.SoilOptimalMax
+	}
+	if req.MaxIrrigationPerDay != nil {
+		cfg.MaxIrrigationPerDay = *req.MaxIrrigationPerDay
+	}
+	if req.BaseET != nil {
+		cfg.BaseET = *req.BaseET
+	}
+	if req.TempFactor != nil {
+		cfg.TempFactor = *req.TempFactor
+	}
+	if req.RainConversion != nil {
+		cfg.RainConversion = *req.RainConversion
+	}
+	if req.ADCToMoisture != nil {
+		cfg.ADCToMoisture = *req.ADCToMoisture
+	}
+	if req.CostW1 != nil {
+		cfg.CostW1 = *req.CostW1
+	}
+	if req.CostW2 != nil {
+		cfg.CostW2 = *req.CostW2
+	}
+	if req.CostW3 != nil {
+		cfg.CostW3 = *req.CostW3
+	}
+	if req.ETModel != "" {
+		cfg.ETModel = req.ETModel
+	}
+	if req.CanopyAreaM2 != nil {
+		cfg.CanopyAreaM2 = *req.CanopyAreaM2
+	}
+	if req.VolumeBudgetL != nil {
+		cfg.VolumeBudgetL = *req.VolumeBudgetL
+	}
+	if req.AllowedWeekdays != nil {
+		cfg.AllowedWeekdays = make([]string, len(req.AllowedWeekdays))
+		for i, day := range req.AllowedWeekdays {
+			cfg.AllowedWeekdays[i] = strings.ToLower(strings.TrimSpace(day))
+		}
+	}
+	if req.MinDaysBetween != nil {
+		cfg.MinDaysBetween = *req.MinDaysBetween
+	}
+	if req.MoistureFloor != nil {
+		cfg.MoistureFloor = *req.MoistureFloor
+	}
+	if req.RiskAversion != nil {
+		cfg.RiskAversion = *req.RiskAversion
+	}
+}
+
+// DeletePlannerProfile removes the device's profile so it falls back to the defaults
+func (s *Service) DeletePlannerProfile(deviceID string) error {
+	return s.profileRepo.Delete(deviceID)
+}
+
+// plannerConfigFor returns the planner configuration used for a device
+func (s *Service) plannerConfigFor(deviceID string) (planner.PlannerConfig, error) {
+	profile, err := s.GetPlannerProfile(deviceID)
+	if err != nil {
+		return planner.PlannerConfig{}, err
+	}
+	return profileToPlannerConfig(profile), nil
+}
+
+// plannerForProfile returns a planner configured with the given profile of
+// the device, its ET model and its overrides
+func (s *Service) plannerForProfile(deviceID string, profile *models.PlannerProfile) (*planner.IrrigationPlanner, error) {
+	cfg := profileToPlannerConfig(profile)
+	devicePlanner := s.planner
+	if profile.Source != "default" {
+		// 网格精度和情景数是全局设置，不随设备参数保存
+		cfg.MoistureStep = s.cfg.Planner.MoistureStep
+		cfg.VolumeStep = s.cfg.Planner.VolumeStep
+		cfg.EnsembleSize = s.cfg.Planner.EnsembleSize
+		devicePlanner = planner.NewIrrigationPlanner(cfg)
+	}
+	if cfg.MinDaysBetween > 0 {
+		devicePlanner = devicePlanner.WithDaysSinceWatering(s.daysSinceWatering(deviceID, cfg.MinDaysBetween))
+	}
+	fixed, err := s.fixedVolumes(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if len(fixed) > 0 {
+		devicePlanner = devicePlanner.WithFixedVolumes(fixed)
+	}
+	if cfg.ETModel == "" || cfg.ETModel == planner.ETModelLinear {
+		return devicePlanner, nil
+	}
+
+	latitude, _ := s.forecastLocation(deviceID)
+	model, err := planner.NewETModel(cfg, latitude, profile.Crop, profile.PlantingDate)
+	if err != nil {
+		return nil, err
+	}
+	return devicePlanner.WithETModel(model), nil
+}
+
+// daysSinceWatering returns how many days ago the device last received water
+// before today, looking back at most lookback days. It returns 0 when there
+// was no watering in that period.
+func (s *Service) daysSinceWatering(deviceID string, lookback int) int {
+	today, _ := time.ParseInLocation("2006-01-02", time.Now().Format("2006-01-02"), time.Local)
+	start := today.AddDate(0, 0, -lookback).Format("2006-01-02")
+	end := today.AddDate(0, 0, -1).Format("2006-01-02")
+
+	volumes, err := s.GetExecutedVolumes(deviceID, start, end)
+	if err != nil {
+		return 0
+	}
+	days := 0
+	for _, v := range volumes {
+		if v.ExecutedVolumeL <= 0 {
+			continue
+		}
+		d, err := time.ParseInLocation("2006-01-02", v.Date, time.Local)
+		if err != nil {
+			continue
+		}
+		// 按日历日计算（四舍五入以兼容夏令时），结果按日期升序，取最近一次
+		days = int(math.Round(today.Sub(d).Hours() / 24))
+	}
+	return days
+}
@@ -3,10 +3,11 @@ package service
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"irrigation-system/backend/internal/config"
@@ -27,92 +28,111 @@ func roundToOneDecimal(val *float64) *float64 {
 
 // Service provides business logic operations
 type Service struct {
-	cfg           *config.Config
-	sensorDataRepo *repository.SensorDataRepository
-	forecastRepo   *repository.ForecastRepository
-	planRepo       *repository.PlanRepository
-	locationRepo   *repository.LocationRepository
-	logRepo        *repository.LogRepository
-	commandRepo    *repository.CommandRepository
-	userRepo       *repository.UserRepository      // 新增：用户仓储
-	deviceRepo     *repository.DeviceRepository    // 新增：设备仓储
-	weatherClient  *weather.QWeatherClient
-	planner        *planner.IrrigationPlanner
+	cfg             *config.Config
+	db              *sql.DB
+	sensorDataRepo  *repository.SensorDataRepository
+	forecastRepo    *repository.ForecastRepository
+	planRepo        *repository.PlanRepository
+	locationRepo    *repository.LocationRepository
+	logRepo         *repository.LogRepository
+	commandRepo     *repository.CommandRepository
+	userRepo        *repository.UserRepository   // 新增：用户仓储
+	deviceRepo      *repository.DeviceRepository // 新增：设备仓储
+	wateringRepo    *repository.WateringRepository
+	volumeRepo      *repository.VolumeRepository
+	profileRepo     *repository.ProfileRepository
+	trajectoryRepo  *repository.TrajectoryRepository
+	calibrationRepo *repository.CalibrationRepository
+	overrideRepo    *repository.OverrideRepository
+	twinRepo        *repository.DeviceConfigRepository
+	quarantineRepo  *repository.QuarantineRepository
+	quarantineRate  *sourceLimiter // 每个来源写入隔离区的速率限制
+	planLocks       sync.Map       // 每个设备一把锁，串行化重新规划以分配不冲突的版本号
+	weatherClient   weather.Provider
+	planner         *planner.IrrigationPlanner
 }
 
 // NewService creates a new service instance
 func NewService(
 	cfg *config.Config,
 	db *sql.DB,
-	weatherClient *weather.QWeatherClient,
+	weatherClient weather.Provider,
 ) *Service {
 	// 初始化用户仓储并创建默认管理员
 	userRepo := repository.NewUserRepository(db)
 	userRepo.InitializeAdmin() // 初始化管理员账户
 
 	return &Service{
-		cfg:            cfg,
-		sensorDataRepo: repository.NewSensorDataRepository(db),
-		forecastRepo:   repository.NewForecastRepository(db),
-		planRepo:       repository.NewPlanRepository(db),
-		locationRepo:   repository.NewLocationRepository(db),
-		logRepo:        repository.NewLogRepository(db),
-		commandRepo:    repository.NewCommandRepository(db),
-		userRepo:       userRepo,                             // 新增
-		deviceRepo:     repository.NewDeviceRepository(db),  // 新增
-		weatherClient:  weatherClient,
-		planner: planner.NewIrrigationPlanner(planner.PlannerConfig{
-			SoilOptimalMin:      cfg.Planner.SoilOptimalMin,
-			SoilOptimalMax:      cfg.Planner.SoilOptimalMax,
-			MaxIrrigationPerDay: cfg.Planner.MaxIrrigationPerDay,
-			BaseET:              cfg.Planner.BaseET,
-			TempFactor:          cfg.Planner.TempFactor,
-			RainConversion:      cfg.Planner.RainConversion,
-			ADCToMoisture:       cfg.Planner.ADCToMoisture,
-			CostW1:              cfg.Planner.CostW1,
-			CostW2:              cfg.Planner.CostW2,
-			CostW3:              cfg.Planner.CostW3,
-		}),
-	}
-}
-
-// HandleDeviceData processes device data upload and returns commands
-func (s *Service) HandleDeviceData(req *models.DeviceDataRequest) (*models.DeviceDataResponse, error) {
-	// Parse timestamp
-	timestamp, err := time.Parse(time.RFC3339, req.Timestamp)
-	if err != nil {
-		return nil, fmt.Errorf("invalid timestamp format: %w", err)
+		cfg:             cfg,
+		db:              db,
+		sensorDataRepo:  repository.NewSensorDataRepository(db),
+		forecastRepo:    repository.NewForecastRepository(db),
+		planRepo:        repository.NewPlanRepository(db),
+		locationRepo:    repository.NewLocationRepository(db),
+		logRepo:         repository.NewLogRepository(db),
+		commandRepo:     repository.NewCommandRepository(db),
+		userRepo:        userRepo,                           // 新增
+		deviceRepo:      repository.NewDeviceRepository(db), // 新增
+		wateringRepo:    repository.NewWateringRepository(db),
+		volumeRepo:      repository.NewVolumeRepository(db),
+		profileRepo:     repository.NewProfileRepository(db),
+		trajectoryRepo:  repository.NewTrajectoryRepository(db),
+		calibrationRepo: repository.NewCalibrationRepository(db),
+		overrideRepo:    repository.NewOverrideRepository(db),
+		twinRepo:        repository.NewDeviceConfigRepository(db),
+		quarantineRepo:  repository.NewQuarantineRepository(db),
+		quarantineRate:  newSourceLimiter(cfg.Security.QuarantineRate),
+		weatherClient:   weatherClient,
+		planner:         planner.NewIrrigationPlanner(plannerConfigFromYAML(cfg.Planner)),
 	}
+}
 
-	// 将温度和湿度四舍五入到小数点后一位
-	roundedTemp := roundToOneDecimal(req.TemperatureC)
-	roundedHumidity := roundToOneDecimal(req.HumidityPct)
+// HandleDeviceData processes a data upload from the authenticated device
+// deviceID, sent from source (the client IP), and returns commands. Uploads
+// from unregistered devices are quarantined or rejected.
+func (s *Service) HandleDeviceData(deviceID, source string, req *models.DeviceDataRequest) (*models.DeviceDataResponse, error) {
+	// 上报数据中的设备ID必须与认证的设备一致
+	if req.DeviceID != deviceID {
+		s.logRepo.Create(&models.DeviceLog{
+			DeviceID:  deviceID,
+			Timestamp: time.Now(),
+			Level:     "WARN",
+			Message:   fmt.Sprintf("Rejected data upload claiming to be from device %s", req.DeviceID),
+		})
+		return nil, fmt.Errorf("%w: authenticated as %s, payload from %s", ErrDeviceIDMismatch, deviceID, req.DeviceID)
+	}
 
-	// Store sensor data
-	sensorData := &models.SensorData{
-		DeviceID:     req.DeviceID,
-		Timestamp:    timestamp,
-		TemperatureC: roundedTemp,
-		HumidityPct:  roundedHumidity,
-		SoilRaw:      req.SoilRaw,
-		RainAnalog:   req.RainAnalog,
-		RainDigital:  req.RainDigital,
-		PumpState:    req.PumpState,
-		ShadeState:   req.ShadeState,
+	sensorData, err := sensorDataFromRequest(req)
+	if err != nil {
+		return nil, err
 	}
+	timestamp := sensorData.Timestamp
 
+	// 未注册设备的数据不进入 sensor_data
+	registered, err := s.deviceRepo.Exists(deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check device: %w", err)
+	}
+	if !registered {
+		return nil, s.quarantineDeviceData(source, req)
+	}
+
+	// 记录上一次的水泵状态，用于检测水泵关闭
+	previous, _ := s.sensorDataRepo.GetLatest(req.DeviceID)
+
+	// Store sensor data
 	if err := s.sensorDataRepo.Create(sensorData); err != nil {
 		return nil, fmt.Errorf("failed to store sensor data: %w", err)
 	}
 
 	// Log the data reception (使用四舍五入后的值)
 	tempValue := 0.0
-	if roundedTemp != nil {
-		tempValue = *roundedTemp
+	if sensorData.TemperatureC != nil {
+		tempValue = *sensorData.TemperatureC
 	}
 	humidityValue := 0.0
-	if roundedHumidity != nil {
-		humidityValue = *roundedHumidity
+	if sensorData.HumidityPct != nil {
+		humidityValue = *sensorData.HumidityPct
 	}
 	soilValue := 0
 	if req.SoilRaw != nil {
@@ -128,8 +148,25 @@ func (s *Service) HandleDeviceData(req *models.DeviceDataRequest) (*models.Devic
 	}
 	s.logRepo.Create(deviceLog) // Ignore error for logging
 
-	// Get pending commands
-	commands, err := s.commandRepo.GetPendingCommands(req.DeviceID)
+	// 水泵由开变关时更新当日实际灌溉水量
+	if previous != nil && previous.PumpState == "on" && req.PumpState == "off" {
+		s.RefreshExecutedVolume(req.DeviceID, timestamp.In(time.Local).Format("2006-01-02"))
+	}
+
+	// 设备上报的配置与期望配置不一致时下发 update_config，随本次响应一起下发
+	if req.Config != nil {
+		if err := s.reportDeviceConfig(req.DeviceID, req.Config); err != nil {
+			s.logRepo.Create(&models.DeviceLog{
+				DeviceID:  req.DeviceID,
+				Timestamp: time.Now(),
+				Level:     "WARN",
+				Message:   fmt.Sprintf("Device config sync failed: %v", err),
+			})
+		}
+	}
+
+	// Deliver pending commands
+	commands, err := s.deliverPendingCommands(req.DeviceID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pending commands: %w", err)
 	}
@@ -147,6 +184,28 @@ func (s *Service) HandleDeviceData(req *models.DeviceDataRequest) (*models.Devic
 	}, nil
 }
 
+// sensorDataFromRequest converts a data upload into a sensor data record
+func sensorDataFromRequest(req *models.DeviceDataRequest) (*models.SensorData, error) {
+	// Parse timestamp
+	timestamp, err := time.Parse(time.RFC3339, req.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid timestamp format: %v", ErrInvalidDeviceData, err)
+	}
+
+	// 将温度和湿度四舍五入到小数点后一位
+	return &models.SensorData{
+		DeviceID:     req.DeviceID,
+		Timestamp:    timestamp,
+		TemperatureC: roundToOneDecimal(req.TemperatureC),
+		HumidityPct:  roundToOneDecimal(req.HumidityPct),
+		SoilRaw:      req.SoilRaw,
+		RainAnalog:   req.RainAnalog,
+		RainDigital:  req.RainDigital,
+		PumpState:    req.PumpState,
+		ShadeState:   req.ShadeState,
+	}, nil
+}
+
 // GetDeviceStatus retrieves current device status
 func (s *Service) GetDeviceStatus(deviceID string) (*models.DeviceStatus, error) {
 	// Get latest sensor data
@@ -155,12 +214,16 @@ func (s *Service) GetDeviceStatus(deviceID string) (*models.DeviceStatus, error)
 		return nil, fmt.Errorf("failed to get latest data: %w", err)
 	}
 
-	// Determine soil status
+	// Determine soil status using the device's planner profile
+	plannerCfg, err := s.plannerConfigFor(deviceID)
+	if err != nil {
+		return nil, err
+	}
 	soilStatus := "optimal"
 	if latestData.SoilRaw != nil {
-		if *latestData.SoilRaw < s.cfg.Planner.SoilOptimalMin {
+		if *latestData.SoilRaw < plannerCfg.SoilOptimalMin {
 			soilStatus = "dry"
-		} else if *latestData.SoilRaw > s.cfg.Planner.SoilOptimalMax {
+		} else if *latestData.SoilRaw > plannerCfg.SoilOptimalMax {
 			soilStatus = "wet"
 		}
 	}
@@ -179,8 +242,8 @@ func (s *Service) GetDeviceStatus(deviceID string) (*models.DeviceStatus, error)
 		plannedVolume = todayPlan.PlannedVolumeL
 	}
 
-	// Get executed volume (simplified, should track actual pump runtime)
-	executedVolume, _ := s.sensorDataRepo.GetTodayIrrigationVolume(deviceID)
+	// Get executed volume from completed commands and pump runtime
+	executedVolume := s.getExecutedVolume(deviceID, today)
 
 	return &models.DeviceStatus{
 		DeviceID:     deviceID,
@@ -204,25 +267,12 @@ func (s *Service) GetDeviceHistory(deviceID string, startTime, endTime *time.Tim
 }
 
 // TriggerIrrigation creates a manual irrigation command
-func (s *Service) TriggerIrrigation(deviceID string, volumeL float64, reason string) (int64, error) {
-	// Create command parameters
-	params := map[string]interface{}{
-		"volume_l": volumeL,
-		"reason":   reason,
-	}
-	paramsJSON, _ := json.Marshal(params)
-	paramsStr := string(paramsJSON)
-
-	cmd := &models.DeviceCommand{
-		DeviceID:    deviceID,
-		CommandType: "irrigate",
-		Parameters:  &paramsStr,
-		Status:      "pending",
-		CreatedAt:   time.Now(),
-	}
-
-	if err := s.commandRepo.Create(cmd); err != nil {
-		return 0, fmt.Errorf("failed to create command: %w", err)
+func (s *Service) TriggerIrrigation(deviceID string, volumeL float64, reason, issuedBy string) (int64, error) {
+	cmd, err := s.enqueueIrrigation(deviceID, volumeL, map[string]interface{}{
+		"reason": reason,
+	}, nil, issuedBy)
+	if err != nil {
+		return 0, err
 	}
 
 	// Log the action
@@ -237,37 +287,66 @@ func (s *Service) TriggerIrrigation(deviceID string, volumeL float64, reason str
 	return cmd.ID, nil
 }
 
+// enqueueIrrigation creates a pending irrigate command with the given extra parameters
+func (s *Service) enqueueIrrigation(deviceID string, volumeL float64, extra map[string]interface{}, planID *int64, issuedBy string) (*models.DeviceCommand, error) {
+	// Create command parameters
+	params := map[string]interface{}{
+		"volume_l": volumeL,
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	paramsJSON, _ := json.Marshal(params)
+	paramsStr := string(paramsJSON)
+
+	cmd := &models.DeviceCommand{
+		DeviceID:       deviceID,
+		CommandType:    "irrigate",
+		Parameters:     &paramsStr,
+		Status:         "pending",
+		CreatedAt:      time.Now(),
+		PlanID:         planID,
+		IdempotencyKey: newIdempotencyKey(),
+		IssuedBy:       issuedBy,
+	}
+
+	if err := s.commandRepo.Create(cmd); err != nil {
+		return nil, fmt.Errorf("failed to create command: %w", err)
+	}
+	return cmd, nil
+}
+
 // UpdateForecast fetches and stores weather forecast
 func (s *Service) UpdateForecast(latitude, longitude float64) error {
-	// Fetch 15-day forecast from QWeather
-	weatherData, err := s.weatherClient.Get15DayForecast(latitude, longitude)
+	// Fetch 15-day forecast from the configured provider
+	dailyForecasts, err := s.weatherClient.DailyForecast(latitude, longitude, 15)
 	if err != nil {
-		return fmt.Errorf("failed to fetch weather data: %w", err)
+		return fmt.Errorf("failed to fetch weather data from %s: %w", s.weatherClient.Name(), err)
 	}
 
-	// Delete existing future forecasts
-	if err := s.forecastRepo.DeleteFutureForecasts(); err != nil {
+	// Delete existing future forecasts of this location only
+	locationKey := repository.LocationKey(latitude, longitude)
+	if err := s.forecastRepo.DeleteFutureForecasts(locationKey); err != nil {
 		return fmt.Errorf("failed to delete old forecasts: %w", err)
 	}
 
 	// Convert and store new forecasts
-	forecasts := make([]*models.RainForecast, 0, len(weatherData.Daily))
-	for _, daily := range weatherData.Daily {
-		tempMax, _ := strconv.ParseFloat(daily.TempMax, 64)
-		tempMin, _ := strconv.ParseFloat(daily.TempMin, 64)
-		precip, _ := strconv.ParseFloat(daily.Precip, 64)
-		humidity, _ := strconv.ParseFloat(daily.Humidity, 64)
-
-		rawJSON, _ := json.Marshal(daily)
+	forecasts := make([]*models.RainForecast, 0, len(dailyForecasts))
+	for _, daily := range dailyForecasts {
+		tempMax, tempMin, precip := daily.TempMax, daily.TempMin, daily.PrecipMm
 
 		forecasts = append(forecasts, &models.RainForecast{
-			Date:        daily.FxDate,
-			TempMax:     &tempMax,
-			TempMin:     &tempMin,
-			PrecipMm:    &precip,
-			HumidityPct: &humidity,
-			RawJSON:     string(rawJSON),
-			CreatedAt:   time.Now(),
+			LocationKey:   locationKey,
+			Latitude:      &latitude,
+			Longitude:     &longitude,
+			Date:          daily.Date,
+			TempMax:       &tempMax,
+			TempMin:       &tempMin,
+			PrecipMm:      &precip,
+			HumidityPct:   daily.HumidityPct,
+			PrecipProbPct: daily.PrecipProbPct,
+			RawJSON:       string(daily.Raw),
+			CreatedAt:     time.Now(),
 		})
 	}
 
@@ -278,13 +357,54 @@ func (s *Service) UpdateForecast(latitude, longitude float64) error {
 	return nil
 }
 
-// GetForecast returns weather forecast data
-func (s *Service) GetForecast(days int) ([]*models.RainForecast, error) {
-	return s.forecastRepo.GetForecastDays(days)
+// UpdateDeviceForecast fetches and stores the forecast for a device's location
+func (s *Service) UpdateDeviceForecast(deviceID string) error {
+	latitude, longitude := s.forecastLocation(deviceID)
+	return s.UpdateForecast(latitude, longitude)
+}
+
+// GetForecast returns weather forecast data for a device's location.
+// An empty deviceID selects the default location.
+func (s *Service) GetForecast(deviceID string, days int) ([]*models.RainForecast, error) {
+	latitude, longitude := s.forecastLocation(deviceID)
+	forecasts, err := s.forecastRepo.GetForecastDays(repository.LocationKey(latitude, longitude), days)
+	if err != nil || len(forecasts) > 0 {
+		return forecasts, err
+	}
+
+	// 该位置尚未刷新过预报时，使用迁移前保存的全局预报
+	return s.forecastRepo.GetForecastDays("", days)
+}
+
+// forecastLocation returns the device's registered location, or the default
+// location when the device has none
+func (s *Service) forecastLocation(deviceID string) (float64, float64) {
+	if deviceID != "" {
+		if loc, err := s.locationRepo.Get(deviceID); err == nil {
+			return loc.Latitude, loc.Longitude
+		}
+	}
+	return s.cfg.Weather.DefaultLocation.Latitude, s.cfg.Weather.DefaultLocation.Longitude
+}
+
+// GetWeatherStats returns cache and quota metrics of the weather providers
+func (s *Service) GetWeatherStats() map[string]weather.ProviderStats {
+	return weather.CollectStats(s.weatherClient)
 }
 
 // RecomputePlan recalculates irrigation plan based on current data
 func (s *Service) RecomputePlan(deviceID string) ([]models.IrrigationPlan, error) {
+	return s.recomputePlan(deviceID, "manual", nil)
+}
+
+// recomputePlan calculates a new plan version and records the predicted
+// trajectory. Earlier versions are kept for auditing.
+// reason is manual, schedule, drift or override; drift is the deviation that triggered it.
+func (s *Service) recomputePlan(deviceID, reason string, drift *float64) ([]models.IrrigationPlan, error) {
+	lock, _ := s.planLocks.LoadOrStore(deviceID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
 	// Get latest sensor data for initial soil moisture
 	latestData, err := s.sensorDataRepo.GetLatest(deviceID)
 	if err != nil {
@@ -295,50 +415,104 @@ func (s *Service) RecomputePlan(deviceID string) ([]models.IrrigationPlan, error
 		return nil, fmt.Errorf("no soil moisture data available")
 	}
 
-	// Get 15-day forecast
-	forecasts, err := s.forecastRepo.GetForecastDays(15)
+	// Get 15-day forecast for the device's location
+	forecasts, err := s.GetForecast(deviceID, 15)
 	if err != nil || len(forecasts) == 0 {
 		return nil, fmt.Errorf("no forecast data available")
 	}
 
 	// Convert forecasts to planner format
-	plannerForecasts := make([]planner.ForecastDay, len(forecasts))
-	for i, f := range forecasts {
-		plannerForecasts[i] = planner.ForecastDay{
-			Date:     f.Date,
-			TempMax:  *f.TempMax,
-			TempMin:  *f.TempMin,
-			PrecipMm: *f.PrecipMm,
-		}
-	}
+	plannerForecasts := toPlannerForecasts(forecasts)
 
-	// Run DP algorithm
-	dailyPlans := s.planner.ComputePlan(*latestData.SoilRaw, plannerForecasts)
+	// Run DP algorithm with the device's own profile
+	profile, err := s.GetPlannerProfile(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	devicePlanner, err := s.plannerForProfile(deviceID, profile)
+	if err != nil {
+		return nil, err
+	}
+	dailyPlans, err := devicePlanner.ComputePlan(*latestData.SoilRaw, plannerForecasts)
+	if err != nil {
+		// 保留原计划，由用户调整约束
+		s.logRepo.Create(&models.DeviceLog{
+			DeviceID:  deviceID,
+			Timestamp: time.Now(),
+			Level:     "WARN",
+			Message:   fmt.Sprintf("Irrigation plan not recomputed (%s): %v", reason, err),
+		})
+		return nil, err
+	}
 
-	// Delete existing future plans
-	if err := s.planRepo.DeleteFuturePlans(deviceID); err != nil {
-		return nil, fmt.Errorf("failed to delete old plans: %w", err)
+	// 本次使用的预报快照
+	latitude, longitude := s.forecastLocation(deviceID)
+	snapshot := &models.ForecastSnapshot{
+		LocationKey: repository.LocationKey(latitude, longitude),
+		Days:        toForecastInputs(plannerForecasts),
+		CreatedAt:   time.Now(),
 	}
 
-	// Convert and store new plans
+	// Convert new plans to a new version
+	soilReadingAt := latestData.Timestamp
+	version := &models.PlanVersion{
+		DeviceID:      deviceID,
+		Reason:        reason,
+		SoilReading:   *latestData.SoilRaw,
+		SoilReadingAt: &soilReadingAt,
+		Drift:         drift,
+		Params:        planParams(profile, s.cfg.Planner),
+		Days:          len(dailyPlans),
+		CreatedAt:     time.Now(),
+	}
 	irrigationPlans := make([]*models.IrrigationPlan, len(dailyPlans))
+	trajectory := &models.PlanTrajectory{
+		DeviceID:      deviceID,
+		Reason:        reason,
+		StartMoisture: *latestData.SoilRaw,
+		Drift:         drift,
+		CreatedAt:     time.Now(),
+		Points:        make([]models.TrajectoryPoint, len(dailyPlans)),
+	}
 	for i, dp := range dailyPlans {
-		irrigationPlans[i] = &models.IrrigationPlan{
-			DeviceID:       deviceID,
-			Date:           dp.Date,
-			PlannedVolumeL: dp.PlannedVolumeL,
-			CreatedAt:      time.Now(),
+		irrigationPlans[i] = toIrrigationPlan(deviceID, dp)
+		trajectory.Points[i] = models.TrajectoryPoint{
+			Date:              dp.Date,
+			PredictedMoisture: roundTo(dp.PredictedMoisture, 1),
+			PlannedVolumeL:    dp.PlannedVolumeL,
 		}
+		version.TotalVolumeL += dp.PlannedVolumeL
+		version.TotalCost += dp.TotalCost()
 	}
+	version.TotalVolumeL = roundTo(version.TotalVolumeL, 2)
+	version.TotalCost = roundTo(version.TotalCost, 2)
 
-	if err := s.planRepo.CreateBatch(irrigationPlans); err != nil {
-		return nil, fmt.Errorf("failed to store plans: %w", err)
+	// 预报快照、计划版本和预测轨迹在同一事务中写入
+	err = repository.WithTx(s.db, func(tx *sql.Tx) error {
+		if err := s.forecastRepo.SaveSnapshot(tx, snapshot); err != nil {
+			return fmt.Errorf("failed to store forecast snapshot: %w", err)
+		}
+		version.ForecastSnapshotID = snapshot.ID
+		if err := s.planRepo.CreateVersion(tx, version, irrigationPlans); err != nil {
+			return fmt.Errorf("failed to store plans: %w", err)
+		}
+		if err := s.trajectoryRepo.Create(tx, trajectory); err != nil {
+			return fmt.Errorf("failed to store predicted trajectory: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Convert to response format
+	today := time.Now().Format("2006-01-02")
 	result := make([]models.IrrigationPlan, len(irrigationPlans))
 	for i, p := range irrigationPlans {
 		result[i] = *p
+		if p.Date == today {
+			result[i].ExecutedVolumeL = s.getExecutedVolume(deviceID, today)
+		}
 	}
 
 	// Log the recomputation
@@ -346,12 +520,68 @@ func (s *Service) RecomputePlan(deviceID string) ([]models.IrrigationPlan, error
 		DeviceID:  deviceID,
 		Timestamp: time.Now(),
 		Level:     "INFO",
-		Message:   fmt.Sprintf("Irrigation plan recomputed for %d days", len(result)),
+		Message:   fmt.Sprintf("Irrigation plan v%d computed for %d days (%s)", version.Version, len(result), reason),
 	})
 
 	return result, nil
 }
 
+// toPlannerForecasts converts stored forecasts to the planner's input
+func toPlannerForecasts(forecasts []*models.RainForecast) []planner.ForecastDay {
+	plannerForecasts := make([]planner.ForecastDay, len(forecasts))
+	for i, f := range forecasts {
+		plannerForecasts[i] = planner.ForecastDay{
+			Date:          f.Date,
+			TempMax:       *f.TempMax,
+			TempMin:       *f.TempMin,
+			PrecipMm:      *f.PrecipMm,
+			HumidityPct:   f.HumidityPct,
+			PrecipProbPct: f.PrecipProbPct,
+		}
+	}
+	return plannerForecasts
+}
+
+// toIrrigationPlan converts one planner day with its explanation to a stored plan
+func toIrrigationPlan(deviceID string, dp planner.DailyPlan) *models.IrrigationPlan {
+	return &models.IrrigationPlan{
+		DeviceID:          deviceID,
+		Date:              dp.Date,
+		PlannedVolumeL:    dp.PlannedVolumeL,
+		CreatedAt:         time.Now(),
+		StartMoisture:     roundTo(dp.StartMoisture, 1),
+		PredictedMoisture: roundTo(dp.PredictedMoisture, 1),
+		ETL:               roundTo(dp.ETL, 3),
+		RainL:             roundTo(dp.RainL, 3),
+		Cost: models.PlanCost{
+			Deviation:  roundTo(dp.CostDeviation, 2),
+			Water:      roundTo(dp.CostWater, 2),
+			Smoothness: roundTo(dp.CostSmoothness, 2),
+			Total:      roundTo(dp.TotalCost(), 2),
+		},
+		Locked: dp.Fixed,
+	}
+}
+
+// GetPlan returns the stored plan of a device from today on, with the
+// predicted moisture, water balance and cost breakdown of each day
+func (s *Service) GetPlan(deviceID string, days int) ([]models.IrrigationPlan, error) {
+	plans, err := s.planRepo.GetFuturePlans(deviceID, days)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plans: %w", err)
+	}
+
+	today := time.Now().Format("2006-01-02")
+	result := make([]models.IrrigationPlan, len(plans))
+	for i, p := range plans {
+		result[i] = *p
+		if p.Date == today {
+			result[i].ExecutedVolumeL = s.getExecutedVolume(deviceID, today)
+		}
+	}
+	return result, nil
+}
+
 // GetLocation retrieves device location
 func (s *Service) GetLocation(deviceID string) (*models.DeviceLocation, error) {
 	return s.locationRepo.Get(deviceID)
@@ -375,9 +605,118 @@ func (s *Service) GetLogs(deviceID string, level *string, startTime *time.Time,
 	return s.logRepo.Query(deviceID, level, startTime, limit, offset)
 }
 
-// UpdateCommandStatus updates command execution status
-func (s *Service) UpdateCommandStatus(commandID int64, status string, result *string) error {
-	return s.commandRepo.UpdateCommandStatus(commandID, status, result)
+// UpdateCommandStatus updates the execution status a device reports for
+// one of its commands. A repeated report of the command's current status is
+// ignored and reported as duplicate.
+func (s *Service) UpdateCommandStatus(deviceID string, req *models.CommandExecutionRequest) (duplicate bool, err error) {
+	if !deviceReportedStatuses[req.Status] {
+		return false, fmt.Errorf("%w: devices may only report executing, completed or failed", ErrInvalidTransition)
+	}
+
+	cmd, err := s.commandRepo.GetByID(req.CommandID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("%w: %d", ErrCommandNotFound, req.CommandID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get command: %w", err)
+	}
+	if cmd.DeviceID != deviceID {
+		s.logRepo.Create(&models.DeviceLog{
+			DeviceID:  cmd.DeviceID,
+			Timestamp: time.Now(),
+			Level:     "WARN",
+			Message:   fmt.Sprintf("Rejected status report for command %d from device %s", cmd.ID, deviceID),
+		})
+		return false, fmt.Errorf("%w: command %d", ErrCommandDeviceMismatch, cmd.ID)
+	}
+	// 旧固件不回传幂等键
+	if req.IdempotencyKey != "" && req.IdempotencyKey != cmd.IdempotencyKey {
+		return false, fmt.Errorf("%w: command %d", ErrIdempotencyKeyMismatch, cmd.ID)
+	}
+	if cmd.Status == req.Status {
+		return true, nil
+	}
+	// 已排队重新下发的命令，设备仍可能上报上一次下发的执行情况
+	if cmd.Status == "pending" && cmd.Attempts > 0 {
+		if _, err := s.commandRepo.Transition(cmd.ID, []string{"pending"}, "delivered", nil); err != nil {
+			return false, fmt.Errorf("failed to update command status: %w", err)
+		}
+	}
+
+	if err := s.transitionCommand(cmd.ID, req.Status, &req.Result); err != nil {
+		return false, err
+	}
+
+	// 灌溉命令完成后更新当日实际灌溉水量
+	if req.Status == "completed" && cmd.CommandType == "irrigate" {
+		s.RefreshExecutedVolume(cmd.DeviceID, time.Now().Format("2006-01-02"))
+	}
+	return false, nil
+}
+
+// ========== 定时任务相关服务方法 ==========
+
+// RefreshAllForecasts updates the forecast for every registered device location.
+// Locations that round to the same grid cell are fetched only once.
+func (s *Service) RefreshAllForecasts() (string, error) {
+	locations, err := s.locationRepo.GetAll()
+	if err != nil {
+		return "", fmt.Errorf("failed to list device locations: %w", err)
+	}
+
+	type coord struct{ lat, lon float64 }
+	var coords []coord
+	seen := make(map[string]bool)
+	for _, loc := range locations {
+		key := repository.LocationKey(loc.Latitude, loc.Longitude)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		coords = append(coords, coord{loc.Latitude, loc.Longitude})
+	}
+
+	// 没有设备登记位置时使用默认位置
+	if len(coords) == 0 {
+		coords = append(coords, coord{
+			s.cfg.Weather.DefaultLocation.Latitude,
+			s.cfg.Weather.DefaultLocation.Longitude,
+		})
+	}
+
+	var failures []string
+	for _, c := range coords {
+		if err := s.UpdateForecast(c.lat, c.lon); err != nil {
+			failures = append(failures, fmt.Sprintf("(%.2f,%.2f): %v", c.lat, c.lon, err))
+		}
+	}
+
+	summary := fmt.Sprintf("refreshed %d/%d locations", len(coords)-len(failures), len(coords))
+	if len(failures) > 0 {
+		return summary, fmt.Errorf("%s; failures: %s", summary, strings.Join(failures, "; "))
+	}
+	return summary, nil
+}
+
+// RecomputeAllPlans recomputes the irrigation plan for every registered device
+func (s *Service) RecomputeAllPlans() (string, error) {
+	devices, err := s.deviceRepo.GetAllDevices()
+	if err != nil {
+		return "", fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	var failures []string
+	for _, device := range devices {
+		if _, err := s.recomputePlan(device.DeviceID, "schedule", nil); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", device.DeviceID, err))
+		}
+	}
+
+	summary := fmt.Sprintf("recomputed %d/%d devices", len(devices)-len(failures), len(devices))
+	if len(failures) > 0 {
+		return summary, fmt.Errorf("%s; failures: %s", summary, strings.Join(failures, "; "))
+	}
+	return summary, nil
 }
 
 // ========== 用户认证相关服务方法 ==========
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"irrigation-system/backend/internal/config"
+	"irrigation-system/backend/internal/database"
+	"irrigation-system/backend/internal/models"
+	"irrigation-system/backend/internal/weather"
+)
+
+// newTestService returns a service on a fresh database with the settings of
+// config.example.yaml
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	cfg, err := config.Load("../../configs/config.example.yaml")
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+
+	dir := t.TempDir()
+	db, err := database.New(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.InitSchema("../../configs/schema.sql"); err != nil {
+		t.Fatalf("init schema: %v", err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return NewService(cfg, db.DB, weather.NewFileProvider(filepath.Join(dir, "forecast.json")))
+}
+
+// registerDevice creates a user owning deviceID
+func registerDevice(t *testing.T, s *Service, deviceID string) {
+	t.Helper()
+	if _, err := s.CreateUser(&models.CreateUserRequest{
+		Username:   "user_" + deviceID,
+		Password:   "password",
+		DeviceID:   deviceID,
+		DeviceName: deviceID,
+	}); err != nil {
+		t.Fatalf("create user for %s: %v", deviceID, err)
+	}
+}
+
+// deviceLogs returns the messages of a device's log entries at level
+func deviceLogs(t *testing.T, s *Service, deviceID, level string) []string {
+	t.Helper()
+	logs, _, err := s.GetLogs(deviceID, &level, nil, 100, 0)
+	if err != nil {
+		t.Fatalf("get logs: %v", err)
+	}
+	messages := make([]string, len(logs))
+	for i, l := range logs {
+		messages[i] = l.Message
+	}
+	return messages
+}
+
+// dataRequest returns a data upload of deviceID taken at the given time
+func dataRequest(deviceID string, soilRaw int, at time.Time) *models.DeviceDataRequest {
+	return &models.DeviceDataRequest{
+		DeviceID:   deviceID,
+		Timestamp:  at.Format(time.RFC3339),
+		SoilRaw:    &soilRaw,
+		PumpState:  "off",
+		ShadeState: "off",
+	}
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+	"irrigation-system/backend/internal/planner"
+)
+
+// SimulatePlan computes a what-if irrigation plan for a device without
+// storing anything. Inputs missing from req come from the device's latest
+// soil reading, stored forecast and current planner profile.
+func (s *Service) SimulatePlan(deviceID string, req *models.SimulatePlanRequest) (*models.PlanSimulation, error) {
+	sim := &models.PlanSimulation{}
+
+	if req.InitialSoilMoisture != nil {
+		sim.InitialSoilMoisture = *req.InitialSoilMoisture
+	} else {
+		latestData, err := s.sensorDataRepo.GetLatest(deviceID)
+		if err != nil || latestData.SoilRaw == nil {
+			return nil, fmt.Errorf("no soil moisture data available, initial_soil_moisture is required")
+		}
+		sim.InitialSoilMoisture = *latestData.SoilRaw
+	}
+
+	forecasts, source, err := s.simulationForecasts(deviceID, req.Forecast)
+	if err != nil {
+		return nil, err
+	}
+	sim.ForecastSource = source
+
+	profile, err := s.simulationProfile(deviceID, req.Params)
+	if err != nil {
+		return nil, err
+	}
+	sim.Profile = profile
+
+	devicePlanner, err := s.plannerForProfile(deviceID, profile)
+	if err != nil {
+		return nil, err
+	}
+	dailyPlans, err := devicePlanner.ComputePlan(sim.InitialSoilMoisture, forecasts)
+	if err != nil {
+		return nil, err
+	}
+
+	sim.Plans = make([]models.IrrigationPlan, len(dailyPlans))
+	sim.Trajectory = make([]models.TrajectoryPoint, len(dailyPlans))
+	for i, dp := range dailyPlans {
+		sim.Plans[i] = *toIrrigationPlan(deviceID, dp)
+		sim.Trajectory[i] = models.TrajectoryPoint{
+			Date:              dp.Date,
+			PredictedMoisture: roundTo(dp.PredictedMoisture, 1),
+			PlannedVolumeL:    dp.PlannedVolumeL,
+		}
+		sim.TotalVolumeL += dp.PlannedVolumeL
+		sim.TotalCost += dp.TotalCost()
+	}
+	sim.TotalVolumeL = roundTo(sim.TotalVolumeL, 2)
+	sim.TotalCost = roundTo(sim.TotalCost, 2)
+	return sim, nil
+}
+
+// simulationForecasts returns the caller's forecast days, or the device's
+// stored 15-day forecast when none are given
+func (s *Service) simulationForecasts(deviceID string, days []models.PlannerForecastDay) ([]planner.ForecastDay, string, error) {
+	if len(days) == 0 {
+		forecasts, err := s.GetForecast(deviceID, 15)
+		if err != nil || len(forecasts) == 0 {
+			return nil, "", fmt.Errorf("no forecast data available, forecast is required")
+		}
+		return toPlannerForecasts(forecasts), "stored", nil
+	}
+
+	forecasts := make([]planner.ForecastDay, len(days))
+	for i, d := range days {
+		if _, err := time.Parse("2006-01-02", d.Date); err != nil {
+			return nil, "", fmt.Errorf("invalid forecast date %q, expected YYYY-MM-DD", d.Date)
+		}
+		if d.TempMin > d.TempMax {
+			return nil, "", fmt.Errorf("forecast %s: temp_min is above temp_max", d.Date)
+		}
+		forecasts[i] = planner.ForecastDay{
+			Date:          d.Date,
+			TempMax:       d.TempMax,
+			TempMin:       d.TempMin,
+			PrecipMm:      d.PrecipMm,
+			HumidityPct:   d.HumidityPct,
+			PrecipProbPct: d.PrecipProbPct,
+		}
+	}
+	return forecasts, "request", nil
+}
+
+// simulationProfile returns the device's current profile with the overrides
+// of params applied. As in a profile update, crop, soil type and pot size
+// derive the parameters from the defaults again.
+func (s *Service) simulationProfile(deviceID string, params *models.UpdatePlannerProfileRequest) (*models.PlannerProfile, error) {
+	current, err := s.GetPlannerProfile(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if params == nil {
+		return current, nil
+	}
+
+	profile := *current
+	profile.Source = "simulation"
+	profile.UpdatedAt = nil
+
+	cfg := profileToPlannerConfig(current)
+	if params.Crop != "" || params.SoilType != "" || params.PotSizeL > 0 {
+		cfg, err = planner.ApplyPresets(plannerConfigFromYAML(s.cfg.Planner), params.Crop, params.SoilType, params.PotSizeL)
+		if err != nil {
+			return nil, err
+		}
+		profile.Crop = params.Crop
+		profile.SoilType = params.SoilType
+		profile.PotSizeL = params.PotSizeL
+	}
+	applyProfileOverrides(&cfg, params)
+	if params.PlantingDate != "" {
+		if _, err := time.Parse("2006-01-02", params.PlantingDate); err != nil {
+			return nil, fmt.Errorf("invalid planting date %q, expected YYYY-MM-DD", params.PlantingDate)
+		}
+		profile.PlantingDate = params.PlantingDate
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	plannerConfigToProfile(cfg, &profile)
+	return &profile, nil
+}
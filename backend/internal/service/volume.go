@@ -0,0 +1,225 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+// resultVolumePattern extracts the volume from firmware results such as
+// "Irrigation completed: 2.40L"
+var resultVolumePattern = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*L\b`)
+
+// RefreshExecutedVolume recomputes and stores the executed volume of a device for one day
+func (s *Service) RefreshExecutedVolume(deviceID, date string) (*models.DailyIrrigationVolume, error) {
+	volume, err := s.computeExecutedVolume(deviceID, date)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.volumeRepo.Upsert(volume); err != nil {
+		return nil, fmt.Errorf("failed to store executed volume: %w", err)
+	}
+	return volume, nil
+}
+
+// StoreExecutedVolumes stores the executed volume of every device for
+// yesterday and today, so that days without a completed command or pump
+// stop are stored too
+func (s *Service) StoreExecutedVolumes() (string, error) {
+	devices, err := s.deviceRepo.GetAllDevices()
+	if err != nil {
+		return "", fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	now := time.Now()
+	dates := []string{now.AddDate(0, 0, -1).Format("2006-01-02"), now.Format("2006-01-02")}
+	var failures []string
+	for _, device := range devices {
+		for _, date := range dates {
+			if _, err := s.RefreshExecutedVolume(device.DeviceID, date); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", device.DeviceID, err))
+				break
+			}
+		}
+	}
+
+	summary := fmt.Sprintf("stored volumes of %d/%d devices", len(devices)-len(failures), len(devices))
+	if len(failures) > 0 {
+		return summary, fmt.Errorf("%s; failures: %s", summary, strings.Join(failures, "; "))
+	}
+	return summary, nil
+}
+
+// computeExecutedVolume computes the executed volume of a device for one day
+// without storing it
+func (s *Service) computeExecutedVolume(deviceID, date string) (*models.DailyIrrigationVolume, error) {
+	dayStart, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q: %w", date, err)
+	}
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	commandVolume, err := s.commandVolume(deviceID, dayStart, dayEnd)
+	if err != nil {
+		return nil, err
+	}
+	runtime, err := s.pumpRuntime(deviceID, dayStart, dayEnd)
+	if err != nil {
+		return nil, err
+	}
+	pumpVolume := runtime.Minutes() * s.cfg.Accounting.PumpFlowRateLPerMin
+
+	executed := commandVolume
+	switch s.cfg.Accounting.Method {
+	case "pump":
+		executed = pumpVolume
+	case "max":
+		executed = math.Max(commandVolume, pumpVolume)
+	}
+
+	return &models.DailyIrrigationVolume{
+		DeviceID:        deviceID,
+		Date:            date,
+		CommandVolumeL:  roundTo(commandVolume, 2),
+		PumpRuntimeSec:  math.Round(runtime.Seconds()),
+		PumpVolumeL:     roundTo(pumpVolume, 2),
+		ExecutedVolumeL: roundTo(executed, 2),
+		UpdatedAt:       time.Now(),
+	}, nil
+}
+
+// GetExecutedVolumes returns executed volumes for each day in [startDate, endDate].
+// Today and yesterday are always recomputed; older days use stored values and
+// are computed if missing. Nothing is stored: volumes are stored when an
+// irrigation completes, the pump stops, and by the volume_store job.
+func (s *Service) GetExecutedVolumes(deviceID, startDate, endDate string) ([]*models.DailyIrrigationVolume, error) {
+	start, err := time.ParseInLocation("2006-01-02", startDate, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start date %q: %w", startDate, err)
+	}
+	end, err := time.ParseInLocation("2006-01-02", endDate, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end date %q: %w", endDate, err)
+	}
+
+	stored, err := s.volumeRepo.GetRange(deviceID, startDate, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get executed volumes: %w", err)
+	}
+	byDate := make(map[string]*models.DailyIrrigationVolume, len(stored))
+	for _, v := range stored {
+		byDate[v.Date] = v
+	}
+
+	today := time.Now().Format("2006-01-02")
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+
+	var volumes []*models.DailyIrrigationVolume
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		date := d.Format("2006-01-02")
+		if date > today {
+			break
+		}
+		v, ok := byDate[date]
+		if !ok || date == today || date == yesterday {
+			if v, err = s.computeExecutedVolume(deviceID, date); err != nil {
+				return nil, err
+			}
+		}
+		volumes = append(volumes, v)
+	}
+	return volumes, nil
+}
+
+// getExecutedVolume returns the executed volume in liters for one day, or 0 on error
+func (s *Service) getExecutedVolume(deviceID, date string) float64 {
+	volumes, err := s.GetExecutedVolumes(deviceID, date, date)
+	if err != nil || len(volumes) == 0 {
+		return 0
+	}
+	return volumes[0].ExecutedVolumeL
+}
+
+// commandVolume sums the volume of irrigate commands completed in [start, end).
+// 优先使用设备上报结果中的实际水量，否则使用命令参数中的 volume_l
+func (s *Service) commandVolume(deviceID string, start, end time.Time) (float64, error) {
+	commands, err := s.commandRepo.GetCompleted(deviceID, "irrigate", start, end)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get completed commands: %w", err)
+	}
+
+	total := 0.0
+	for _, cmd := range commands {
+		if cmd.Result != nil {
+			if m := resultVolumePattern.FindStringSubmatch(*cmd.Result); m != nil {
+				if v, err := strconv.ParseFloat(m[1], 64); err == nil {
+					total += v
+					continue
+				}
+			}
+		}
+		if cmd.Parameters != nil {
+			var params struct {
+				VolumeL float64 `json:"volume_l"`
+			}
+			if err := json.Unmarshal([]byte(*cmd.Parameters), &params); err == nil {
+				total += params.VolumeL
+			}
+		}
+	}
+	return total, nil
+}
+
+// pumpRuntime estimates how long the pump ran in [start, end) from reported
+// pump states. Each interval between two samples counts as "on" when the
+// earlier sample reports on; intervals longer than the configured gap are
+// capped because the device was probably offline.
+func (s *Service) pumpRuntime(deviceID string, start, end time.Time) (time.Duration, error) {
+	samples, err := s.sensorDataRepo.GetPumpSamples(deviceID, start, end)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get pump samples: %w", err)
+	}
+
+	maxGap := time.Duration(s.cfg.Accounting.MaxSampleGapMinutes) * time.Minute
+	now := time.Now()
+	var runtime time.Duration
+	for i, sample := range samples {
+		if sample.PumpState != "on" {
+			continue
+		}
+
+		from := sample.Timestamp
+		to := end
+		if i+1 < len(samples) {
+			to = samples[i+1].Timestamp
+		}
+		if to.After(now) {
+			to = now
+		}
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if to.Sub(sample.Timestamp) > maxGap {
+			to = sample.Timestamp.Add(maxGap)
+		}
+		if to.After(from) {
+			runtime += to.Sub(from)
+		}
+	}
+	return runtime, nil
+}
+
+// roundTo rounds a value to the given number of decimals
+func roundTo(val float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(val*p) / p
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"irrigation-system/backend/internal/models"
+)
+
+// storedVolumes returns the stored executed volumes of a device for today
+func storedVolumes(t *testing.T, s *Service, deviceID string) []*models.DailyIrrigationVolume {
+	t.Helper()
+	today := time.Now().Format("2006-01-02")
+	volumes, err := s.volumeRepo.GetRange(deviceID, today, today)
+	if err != nil {
+		t.Fatalf("get stored volumes: %v", err)
+	}
+	return volumes
+}
+
+func TestExecutedVolumeReadsDoNotStore(t *testing.T) {
+	s := newTestService(t)
+	registerDevice(t, s, "dev1")
+	today := time.Now().Format("2006-01-02")
+
+	temperature, humidity := 25.0, 60.0
+	reading := dataRequest("dev1", 2000, time.Now())
+	reading.TemperatureC, reading.HumidityPct = &temperature, &humidity
+	if _, err := s.HandleDeviceData("dev1", "10.0.0.1", reading); err != nil {
+		t.Fatalf("HandleDeviceData: %v", err)
+	}
+	cmd, err := s.enqueueIrrigation("dev1", 2, nil, nil, "admin")
+	if err != nil {
+		t.Fatalf("queue irrigation: %v", err)
+	}
+	setCommandState(t, s, cmd.ID, "executing", 1, time.Now())
+	// 命令完成前的查询只计算
+	if _, err := s.GetDeviceStatus("dev1"); err != nil {
+		t.Fatalf("GetDeviceStatus: %v", err)
+	}
+	if _, err := s.GetExecutedVolumes("dev1", today, today); err != nil {
+		t.Fatalf("GetExecutedVolumes: %v", err)
+	}
+	if stored := storedVolumes(t, s, "dev1"); len(stored) != 0 {
+		t.Fatalf("reads stored %d volumes", len(stored))
+	}
+
+	if _, err := s.UpdateCommandStatus("dev1", &models.CommandExecutionRequest{
+		CommandID:      cmd.ID,
+		Status:         "completed",
+		Result:         "Irrigation completed: 1.80L",
+		IdempotencyKey: cmd.IdempotencyKey,
+	}); err != nil {
+		t.Fatalf("UpdateCommandStatus: %v", err)
+	}
+	stored := storedVolumes(t, s, "dev1")
+	if len(stored) != 1 || stored[0].ExecutedVolumeL != 1.8 {
+		t.Fatalf("got stored volumes %+v after completion, want 1.8 L", stored)
+	}
+
+	volumes, err := s.GetExecutedVolumes("dev1", today, today)
+	if err != nil {
+		t.Fatalf("GetExecutedVolumes: %v", err)
+	}
+	if len(volumes) != 1 || volumes[0].ExecutedVolumeL != 1.8 {
+		t.Errorf("got volumes %+v, want 1.8 L", volumes)
+	}
+}
+
+func TestStoreExecutedVolumes(t *testing.T) {
+	s := newTestService(t)
+	registerDevice(t, s, "dev1")
+	registerDevice(t, s, "dev2")
+
+	if _, err := s.StoreExecutedVolumes(); err != nil {
+		t.Fatalf("StoreExecutedVolumes: %v", err)
+	}
+	for _, deviceID := range []string{"dev1", "dev2"} {
+		if stored := storedVolumes(t, s, deviceID); len(stored) != 1 || stored[0].ExecutedVolumeL != 0 {
+			t.Errorf("%s: got stored volumes %+v, want one empty day", deviceID, stored)
+		}
+	}
+}
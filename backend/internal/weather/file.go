@@ -0,0 +1,218 @@
+package weather
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileProvider reads forecasts from a local JSON or CSV file, e.g. for offline
+// deployments or tests. The file is re-read on every call so it can be
+// replaced while the server runs. Coordinates are ignored.
+//
+// JSON: either an array of daily entries or {"daily": [...], "hourly": [...]},
+// using the field names of DailyForecast / HourlyForecast.
+// CSV: header date,temp_max,temp_min,precip_mm[,humidity_pct][,precip_prob_pct].
+type FileProvider struct {
+	path string
+}
+
+// NewFileProvider creates a file based provider
+func NewFileProvider(path string) *FileProvider {
+	return &FileProvider{path: path}
+}
+
+type fileForecast struct {
+	Daily  []DailyForecast  `json:"daily"`
+	Hourly []HourlyForecast `json:"hourly"`
+}
+
+// Name implements Provider
+func (p *FileProvider) Name() string {
+	return "file"
+}
+
+// DailyForecast implements Provider, returning up to N days from today onwards
+func (p *FileProvider) DailyForecast(latitude, longitude float64, days int) ([]DailyForecast, error) {
+	data, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+
+	today := time.Now().Format("2006-01-02")
+	var forecasts []DailyForecast
+	for _, f := range data.Daily {
+		if f.Date < today {
+			continue
+		}
+		if len(forecasts) >= days {
+			break
+		}
+		forecasts = append(forecasts, f)
+	}
+	if len(forecasts) == 0 {
+		return nil, fmt.Errorf("no forecast from %s onwards in %s", today, p.path)
+	}
+	return forecasts, nil
+}
+
+// HourlyForecast implements HourlyProvider, returning up to N hours from now onwards
+func (p *FileProvider) HourlyForecast(latitude, longitude float64, hours int) ([]HourlyForecast, error) {
+	data, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	if len(data.Hourly) == 0 {
+		return nil, ErrNotSupported
+	}
+
+	from := time.Now().Truncate(time.Hour)
+	var forecasts []HourlyForecast
+	for _, f := range data.Hourly {
+		if f.Time.Before(from) {
+			continue
+		}
+		if len(forecasts) >= hours {
+			break
+		}
+		forecasts = append(forecasts, f)
+	}
+	return forecasts, nil
+}
+
+// HistoricalDaily implements HistoricalProvider for the days in [start, end]
+func (p *FileProvider) HistoricalDaily(latitude, longitude float64, start, end time.Time) ([]DailyForecast, error) {
+	data, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+
+	startDate, endDate := start.Format("2006-01-02"), end.Format("2006-01-02")
+	var days []DailyForecast
+	for _, f := range data.Daily {
+		if f.Date >= startDate && f.Date <= endDate {
+			days = append(days, f)
+		}
+	}
+	return days, nil
+}
+
+// load reads and parses the file, sorting entries by date/time
+func (p *FileProvider) load() (*fileForecast, error) {
+	content, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read forecast file: %w", err)
+	}
+
+	var data *fileForecast
+	switch strings.ToLower(filepath.Ext(p.path)) {
+	case ".csv":
+		data, err = parseForecastCSV(string(content))
+	default:
+		data, err = parseForecastJSON(content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse forecast file %s: %w", p.path, err)
+	}
+
+	sort.Slice(data.Daily, func(i, j int) bool { return data.Daily[i].Date < data.Daily[j].Date })
+	sort.Slice(data.Hourly, func(i, j int) bool { return data.Hourly[i].Time.Before(data.Hourly[j].Time) })
+	return data, nil
+}
+
+func parseForecastJSON(content []byte) (*fileForecast, error) {
+	trimmed := strings.TrimSpace(string(content))
+	if strings.HasPrefix(trimmed, "[") {
+		var daily []DailyForecast
+		if err := json.Unmarshal(content, &daily); err != nil {
+			return nil, err
+		}
+		return &fileForecast{Daily: daily}, nil
+	}
+
+	var data fileForecast
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func parseForecastCSV(content string) (*fileForecast, error) {
+	records, err := csv.NewReader(strings.NewReader(content)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return &fileForecast{}, nil
+	}
+
+	// 按表头定位列，列顺序不限
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"date", "temp_max", "temp_min", "precip_mm"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing column %q", required)
+		}
+	}
+
+	field := func(record []string, name string) (string, bool) {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return "", false
+		}
+		v := strings.TrimSpace(record[i])
+		return v, v != ""
+	}
+	number := func(record []string, line int, name string) (float64, error) {
+		v, _ := field(record, name)
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("line %d: invalid %s %q", line, name, v)
+		}
+		return f, nil
+	}
+
+	data := &fileForecast{}
+	for n, record := range records[1:] {
+		line := n + 2
+		date, _ := field(record, "date")
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", line, date)
+		}
+
+		f := DailyForecast{Date: date}
+		if f.TempMax, err = number(record, line, "temp_max"); err != nil {
+			return nil, err
+		}
+		if f.TempMin, err = number(record, line, "temp_min"); err != nil {
+			return nil, err
+		}
+		if f.PrecipMm, err = number(record, line, "precip_mm"); err != nil {
+			return nil, err
+		}
+		if _, ok := field(record, "humidity_pct"); ok {
+			humidity, err := number(record, line, "humidity_pct")
+			if err != nil {
+				return nil, err
+			}
+			f.HumidityPct = &humidity
+		}
+		if _, ok := field(record, "precip_prob_pct"); ok {
+			prob, err := number(record, line, "precip_prob_pct")
+			if err != nil {
+				return nil, err
+			}
+			f.PrecipProbPct = &prob
+		}
+		data.Daily = append(data.Daily, f)
+	}
+	return data, nil
+}
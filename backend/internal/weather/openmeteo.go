@@ -0,0 +1,194 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// OpenMeteoClient is a client for the Open-Meteo API (no API key required)
+type OpenMeteoClient struct {
+	forecastURL string
+	archiveURL  string
+	client      *http.Client
+}
+
+// NewOpenMeteoClient creates a new Open-Meteo client
+func NewOpenMeteoClient(forecastURL, archiveURL string, timeout time.Duration) *OpenMeteoClient {
+	return &OpenMeteoClient{
+		forecastURL: forecastURL,
+		archiveURL:  archiveURL,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// openMeteoResponse represents the Open-Meteo response; values may be null
+type openMeteoResponse struct {
+	UTCOffsetSeconds int `json:"utc_offset_seconds"`
+	Daily            struct {
+		Time        []string   `json:"time"`
+		TempMax     []*float64 `json:"temperature_2m_max"`
+		TempMin     []*float64 `json:"temperature_2m_min"`
+		Precip      []*float64 `json:"precipitation_sum"`
+		HumidityAvg []*float64 `json:"relative_humidity_2m_mean"`
+		PrecipProb  []*float64 `json:"precipitation_probability_max"`
+	} `json:"daily"`
+	Hourly struct {
+		Time     []string   `json:"time"`
+		Temp     []*float64 `json:"temperature_2m"`
+		Precip   []*float64 `json:"precipitation"`
+		Humidity []*float64 `json:"relative_humidity_2m"`
+	} `json:"hourly"`
+}
+
+const (
+	openMeteoDailyVars = "temperature_2m_max,temperature_2m_min,precipitation_sum,relative_humidity_2m_mean,precipitation_probability_max"
+	// 历史数据没有降水概率
+	openMeteoArchiveVars = "temperature_2m_max,temperature_2m_min,precipitation_sum,relative_humidity_2m_mean"
+)
+
+// Name implements Provider
+func (c *OpenMeteoClient) Name() string {
+	return "open_meteo"
+}
+
+// DailyForecast implements Provider (up to 16 days)
+func (c *OpenMeteoClient) DailyForecast(latitude, longitude float64, days int) ([]DailyForecast, error) {
+	if days < 1 || days > 16 {
+		return nil, fmt.Errorf("invalid days parameter: %d, must be between 1 and 16", days)
+	}
+
+	params := c.locationParams(latitude, longitude)
+	params.Set("daily", openMeteoDailyVars)
+	params.Set("forecast_days", fmt.Sprintf("%d", days))
+
+	resp, err := c.get(c.forecastURL, params)
+	if err != nil {
+		return nil, err
+	}
+	return resp.dailyForecasts(), nil
+}
+
+// HourlyForecast implements HourlyProvider
+func (c *OpenMeteoClient) HourlyForecast(latitude, longitude float64, hours int) ([]HourlyForecast, error) {
+	if hours < 1 || hours > 16*24 {
+		return nil, fmt.Errorf("invalid hours parameter: %d, must be between 1 and %d", hours, 16*24)
+	}
+
+	params := c.locationParams(latitude, longitude)
+	params.Set("hourly", "temperature_2m,precipitation,relative_humidity_2m")
+	params.Set("forecast_hours", fmt.Sprintf("%d", hours))
+
+	resp, err := c.get(c.forecastURL, params)
+	if err != nil {
+		return nil, err
+	}
+
+	zone := time.FixedZone("", resp.UTCOffsetSeconds)
+	forecasts := make([]HourlyForecast, 0, len(resp.Hourly.Time))
+	for i, ts := range resp.Hourly.Time {
+		t, err := time.ParseInLocation("2006-01-02T15:04", ts, zone)
+		if err != nil {
+			continue
+		}
+		f := HourlyForecast{
+			Time:        t,
+			TempC:       valueAt(resp.Hourly.Temp, i),
+			PrecipMm:    valueAt(resp.Hourly.Precip, i),
+			HumidityPct: pointerAt(resp.Hourly.Humidity, i),
+		}
+		forecasts = append(forecasts, f)
+	}
+	return forecasts, nil
+}
+
+// HistoricalDaily implements HistoricalProvider using the archive API
+func (c *OpenMeteoClient) HistoricalDaily(latitude, longitude float64, start, end time.Time) ([]DailyForecast, error) {
+	if end.Before(start) {
+		return nil, fmt.Errorf("end date is before start date")
+	}
+
+	params := c.locationParams(latitude, longitude)
+	params.Set("daily", openMeteoArchiveVars)
+	params.Set("start_date", start.Format("2006-01-02"))
+	params.Set("end_date", end.Format("2006-01-02"))
+
+	resp, err := c.get(c.archiveURL, params)
+	if err != nil {
+		return nil, err
+	}
+	return resp.dailyForecasts(), nil
+}
+
+func (c *OpenMeteoClient) locationParams(latitude, longitude float64) url.Values {
+	params := url.Values{}
+	params.Set("latitude", fmt.Sprintf("%.4f", latitude))
+	params.Set("longitude", fmt.Sprintf("%.4f", longitude))
+	params.Set("timezone", "auto")
+	return params
+}
+
+func (c *OpenMeteoClient) get(baseURL string, params url.Values) (*openMeteoResponse, error) {
+	resp, err := c.client.Get(baseURL + "?" + params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result openMeteoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &result, nil
+}
+
+// dailyForecasts converts the column-oriented daily block into rows
+func (r *openMeteoResponse) dailyForecasts() []DailyForecast {
+	forecasts := make([]DailyForecast, 0, len(r.Daily.Time))
+	for i, date := range r.Daily.Time {
+		f := DailyForecast{
+			Date:          date,
+			TempMax:       valueAt(r.Daily.TempMax, i),
+			TempMin:       valueAt(r.Daily.TempMin, i),
+			PrecipMm:      valueAt(r.Daily.Precip, i),
+			HumidityPct:   pointerAt(r.Daily.HumidityAvg, i),
+			PrecipProbPct: pointerAt(r.Daily.PrecipProb, i),
+		}
+		f.Raw, _ = json.Marshal(map[string]interface{}{
+			"date":                          date,
+			"temperature_2m_max":            pointerAt(r.Daily.TempMax, i),
+			"temperature_2m_min":            pointerAt(r.Daily.TempMin, i),
+			"precipitation_sum":             pointerAt(r.Daily.Precip, i),
+			"relative_humidity_2m_mean":     f.HumidityPct,
+			"precipitation_probability_max": f.PrecipProbPct,
+		})
+		forecasts = append(forecasts, f)
+	}
+	return forecasts
+}
+
+// valueAt returns values[i], or 0 when missing or null
+func valueAt(values []*float64, i int) float64 {
+	if p := pointerAt(values, i); p != nil {
+		return *p
+	}
+	return 0
+}
+
+// pointerAt returns values[i], or nil when missing
+func pointerAt(values []*float64, i int) *float64 {
+	if i < len(values) {
+		return values[i]
+	}
+	return nil
+}
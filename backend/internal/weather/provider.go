@@ -0,0 +1,162 @@
+package weather
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"irrigation-system/backend/internal/config"
+)
+
+// ErrNotSupported is returned when a provider lacks an optional capability
+var ErrNotSupported = errors.New("not supported by weather provider")
+
+// DailyForecast is one day of provider-independent weather data
+type DailyForecast struct {
+	Date          string          `json:"date"` // YYYY-MM-DD
+	TempMax       float64         `json:"temp_max"`
+	TempMin       float64         `json:"temp_min"`
+	PrecipMm      float64         `json:"precip_mm"`
+	HumidityPct   *float64        `json:"humidity_pct,omitempty"`
+	PrecipProbPct *float64        `json:"precip_prob_pct,omitempty"` // 降水概率 (%)
+	Raw           json.RawMessage `json:"raw,omitempty"`             // 原始数据，便于排查
+}
+
+// HourlyForecast is one hour of provider-independent weather data
+type HourlyForecast struct {
+	Time        time.Time `json:"time"`
+	TempC       float64   `json:"temp_c"`
+	PrecipMm    float64   `json:"precip_mm"`
+	HumidityPct *float64  `json:"humidity_pct,omitempty"`
+}
+
+// Provider supplies daily weather forecasts for a location
+type Provider interface {
+	Name() string
+	DailyForecast(latitude, longitude float64, days int) ([]DailyForecast, error)
+}
+
+// HourlyProvider is implemented by providers that offer hourly forecasts
+type HourlyProvider interface {
+	HourlyForecast(latitude, longitude float64, hours int) ([]HourlyForecast, error)
+}
+
+// HistoricalProvider is implemented by providers that offer observed daily weather
+type HistoricalProvider interface {
+	HistoricalDaily(latitude, longitude float64, start, end time.Time) ([]DailyForecast, error)
+}
+
+// NewProvider builds the configured provider, wrapped in a fallback chain
+// when fallbacks are configured
+func NewProvider(cfg config.WeatherConfig) (Provider, error) {
+	names := append([]string{cfg.Provider}, cfg.Fallback...)
+
+	var providers []Provider
+	for _, name := range names {
+		p, err := newNamedProvider(name, cfg)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+
+	if len(providers) == 1 {
+		return providers[0], nil
+	}
+	return NewFallbackProvider(providers...), nil
+}
+
+func newNamedProvider(name string, cfg config.WeatherConfig) (Provider, error) {
+	switch strings.ToLower(name) {
+	case "", "qweather":
+		return NewQWeatherClientWithOptions(cfg.APIHost, cfg.APIKey, cfg.Timeout, QWeatherOptions{
+			CacheTTL:     cfg.CacheTTL,
+			DailyBudget:  cfg.DailyBudget,
+			MaxRetries:   cfg.MaxRetries,
+			RetryBackoff: cfg.RetryBackoff,
+		}), nil
+	case "open_meteo", "openmeteo":
+		return NewOpenMeteoClient(cfg.OpenMeteo.ForecastURL, cfg.OpenMeteo.ArchiveURL, cfg.Timeout), nil
+	case "file":
+		if cfg.File.Path == "" {
+			return nil, fmt.Errorf("weather file provider requires file.path")
+		}
+		return NewFileProvider(cfg.File.Path), nil
+	default:
+		return nil, fmt.Errorf("unknown weather provider: %s", name)
+	}
+}
+
+// FallbackProvider tries each provider in order until one succeeds
+type FallbackProvider struct {
+	providers []Provider
+}
+
+// NewFallbackProvider creates a provider chain
+func NewFallbackProvider(providers ...Provider) *FallbackProvider {
+	return &FallbackProvider{providers: providers}
+}
+
+// Name returns the names of the chained providers
+func (f *FallbackProvider) Name() string {
+	names := make([]string, len(f.providers))
+	for i, p := range f.providers {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, ">")
+}
+
+// DailyForecast returns the first successful daily forecast in the chain
+func (f *FallbackProvider) DailyForecast(latitude, longitude float64, days int) ([]DailyForecast, error) {
+	var errs []string
+	for _, p := range f.providers {
+		forecasts, err := p.DailyForecast(latitude, longitude, days)
+		if err == nil {
+			return forecasts, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", p.Name(), err))
+	}
+	return nil, fmt.Errorf("all weather providers failed: %s", strings.Join(errs, "; "))
+}
+
+// HourlyForecast returns the first successful hourly forecast in the chain
+func (f *FallbackProvider) HourlyForecast(latitude, longitude float64, hours int) ([]HourlyForecast, error) {
+	var errs []string
+	for _, p := range f.providers {
+		hp, ok := p.(HourlyProvider)
+		if !ok {
+			continue
+		}
+		forecasts, err := hp.HourlyForecast(latitude, longitude, hours)
+		if err == nil {
+			return forecasts, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", p.Name(), err))
+	}
+	if len(errs) == 0 {
+		return nil, ErrNotSupported
+	}
+	return nil, fmt.Errorf("all weather providers failed: %s", strings.Join(errs, "; "))
+}
+
+// HistoricalDaily returns the first successful historical data in the chain
+func (f *FallbackProvider) HistoricalDaily(latitude, longitude float64, start, end time.Time) ([]DailyForecast, error) {
+	var errs []string
+	for _, p := range f.providers {
+		hp, ok := p.(HistoricalProvider)
+		if !ok {
+			continue
+		}
+		days, err := hp.HistoricalDaily(latitude, longitude, start, end)
+		if err == nil {
+			return days, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", p.Name(), err))
+	}
+	if len(errs) == 0 {
+		return nil, ErrNotSupported
+	}
+	return nil, fmt.Errorf("all weather providers failed: %s", strings.Join(errs, "; "))
+}
@@ -0,0 +1,266 @@
+package weather
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrBudgetExceeded is returned when the daily upstream call budget is used up
+var ErrBudgetExceeded = errors.New("weather API daily call budget exceeded")
+
+// maxRetryAfter caps the wait requested by a Retry-After header
+const maxRetryAfter = 30 * time.Second
+
+// QWeatherOptions controls caching and quota protection of the QWeather client.
+// Zero values disable the corresponding feature.
+type QWeatherOptions struct {
+	CacheTTL     time.Duration // 缓存最短有效期，已知发布间隔时延长到预计的下次发布时间
+	DailyBudget  int           // 每日最多请求次数（含重试），0 表示不限
+	MaxRetries   int           // 429/5xx 时的最大重试次数
+	RetryBackoff time.Duration // 首次重试等待时间，之后指数增长
+	HTTPClient   *http.Client  // 为空时使用默认客户端
+}
+
+// ProviderStats reports cache and quota metrics for the current day
+type ProviderStats struct {
+	Date           string `json:"date"`
+	Calls          int    `json:"calls"`  // 今日上游请求次数（含重试）
+	Budget         int    `json:"budget"` // 0 表示不限
+	CacheHits      int    `json:"cache_hits"`
+	CacheMisses    int    `json:"cache_misses"`
+	StaleServed    int    `json:"stale_served"` // 上游失败时返回过期缓存的次数
+	Unchanged      int    `json:"unchanged"`    // 刷新后 updateTime 未变化的次数
+	Retries        int    `json:"retries"`
+	Failures       int    `json:"failures"`
+	BudgetRejected int    `json:"budget_rejected"`
+	CacheEntries   int    `json:"cache_entries"`
+}
+
+// MeteredProvider is implemented by providers that report usage metrics
+type MeteredProvider interface {
+	Stats() ProviderStats
+}
+
+// CollectStats returns metrics of all metered providers, keyed by name
+func CollectStats(p Provider) map[string]ProviderStats {
+	stats := make(map[string]ProviderStats)
+	if f, ok := p.(*FallbackProvider); ok {
+		for _, member := range f.providers {
+			for name, s := range CollectStats(member) {
+				stats[name] = s
+			}
+		}
+		return stats
+	}
+	if m, ok := p.(MeteredProvider); ok {
+		stats[p.Name()] = m.Stats()
+	}
+	return stats
+}
+
+// maxPublishInterval caps the publish interval learned from updateTime
+const maxPublishInterval = 24 * time.Hour
+
+type cacheEntry struct {
+	resp       *QWeatherResponse
+	fetchedAt  time.Time
+	freshUntil time.Time
+	interval   time.Duration // 最近两次发布的间隔，未知时为0
+}
+
+// Stats implements MeteredProvider
+func (c *QWeatherClient) Stats() ProviderStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rollStats(time.Now())
+	stats := c.stats
+	stats.Budget = c.opts.DailyBudget
+	stats.CacheEntries = len(c.cache)
+	return stats
+}
+
+// cached returns the cached response for key and whether it is still fresh.
+// A stale response is still returned so it can be served when upstream fails.
+func (c *QWeatherClient) cached(key string) (*QWeatherResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.rollStats(now)
+
+	entry, ok := c.cache[key]
+	if !ok {
+		c.stats.CacheMisses++
+		return nil, false
+	}
+	if now.Before(entry.freshUntil) {
+		c.stats.CacheHits++
+		return entry.resp, true
+	}
+	c.stats.CacheMisses++
+	return entry.resp, false
+}
+
+// store caches a fresh response and returns the response to use. If the
+// upstream updateTime did not change, the cached response is kept so callers
+// can detect that no new forecast was published. Once the publish interval
+// is known from two updateTimes, the next upstream fetch is put off until
+// the next forecast is expected, or the cache TTL if that is later.
+func (c *QWeatherClient) store(key string, resp *QWeatherResponse) *QWeatherResponse {
+	if c.opts.CacheTTL <= 0 {
+		return resp
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := c.cache[key]
+	if ok && resp.UpdateTime != "" && entry.resp.UpdateTime == resp.UpdateTime {
+		c.stats.Unchanged++
+		entry.fetchedAt = now
+		entry.freshUntil = nextFetch(now, c.opts.CacheTTL, resp.UpdateTime, entry.interval)
+		return entry.resp
+	}
+
+	// 由相邻两次发布时间推算发布间隔
+	var interval time.Duration
+	if ok {
+		previous, err1 := parseUpdateTime(entry.resp.UpdateTime)
+		current, err2 := parseUpdateTime(resp.UpdateTime)
+		if err1 == nil && err2 == nil && current.After(previous) && current.Sub(previous) <= maxPublishInterval {
+			interval = current.Sub(previous)
+		}
+	}
+
+	// 清理长时间未使用的条目
+	for k, entry := range c.cache {
+		if now.Sub(entry.fetchedAt) > 24*time.Hour {
+			delete(c.cache, k)
+		}
+	}
+	c.cache[key] = &cacheEntry{
+		resp:       resp,
+		fetchedAt:  now,
+		freshUntil: nextFetch(now, c.opts.CacheTTL, resp.UpdateTime, interval),
+		interval:   interval,
+	}
+	return resp
+}
+
+// nextFetch returns when a response published at updateTime should be
+// fetched again: at the expected next publish time, but not before the
+// cache TTL has passed
+func nextFetch(now time.Time, ttl time.Duration, updateTime string, interval time.Duration) time.Time {
+	next := now.Add(ttl)
+	if interval <= 0 {
+		return next
+	}
+	published, err := parseUpdateTime(updateTime)
+	if err != nil {
+		return next
+	}
+	if expected := published.Add(interval); expected.After(next) {
+		return expected
+	}
+	return next
+}
+
+// parseUpdateTime parses a QWeather updateTime such as "2024-06-03T08:00+08:00"
+func parseUpdateTime(s string) (time.Time, error) {
+	return time.Parse("2006-01-02T15:04Z07:00", s)
+}
+
+func (c *QWeatherClient) recordStale() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.StaleServed++
+}
+
+// reserveCall counts one upstream request against the daily budget
+func (c *QWeatherClient) reserveCall() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rollStats(time.Now())
+	if c.opts.DailyBudget > 0 && c.stats.Calls >= c.opts.DailyBudget {
+		c.stats.BudgetRejected++
+		return ErrBudgetExceeded
+	}
+	c.stats.Calls++
+	return nil
+}
+
+// rollStats resets the counters when the local date changes; caller holds mu
+func (c *QWeatherClient) rollStats(now time.Time) {
+	today := now.Format("2006-01-02")
+	if c.stats.Date != today {
+		c.stats = ProviderStats{Date: today}
+	}
+}
+
+// do sends a GET request, retrying with exponential backoff on 429 and 5xx.
+// Every attempt is counted against the daily budget.
+func (c *QWeatherClient) do(url string) (*http.Response, error) {
+	backoff := c.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		if err := c.reserveCall(); err != nil {
+			return nil, err
+		}
+
+		// Create request
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		// Set accept encoding header for gzip compression
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			c.recordFailure()
+			return nil, fmt.Errorf("failed to send request: %w", err)
+		}
+
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if !retryable {
+			if resp.StatusCode != http.StatusOK {
+				c.recordFailure()
+			}
+			return resp, nil
+		}
+		if attempt >= c.opts.MaxRetries {
+			c.recordFailure()
+			return resp, nil
+		}
+
+		wait := backoff
+		if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && retryAfter >= 0 {
+			wait = time.Duration(retryAfter) * time.Second
+			if wait > maxRetryAfter {
+				wait = maxRetryAfter
+			}
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		c.mu.Lock()
+		c.stats.Retries++
+		c.mu.Unlock()
+
+		time.Sleep(wait)
+		backoff *= 2
+	}
+}
+
+func (c *QWeatherClient) recordFailure() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Failures++
+}
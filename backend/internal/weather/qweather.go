@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,17 +18,35 @@ type QWeatherClient struct {
 	apiKey  string
 	timeout time.Duration
 	client  *http.Client
+	opts    QWeatherOptions
+
+	mu    sync.Mutex
+	cache map[string]*cacheEntry
+	stats ProviderStats
 }
 
-// NewQWeatherClient creates a new QWeather API client
+// NewQWeatherClient creates a new QWeather API client without caching,
+// call budget or retries
 func NewQWeatherClient(apiHost, apiKey string, timeout time.Duration) *QWeatherClient {
+	return NewQWeatherClientWithOptions(apiHost, apiKey, timeout, QWeatherOptions{})
+}
+
+// NewQWeatherClientWithOptions creates a QWeather API client with caching and
+// quota protection. apiHost may include a scheme, e.g. an httptest server URL.
+func NewQWeatherClientWithOptions(apiHost, apiKey string, timeout time.Duration, opts QWeatherOptions) *QWeatherClient {
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{
+			Timeout: timeout,
+		}
+	}
 	return &QWeatherClient{
 		apiHost: apiHost,
 		apiKey:  apiKey,
 		timeout: timeout,
-		client: &http.Client{
-			Timeout: timeout,
-		},
+		client:  client,
+		opts:    opts,
+		cache:   make(map[string]*cacheEntry),
 	}
 }
 
@@ -46,6 +66,7 @@ type DailyData struct {
 	TempMin   string `json:"tempMin"`
 	Precip    string `json:"precip"`
 	Humidity  string `json:"humidity"`
+	Pop       string `json:"pop"` // 降水概率 (%)，部分订阅没有
 	Pressure  string `json:"pressure"`
 	TextDay   string `json:"textDay"`
 	TextNight string `json:"textNight"`
@@ -57,40 +78,46 @@ type ReferData struct {
 	License []string `json:"license"`
 }
 
-// GetDailyForecast retrieves N-day weather forecast
-// days: 3, 7, 10, 15, or 30
-// latitude, longitude: location coordinates
-func (c *QWeatherClient) GetDailyForecast(days int, latitude, longitude float64) (*QWeatherResponse, error) {
-	// Validate days parameter
-	validDays := map[int]bool{3: true, 7: true, 10: true, 15: true, 30: true}
-	if !validDays[days] {
-		return nil, fmt.Errorf("invalid days parameter: %d, must be 3, 7, 10, 15, or 30", days)
-	}
+// HourlyResponse represents the hourly API response structure
+type HourlyResponse struct {
+	Code       string       `json:"code"`
+	UpdateTime string       `json:"updateTime"`
+	Hourly     []HourlyData `json:"hourly"`
+}
 
-	// Build URL with key parameter
-	location := fmt.Sprintf("%.2f,%.2f", longitude, latitude)
-	url := fmt.Sprintf("https://%s/v7/weather/%dd?location=%s&key=%s", c.apiHost, days, location, c.apiKey)
+// HourlyData represents hourly forecast data
+type HourlyData struct {
+	FxTime   string `json:"fxTime"`
+	Temp     string `json:"temp"`
+	Humidity string `json:"humidity"`
+	Precip   string `json:"precip"`
+}
 
-	// Create request
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// baseURL returns the API base URL; apiHost may include a scheme (e.g. for tests)
+func (c *QWeatherClient) baseURL() string {
+	if strings.HasPrefix(c.apiHost, "http://") || strings.HasPrefix(c.apiHost, "https://") {
+		return strings.TrimRight(c.apiHost, "/")
 	}
+	return "https://" + c.apiHost
+}
 
-	// Set accept encoding header for gzip compression
-	req.Header.Set("Accept-Encoding", "gzip")
+// get sends a GET request and decodes the (optionally gzipped) JSON response
+func (c *QWeatherClient) get(path string, latitude, longitude float64, out interface{}) error {
+	// Build URL with key parameter
+	location := fmt.Sprintf("%.2f,%.2f", longitude, latitude)
+	url := fmt.Sprintf("%s%s?location=%s&key=%s", c.baseURL(), path, location, c.apiKey)
 
-	// Send request
-	resp, err := c.client.Do(req)
+	// Send request (counted against the daily budget, retried on 429/5xx)
+	resp, err := c.do(url)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
 
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
 	}
 
 	// Handle gzip encoding
@@ -98,27 +125,139 @@ func (c *QWeatherClient) GetDailyForecast(days int, latitude, longitude float64)
 	if strings.Contains(resp.Header.Get("Content-Encoding"), "gzip") {
 		gzReader, err := gzip.NewReader(resp.Body)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+			return fmt.Errorf("failed to create gzip reader: %w", err)
 		}
 		defer gzReader.Close()
 		reader = gzReader
 	}
 
 	// Parse response
-	var weatherResp QWeatherResponse
-	if err := json.NewDecoder(reader).Decode(&weatherResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if err := json.NewDecoder(reader).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// GetDailyForecast retrieves N-day weather forecast
+// days: 3, 7, 10, 15, or 30
+// latitude, longitude: location coordinates
+func (c *QWeatherClient) GetDailyForecast(days int, latitude, longitude float64) (*QWeatherResponse, error) {
+	// Validate days parameter
+	validDays := map[int]bool{3: true, 7: true, 10: true, 15: true, 30: true}
+	if !validDays[days] {
+		return nil, fmt.Errorf("invalid days parameter: %d, must be 3, 7, 10, 15, or 30", days)
 	}
 
-	// Check API response code
-	if weatherResp.Code != "200" {
-		return nil, fmt.Errorf("API returned error code: %s", weatherResp.Code)
+	// Serve from cache while fresh
+	key := fmt.Sprintf("%.2f,%.2f:%d", latitude, longitude, days)
+	cached, fresh := c.cached(key)
+	if fresh {
+		return cached, nil
 	}
 
-	return &weatherResp, nil
+	var weatherResp QWeatherResponse
+	err := c.get(fmt.Sprintf("/v7/weather/%dd", days), latitude, longitude, &weatherResp)
+	if err == nil && weatherResp.Code != "200" {
+		// Check API response code
+		err = fmt.Errorf("API returned error code: %s", weatherResp.Code)
+	}
+	if err != nil {
+		// 配额用尽或上游故障时返回过期缓存，避免预报中断
+		if cached != nil {
+			c.recordStale()
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	return c.store(key, &weatherResp), nil
 }
 
 // Get15DayForecast is a convenience method to get 15-day forecast
 func (c *QWeatherClient) Get15DayForecast(latitude, longitude float64) (*QWeatherResponse, error) {
 	return c.GetDailyForecast(15, latitude, longitude)
 }
+
+// Name implements Provider
+func (c *QWeatherClient) Name() string {
+	return "qweather"
+}
+
+// DailyForecast implements Provider using the smallest QWeather range covering the requested days
+func (c *QWeatherClient) DailyForecast(latitude, longitude float64, days int) ([]DailyForecast, error) {
+	apiDays := 30
+	for _, d := range []int{3, 7, 10, 15, 30} {
+		if days <= d {
+			apiDays = d
+			break
+		}
+	}
+
+	resp, err := c.GetDailyForecast(apiDays, latitude, longitude)
+	if err != nil {
+		return nil, err
+	}
+
+	forecasts := make([]DailyForecast, 0, len(resp.Daily))
+	for _, daily := range resp.Daily {
+		if len(forecasts) >= days {
+			break
+		}
+		tempMax, _ := strconv.ParseFloat(daily.TempMax, 64)
+		tempMin, _ := strconv.ParseFloat(daily.TempMin, 64)
+		precip, _ := strconv.ParseFloat(daily.Precip, 64)
+		f := DailyForecast{
+			Date:     daily.FxDate,
+			TempMax:  tempMax,
+			TempMin:  tempMin,
+			PrecipMm: precip,
+		}
+		if humidity, err := strconv.ParseFloat(daily.Humidity, 64); err == nil {
+			f.HumidityPct = &humidity
+		}
+		if pop, err := strconv.ParseFloat(daily.Pop, 64); err == nil {
+			f.PrecipProbPct = &pop
+		}
+		f.Raw, _ = json.Marshal(daily)
+		forecasts = append(forecasts, f)
+	}
+	return forecasts, nil
+}
+
+// HourlyForecast implements HourlyProvider (24, 72 or 168 hours)
+func (c *QWeatherClient) HourlyForecast(latitude, longitude float64, hours int) ([]HourlyForecast, error) {
+	apiHours := 168
+	for _, h := range []int{24, 72, 168} {
+		if hours <= h {
+			apiHours = h
+			break
+		}
+	}
+
+	var resp HourlyResponse
+	if err := c.get(fmt.Sprintf("/v7/weather/%dh", apiHours), latitude, longitude, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Code != "200" {
+		return nil, fmt.Errorf("API returned error code: %s", resp.Code)
+	}
+
+	forecasts := make([]HourlyForecast, 0, len(resp.Hourly))
+	for _, hourly := range resp.Hourly {
+		if len(forecasts) >= hours {
+			break
+		}
+		t, err := time.Parse("2006-01-02T15:04-07:00", hourly.FxTime)
+		if err != nil {
+			continue
+		}
+		temp, _ := strconv.ParseFloat(hourly.Temp, 64)
+		precip, _ := strconv.ParseFloat(hourly.Precip, 64)
+		f := HourlyForecast{Time: t, TempC: temp, PrecipMm: precip}
+		if humidity, err := strconv.ParseFloat(hourly.Humidity, 64); err == nil {
+			f.HumidityPct = &humidity
+		}
+		forecasts = append(forecasts, f)
+	}
+	return forecasts, nil
+}
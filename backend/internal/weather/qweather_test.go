@@ -0,0 +1,293 @@
+package weather
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testLat, testLon = 39.90, 116.40
+
+// qweatherServer is a fake QWeather API that answers each request with the
+// next status code in statuses (200 once they run out) and records when the
+// requests arrived
+type qweatherServer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	statuses    []int
+	updateTimes []string // 依次返回的 updateTime，用完后重复最后一个
+	requests    []time.Time
+}
+
+func newQWeatherServer(t *testing.T, statuses ...int) *qweatherServer {
+	s := &qweatherServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *qweatherServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, time.Now())
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	updateTime := "2024-06-03T08:00+08:00"
+	if len(s.updateTimes) > 0 {
+		updateTime = s.updateTimes[0]
+		if len(s.updateTimes) > 1 {
+			s.updateTimes = s.updateTimes[1:]
+		}
+	}
+	s.mu.Unlock()
+
+	if status != http.StatusOK {
+		http.Error(w, "upstream error", status)
+		return
+	}
+	json.NewEncoder(w).Encode(QWeatherResponse{
+		Code:       "200",
+		UpdateTime: updateTime,
+		Daily: []DailyData{
+			{FxDate: "2024-06-03", TempMax: "30", TempMin: "20", Precip: "0.0"},
+			{FxDate: "2024-06-04", TempMax: "28", TempMin: "19", Precip: "4.5"},
+			{FxDate: "2024-06-05", TempMax: "27", TempMin: "18", Precip: "1.2"},
+		},
+	})
+}
+
+// requestTimes returns when the requests so far arrived
+func (s *qweatherServer) requestTimes() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Time(nil), s.requests...)
+}
+
+func (s *qweatherServer) client(opts QWeatherOptions) *QWeatherClient {
+	return NewQWeatherClientWithOptions(s.URL, "test-key", 5*time.Second, opts)
+}
+
+func TestQWeatherCacheHit(t *testing.T) {
+	server := newQWeatherServer(t)
+	client := server.client(QWeatherOptions{CacheTTL: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		forecasts, err := client.DailyForecast(testLat, testLon, 3)
+		if err != nil {
+			t.Fatalf("DailyForecast #%d: %v", i+1, err)
+		}
+		if len(forecasts) != 3 || forecasts[1].PrecipMm != 4.5 {
+			t.Fatalf("DailyForecast #%d: unexpected forecasts %+v", i+1, forecasts)
+		}
+	}
+	// 其他位置不命中缓存
+	if _, err := client.DailyForecast(31.23, 121.47, 3); err != nil {
+		t.Fatalf("DailyForecast other location: %v", err)
+	}
+
+	if got := len(server.requestTimes()); got != 2 {
+		t.Errorf("got %d upstream requests, want 2", got)
+	}
+	stats := client.Stats()
+	if stats.CacheHits != 2 || stats.CacheMisses != 2 || stats.Calls != 2 || stats.CacheEntries != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestQWeatherServesStaleCacheOnFailure(t *testing.T) {
+	server := newQWeatherServer(t, http.StatusOK, http.StatusInternalServerError)
+	client := server.client(QWeatherOptions{CacheTTL: 20 * time.Millisecond})
+
+	first, err := client.GetDailyForecast(3, testLat, testLon)
+	if err != nil {
+		t.Fatalf("GetDailyForecast: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	stale, err := client.GetDailyForecast(3, testLat, testLon)
+	if err != nil {
+		t.Fatalf("GetDailyForecast after upstream failure: %v", err)
+	}
+	if stale != first {
+		t.Errorf("expected the expired cached response to be served")
+	}
+	if stats := client.Stats(); stats.StaleServed != 1 || stats.Failures != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestQWeatherUnchangedForecast(t *testing.T) {
+	server := newQWeatherServer(t)
+	client := server.client(QWeatherOptions{CacheTTL: 20 * time.Millisecond})
+
+	first, err := client.GetDailyForecast(3, testLat, testLon)
+	if err != nil {
+		t.Fatalf("GetDailyForecast: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// updateTime 未变化时返回原缓存
+	again, err := client.GetDailyForecast(3, testLat, testLon)
+	if err != nil {
+		t.Fatalf("GetDailyForecast: %v", err)
+	}
+	if again != first {
+		t.Errorf("expected the cached response for an unchanged updateTime")
+	}
+	// 发布间隔未知，仍按缓存有效期刷新
+	time.Sleep(30 * time.Millisecond)
+	if _, err := client.GetDailyForecast(3, testLat, testLon); err != nil {
+		t.Fatalf("GetDailyForecast: %v", err)
+	}
+
+	if got := len(server.requestTimes()); got != 3 {
+		t.Errorf("got %d upstream requests, want 3", got)
+	}
+	if stats := client.Stats(); stats.Unchanged != 2 || stats.CacheHits != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestQWeatherWaitsForNextPublish(t *testing.T) {
+	const layout = "2006-01-02T15:04Z07:00"
+	now := time.Now()
+	server := newQWeatherServer(t)
+	// 两次发布间隔2小时，下次发布预计在1小时后
+	server.updateTimes = []string{now.Add(-3 * time.Hour).Format(layout), now.Add(-time.Hour).Format(layout)}
+	client := server.client(QWeatherOptions{CacheTTL: 20 * time.Millisecond})
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetDailyForecast(3, testLat, testLon); err != nil {
+			t.Fatalf("GetDailyForecast #%d: %v", i+1, err)
+		}
+		time.Sleep(30 * time.Millisecond)
+	}
+	// 缓存有效期已过，但尚未到预计发布时间
+	if _, err := client.GetDailyForecast(3, testLat, testLon); err != nil {
+		t.Fatalf("GetDailyForecast: %v", err)
+	}
+
+	if got := len(server.requestTimes()); got != 2 {
+		t.Errorf("got %d upstream requests, want 2", got)
+	}
+	if stats := client.Stats(); stats.CacheHits != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestQWeatherDailyBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		opts      QWeatherOptions
+		calls     int // DailyForecast 调用次数
+		succeeded int
+		upstream  int
+	}{
+		{
+			name:      "requests",
+			opts:      QWeatherOptions{DailyBudget: 2},
+			calls:     3,
+			succeeded: 2,
+			upstream:  2,
+		},
+		{
+			// 重试同样计入预算
+			name:      "retries",
+			statuses:  []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			opts:      QWeatherOptions{DailyBudget: 2, MaxRetries: 3, RetryBackoff: time.Millisecond},
+			calls:     1,
+			succeeded: 0,
+			upstream:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newQWeatherServer(t, tt.statuses...)
+			client := server.client(tt.opts)
+
+			succeeded := 0
+			var lastErr error
+			for i := 0; i < tt.calls; i++ {
+				if _, err := client.DailyForecast(testLat, testLon, 3); err != nil {
+					lastErr = err
+					continue
+				}
+				succeeded++
+			}
+
+			if succeeded != tt.succeeded {
+				t.Errorf("%d calls succeeded, want %d", succeeded, tt.succeeded)
+			}
+			if !errors.Is(lastErr, ErrBudgetExceeded) {
+				t.Errorf("got error %v, want ErrBudgetExceeded", lastErr)
+			}
+			if got := len(server.requestTimes()); got != tt.upstream {
+				t.Errorf("got %d upstream requests, want %d", got, tt.upstream)
+			}
+			if stats := client.Stats(); stats.Calls != tt.opts.DailyBudget || stats.BudgetRejected != 1 {
+				t.Errorf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestQWeatherRetriesWithBackoff(t *testing.T) {
+	const backoff = 20 * time.Millisecond
+	server := newQWeatherServer(t, http.StatusServiceUnavailable, http.StatusBadGateway)
+	client := server.client(QWeatherOptions{MaxRetries: 3, RetryBackoff: backoff})
+
+	if _, err := client.DailyForecast(testLat, testLon, 3); err != nil {
+		t.Fatalf("DailyForecast: %v", err)
+	}
+
+	times := server.requestTimes()
+	if len(times) != 3 {
+		t.Fatalf("got %d upstream requests, want 3", len(times))
+	}
+	// 等待时间从 RetryBackoff 开始翻倍
+	for i, want := range []time.Duration{backoff, 2 * backoff} {
+		if gap := times[i+1].Sub(times[i]); gap < want {
+			t.Errorf("retry %d after %s, want at least %s", i+1, gap, want)
+		}
+	}
+	if stats := client.Stats(); stats.Retries != 2 || stats.Calls != 3 || stats.Failures != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestQWeatherRetryLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		want     int // 上游请求次数
+	}{
+		{name: "5xx exhausts retries", statuses: []int{500, 500, 500, 500}, want: 3},
+		{name: "429 is retried", statuses: []int{429, 429, 429}, want: 3},
+		{name: "4xx is not retried", statuses: []int{401}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newQWeatherServer(t, tt.statuses...)
+			client := server.client(QWeatherOptions{MaxRetries: 2, RetryBackoff: time.Millisecond})
+
+			if _, err := client.DailyForecast(testLat, testLon, 3); err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got := len(server.requestTimes()); got != tt.want {
+				t.Errorf("got %d upstream requests, want %d", got, tt.want)
+			}
+			if stats := client.Stats(); stats.Failures != 1 || stats.Retries != tt.want-1 {
+				t.Errorf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
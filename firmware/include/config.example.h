@@ -16,9 +16,12 @@
 #define API_ENDPOINT "/api/device/data"
 #define API_CMD_STATUS "/api/device/command/status"
 
-// 设备API密钥（与后端配置一致）
+// 设备API密钥（管理员在后端为本设备分配的专属密钥；未分配时使用后端的共享密钥）
 #define DEVICE_API_KEY "你的设备API密钥"
 
+// 请求签名（HMAC-SHA256 + 时间戳 + 随机数，防止重放）；NTP 时间同步前自动使用明文密钥
+#define USE_REQUEST_SIGNING true
+
 // ============ 引脚定义 ============
 // 传感器引脚
 #define DHT_PIN 4           // DHT11 温湿度传感器